@@ -0,0 +1,120 @@
+// Package admin provides the HTTP API for operating channels at runtime.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"pdn/coordinator"
+	"pdn/database"
+	"time"
+)
+
+// Admin contains the admin server.
+type Admin struct {
+	server      *http.Server
+	handler     http.Handler
+	config      Config
+	database    database.Database
+	coordinator *coordinator.Coordinator
+}
+
+// New creates a new instance of Admin.
+func New(config Config, db database.Database, cod *coordinator.Coordinator) *Admin {
+	a := &Admin{
+		config:      config,
+		database:    db,
+		coordinator: cod,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /channels", a.postChannel)
+	mux.HandleFunc("DELETE /channels/{channelID}", a.deleteChannel)
+	mux.HandleFunc("POST /channels/{channelID}/keys", a.postKey)
+	mux.HandleFunc("GET /channels/{channelID}/policy", a.getPolicy)
+	mux.HandleFunc("PUT /channels/{channelID}/policy", a.putPolicy)
+	mux.HandleFunc("PUT /channels/{channelID}/rebalance", a.putRebalance)
+	mux.HandleFunc("GET /channels/{channelID}/topology", a.getTopology)
+	mux.HandleFunc("GET /channels/{channelID}/offload", a.getOffload)
+	a.handler = a.authorize(mux)
+	a.server = &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.Port),
+		Handler:           a,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return a
+}
+
+// Start runs the admin server. It returns immediately if the admin server is
+// not enabled.
+func (a *Admin) Start() error {
+	if !a.config.Enabled() {
+		log.Printf("Admin server is disabled, no token is given")
+		return nil
+	}
+	log.Printf("Starting admin server on port %d", a.config.Port)
+	if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to start admin server: %w", err)
+	}
+	return nil
+}
+
+// Shutdown stops the admin server after the requests being handled, or when
+// the context is done.
+func (a *Admin) Shutdown(ctx context.Context) error {
+	if err := a.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shutdown admin server: %w", err)
+	}
+	return nil
+}
+
+// ServeHTTP handles the admin API requests.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.handler.ServeHTTP(w, r)
+}
+
+// authorize rejects requests without the bearer token of the admin server.
+func (a *Admin) authorize(next http.Handler) http.Handler {
+	want := []byte("Bearer " + a.config.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes the value as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error occurs in writing admin response %v", err)
+	}
+}
+
+// writeError writes the error as a JSON response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// statusOf returns the HTTP status of the error.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, database.ErrChannelNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrChannelAlreadyExists), errors.Is(err, ErrChannelInUse):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidChannel):
+		return http.StatusBadRequest
+	case errors.Is(err, coordinator.ErrInvalidPolicy):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
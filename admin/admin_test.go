@@ -0,0 +1,151 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"pdn/admin"
+	"pdn/broker"
+	"pdn/coordinator"
+	"pdn/database"
+	"pdn/database/memory"
+	"pdn/metric"
+	"pdn/pool"
+	"strings"
+	"testing"
+)
+
+// TestPolicy tests that the policy of a channel is read and changed through the admin API.
+func TestPolicy(t *testing.T) {
+	const token = "secret"
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "given no token when get policy then return unauthorized",
+			method:     http.MethodGet,
+			path:       "/channels/channel/policy",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "given channel when get policy then return default policy",
+			method:     http.MethodGet,
+			path:       "/channels/channel/policy",
+			token:      token,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"peer_to_peer":true,"max_depth":3,"max_fan_out":0,"min_viewers":0,"duplicate_client":"reject"}`,
+		},
+		{
+			name:       "given partial policy when put policy then keep omitted fields",
+			method:     http.MethodPut,
+			path:       "/channels/channel/policy",
+			token:      token,
+			body:       `{"peer_to_peer":false,"min_viewers":10}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"peer_to_peer":false,"max_depth":3,"max_fan_out":0,"min_viewers":10,"duplicate_client":"reject"}`,
+		},
+		{
+			name:       "given invalid policy when put policy then return bad request",
+			method:     http.MethodPut,
+			path:       "/channels/channel/policy",
+			token:      token,
+			body:       `{"max_depth":0}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "given unknown channel when get policy then return not found",
+			method:     http.MethodGet,
+			path:       "/channels/unknown/policy",
+			token:      token,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.New(database.Config{
+				DefaultPolicy: database.Policy{PeerToPeer: true, MaxDepth: database.DefaultMaxDepth},
+			})
+			key, err := database.NewChannelKey("key")
+			require.NoError(t, err)
+			_, err = db.CreateChannelInfo("channel", key)
+			require.NoError(t, err)
+			strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+			require.NoError(t, err)
+			m := metric.New(metric.Config{})
+			cod := coordinator.New(coordinator.Config{}, broker.New(m), m, db, pool.New(db, strategy))
+			a := admin.New(admin.Config{Port: admin.DefaultPort, Token: token}, db, cod)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			a.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestProvision tests that channels are provisioned, rotated and deleted through the admin API.
+func TestProvision(t *testing.T) {
+	const token = "secret"
+	db := memory.New(database.Config{DefaultPolicy: database.Policy{MaxDepth: database.DefaultMaxDepth}})
+	strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+	require.NoError(t, err)
+	m := metric.New(metric.Config{})
+	cod := coordinator.New(coordinator.Config{}, broker.New(m), m, db, pool.New(db, strategy))
+	a := admin.New(admin.Config{Port: admin.DefaultPort, Token: token}, db, cod)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// given a provisioned channel
+	rec := do(http.MethodPost, "/channels", `{"id":"channel"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created admin.Credential
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.NotEmpty(t, created.Key)
+	channel, err := db.FindChannelInfoByID("channel")
+	require.NoError(t, err)
+	assert.True(t, channel.Provisioned)
+	assert.True(t, channel.Authenticate(created.Key))
+
+	// when the channel is provisioned again then return conflict
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/channels", `{"id":"channel"}`).Code)
+
+	// when the key is rotated then both keys are accepted in the overlap
+	rec = do(http.MethodPost, "/channels/channel/keys", `{"key":"rotated","overlap_seconds":60}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	channel, err = db.FindChannelInfoByID("channel")
+	require.NoError(t, err)
+	assert.True(t, channel.Authenticate("rotated"))
+	assert.True(t, channel.Authenticate(created.Key))
+
+	// when the channel has a client then it is not deleted
+	require.NoError(t, db.CreateClientInfo("channel", "client"))
+	assert.Equal(t, http.StatusConflict, do(http.MethodDelete, "/channels/channel", "").Code)
+
+	// when the channel has no client then it is deleted
+	require.NoError(t, db.DeleteClientInfoByID("channel", "client"))
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/channels/channel", "").Code)
+	_, err = db.FindChannelInfoByID("channel")
+	assert.ErrorIs(t, err, database.ErrChannelNotFound)
+}
@@ -0,0 +1,291 @@
+package admin
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"pdn/database"
+	"pdn/topology"
+	"time"
+)
+
+// Policy is the JSON representation of a channel policy.
+type Policy struct {
+	PeerToPeer bool `json:"peer_to_peer"`
+	MaxDepth   int  `json:"max_depth"`
+	MaxFanOut  int  `json:"max_fan_out"`
+	MinViewers int  `json:"min_viewers"`
+
+	DuplicateClient string `json:"duplicate_client"`
+}
+
+// PolicyUpdate is the request to change a channel policy. Omitted fields keep
+// their current values.
+type PolicyUpdate struct {
+	PeerToPeer *bool `json:"peer_to_peer"`
+	MaxDepth   *int  `json:"max_depth"`
+	MaxFanOut  *int  `json:"max_fan_out"`
+	MinViewers *int  `json:"min_viewers"`
+
+	DuplicateClient *string `json:"duplicate_client"`
+}
+
+// Provision is the request to provision a channel. A random key is generated
+// if the key is omitted.
+type Provision struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// Rotation is the request to add a new key to a channel. A random key is
+// generated if the key is omitted. The old keys are accepted for the overlap,
+// and expire at once if it is zero.
+type Rotation struct {
+	Key            string `json:"key"`
+	OverlapSeconds int    `json:"overlap_seconds"`
+}
+
+// Credential is the key of a channel. It is returned only when the key is
+// added, because only its hash is stored.
+type Credential struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// Rebalance is the request to turn the rebalancing of a channel on or off.
+type Rebalance struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Offload is the JSON representation of the stream delivered to the viewers
+// of a channel by Media server and by peers.
+type Offload struct {
+	ServerBytes         int64   `json:"server_bytes"`
+	PeerBytes           int64   `json:"peer_bytes"`
+	ServerViewerSeconds float64 `json:"server_viewer_seconds"`
+	PeerViewerSeconds   float64 `json:"peer_viewer_seconds"`
+	Ratio               float64 `json:"offload_ratio"`
+}
+
+// toPolicy converts the channel policy to its JSON representation.
+func toPolicy(policy database.Policy) Policy {
+	return Policy{
+		PeerToPeer: policy.PeerToPeer,
+		MaxDepth:   policy.MaxDepth,
+		MaxFanOut:  policy.MaxFanOut,
+		MinViewers: policy.MinViewers,
+
+		DuplicateClient: cmp.Or(policy.DuplicateClient, database.DuplicateReject),
+	}
+}
+
+// apply returns the policy with the given fields changed.
+func (u PolicyUpdate) apply(policy database.Policy) database.Policy {
+	if u.PeerToPeer != nil {
+		policy.PeerToPeer = *u.PeerToPeer
+	}
+	if u.MaxDepth != nil {
+		policy.MaxDepth = *u.MaxDepth
+	}
+	if u.MaxFanOut != nil {
+		policy.MaxFanOut = *u.MaxFanOut
+	}
+	if u.MinViewers != nil {
+		policy.MinViewers = *u.MinViewers
+	}
+	if u.DuplicateClient != nil {
+		policy.DuplicateClient = *u.DuplicateClient
+	}
+	return policy
+}
+
+// generateKey generates a random channel key.
+func generateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to read random key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashKey hashes the given key, or a generated one if it is empty. It returns
+// the key with its hash.
+func hashKey(key string) (string, database.ChannelKey, error) {
+	if key == "" {
+		generated, err := generateKey()
+		if err != nil {
+			return "", database.ChannelKey{}, err
+		}
+		key = generated
+	}
+	hashed, err := database.NewChannelKey(key)
+	if err != nil {
+		return "", database.ChannelKey{}, err
+	}
+	return key, hashed, nil
+}
+
+// postChannel provisions a new channel.
+func (a *Admin) postChannel(w http.ResponseWriter, r *http.Request) {
+	var provision Provision
+	if err := json.NewDecoder(r.Body).Decode(&provision); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to decode channel: %w", err))
+		return
+	}
+	if provision.ID == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: id is required", ErrInvalidChannel))
+		return
+	}
+	key, hashed, err := hashKey(provision.Key)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	channel, err := a.database.CreateChannelInfo(provision.ID, hashed)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, Credential{ID: channel.ID, Key: key})
+}
+
+// deleteChannel deletes the channel. A channel with clients is not deleted.
+func (a *Admin) deleteChannel(w http.ResponseWriter, r *http.Request) {
+	channel, err := a.database.FindChannelInfoByID(r.PathValue("channelID"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	clients, err := a.database.FindAllClientInfosByChannelID(channel.ID)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if len(clients) > 0 {
+		writeError(w, http.StatusConflict, fmt.Errorf("%w: %d clients", ErrChannelInUse, len(clients)))
+		return
+	}
+	if err := a.database.DeleteChannelInfoByID(channel.ID); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postKey rotates the key of the channel.
+func (a *Admin) postKey(w http.ResponseWriter, r *http.Request) {
+	var rotation Rotation
+	if err := json.NewDecoder(r.Body).Decode(&rotation); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to decode rotation: %w", err))
+		return
+	}
+	if rotation.OverlapSeconds < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: overlap must not be negative", ErrInvalidChannel))
+		return
+	}
+	channel, err := a.database.FindChannelInfoByID(r.PathValue("channelID"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	key, hashed, err := hashKey(rotation.Key)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	overlap := time.Duration(rotation.OverlapSeconds) * time.Second
+	if _, err := a.database.UpdateChannelKeys(channel.ID, channel.RotateKey(hashed, overlap, time.Now())); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Credential{ID: channel.ID, Key: key})
+}
+
+// getPolicy returns the policy of the channel.
+func (a *Admin) getPolicy(w http.ResponseWriter, r *http.Request) {
+	channel, err := a.database.FindChannelInfoByID(r.PathValue("channelID"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPolicy(channel.Policy))
+}
+
+// putPolicy changes the policy of the channel.
+func (a *Admin) putPolicy(w http.ResponseWriter, r *http.Request) {
+	var update PolicyUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to decode policy: %w", err))
+		return
+	}
+	channel, err := a.database.FindChannelInfoByID(r.PathValue("channelID"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	channel, err = a.coordinator.SetChannelPolicy(channel.ID, update.apply(channel.Policy))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPolicy(channel.Policy))
+}
+
+// putRebalance turns the rebalancing of the channel on or off.
+func (a *Admin) putRebalance(w http.ResponseWriter, r *http.Request) {
+	var rebalance Rebalance
+	if err := json.NewDecoder(r.Body).Decode(&rebalance); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to decode rebalance: %w", err))
+		return
+	}
+	channelID := r.PathValue("channelID")
+	if err := a.coordinator.SetRebalance(channelID, rebalance.Enabled); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rebalance)
+}
+
+// getTopology returns the delivery tree of the channel. It is rendered in
+// Graphviz DOT if the format query is dot, and in JSON otherwise.
+func (a *Admin) getTopology(w http.ResponseWriter, r *http.Request) {
+	channel, err := a.database.FindChannelInfoByID(r.PathValue("channelID"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	t, err := topology.Build(a.database, channel.ID)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		if _, err := w.Write([]byte(t.DOT())); err != nil {
+			log.Printf("Error occurs in writing admin response %v", err)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// getOffload returns the stream delivered to the viewers of the channel so far.
+func (a *Admin) getOffload(w http.ResponseWriter, r *http.Request) {
+	channel, err := a.database.FindChannelInfoByID(r.PathValue("channelID"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	offload := a.coordinator.Offload(channel.ID)
+	writeJSON(w, http.StatusOK, Offload{
+		ServerBytes:         offload.ServerBytes,
+		PeerBytes:           offload.PeerBytes,
+		ServerViewerSeconds: offload.ServerTime.Seconds(),
+		PeerViewerSeconds:   offload.PeerTime.Seconds(),
+		Ratio:               offload.Ratio(),
+	})
+}
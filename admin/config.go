@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultPort is the default port number for the admin server.
+const DefaultPort = 7071
+
+var (
+	// ErrInvalidPort is returned when the port of the admin server is invalid.
+	ErrInvalidPort = errors.New("invalid port")
+
+	// ErrInvalidChannel is returned when the channel to provision is invalid.
+	ErrInvalidChannel = errors.New("invalid channel")
+
+	// ErrChannelInUse is returned when the channel to delete has clients.
+	ErrChannelInUse = errors.New("channel in use")
+)
+
+// Config is the configuration for the admin server. The admin server is
+// started only if Token is set, and every request must carry it as a bearer
+// token.
+type Config struct {
+	Port  int
+	Token string
+}
+
+// Enabled checks if the admin server should be started.
+func (c Config) Enabled() bool {
+	return c.Token != ""
+}
+
+// Validate validates the port number of the admin server.
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("must be between 1 and 65535, given %d: %w", c.Port, ErrInvalidPort)
+	}
+	return nil
+}
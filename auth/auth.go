@@ -0,0 +1,196 @@
+// Package auth verifies the signed tokens that clients activate with. A token
+// is a JWT signed with HS256, RS256 or EdDSA, and its claims give the client
+// a role in a channel.
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Algorithms of the signature of a token.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// Roles of a client in a channel.
+const (
+	RolePublisher = "publisher"
+	RoleViewer    = "viewer"
+	RoleModerator = "moderator"
+)
+
+// leeway is the allowed clock skew between the issuer and the server.
+const leeway = 30 * time.Second
+
+// ErrInvalidToken is returned when the token is malformed, badly signed or
+// not valid now.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Limits are the limits of a client given in its token. Zero values mean no
+// limit.
+type Limits struct {
+	// MaxUploadBandwidth caps the upload bandwidth in kbps the client reports,
+	// so the client forwards to fewer fetchers.
+	MaxUploadBandwidth int `json:"max_upload_bandwidth"`
+
+	// ForwardingDisabled makes the client never forward the stream.
+	ForwardingDisabled bool `json:"forwarding_disabled"`
+}
+
+// Claims are the claims of a token. A token without the client is valid for
+// any client in the channel.
+type Claims struct {
+	Channel   string `json:"channel"`
+	Client    string `json:"client"`
+	Role      string `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+	Limits    Limits `json:"limits"`
+}
+
+// Validate validates the claims at the given time.
+func (c Claims) Validate(now time.Time) error {
+	if c.Channel == "" {
+		return fmt.Errorf("%w: no channel", ErrInvalidToken)
+	}
+	if !slices.Contains([]string{RolePublisher, RoleViewer, RoleModerator}, c.Role) {
+		return fmt.Errorf("%w: unknown role %q", ErrInvalidToken, c.Role)
+	}
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if now.Add(-leeway).After(time.Unix(c.ExpiresAt, 0)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+// header is the header of a token.
+type header struct {
+	Algorithm string `json:"alg"`
+}
+
+// Verifier verifies tokens with the configured keys.
+type Verifier struct {
+	config Config
+	secret []byte
+	rsa    *rsa.PublicKey
+	ed     ed25519.PublicKey
+}
+
+// New creates a new Verifier with the keys of the config.
+func New(config Config) (*Verifier, error) {
+	v := &Verifier{
+		config: config,
+		secret: []byte(config.HS256Secret),
+	}
+	if config.RS256PublicKeyFile != "" {
+		key, err := readRSAPublicKey(config.RS256PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsa = key
+	}
+	if config.EdDSAPublicKeyFile != "" {
+		key, err := readEd25519PublicKey(config.EdDSAPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.ed = key
+	}
+	return v, nil
+}
+
+// Required checks if every client needs a token.
+func (v *Verifier) Required() bool {
+	return v.config.Required
+}
+
+// KeyRole returns the role of the clients activating with the channel key.
+func (v *Verifier) KeyRole() string {
+	if v.config.KeyPublishes {
+		return RolePublisher
+	}
+	return RoleViewer
+}
+
+// Verify verifies the signature of the token and returns its claims.
+func (v *Verifier) Verify(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var h header
+	if err := decode(parts[0], &h); err != nil {
+		return Claims{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	if err := v.verify(h.Algorithm, parts[0]+"."+parts[1], sig); err != nil {
+		return Claims{}, err
+	}
+
+	var claims Claims
+	if err := decode(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+	if err := claims.Validate(now); err != nil {
+		return Claims{}, err
+	}
+	return claims, nil
+}
+
+// verify verifies the signature of the signed part by the algorithm. The
+// algorithms without the configured key are rejected.
+func (v *Verifier) verify(algorithm, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch {
+	case algorithm == HS256 && len(v.secret) > 0:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case algorithm == RS256 && v.rsa != nil:
+		if err := rsa.VerifyPKCS1v15(v.rsa, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case algorithm == EdDSA && v.ed != nil:
+		if !ed25519.Verify(v.ed, []byte(signed), sig) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, algorithm)
+	}
+	return nil
+}
+
+// decode decodes the base64url encoded JSON part of a token.
+func decode(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	return nil
+}
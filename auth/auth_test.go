@@ -0,0 +1,165 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"pdn/auth"
+	"testing"
+	"time"
+)
+
+// writeKey writes the public key to a PEM file and returns its path.
+func writeKey(t *testing.T, name string, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	return path
+}
+
+// encode encodes the value as a part of a token.
+func encode(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestVerify(t *testing.T) {
+	const secret = "secret"
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	verifier, err := auth.New(auth.Config{
+		HS256Secret:        secret,
+		RS256PublicKeyFile: writeKey(t, "rsa.pem", &rsaKey.PublicKey),
+		EdDSAPublicKeyFile: writeKey(t, "ed25519.pem", edPublic),
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	valid := auth.Claims{
+		Channel:   "channel",
+		Client:    "client",
+		Role:      auth.RoleViewer,
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Limits:    auth.Limits{ForwardingDisabled: true},
+	}
+	sign := func(algorithm string, claims auth.Claims) string {
+		signed := encode(t, map[string]string{"alg": algorithm, "typ": "JWT"}) + "." + encode(t, claims)
+		var sig []byte
+		switch algorithm {
+		case auth.HS256:
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(signed))
+			sig = mac.Sum(nil)
+		case auth.RS256:
+			digest := sha256.Sum256([]byte(signed))
+			sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+			require.NoError(t, err)
+		case auth.EdDSA:
+			sig = ed25519.Sign(edPrivate, []byte(signed))
+		}
+		return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "given HS256 token when verify then return claims",
+			token: sign(auth.HS256, valid),
+		},
+		{
+			name:  "given RS256 token when verify then return claims",
+			token: sign(auth.RS256, valid),
+		},
+		{
+			name:  "given EdDSA token when verify then return claims",
+			token: sign(auth.EdDSA, valid),
+		},
+		{
+			name:    "given token without signature when verify then return error",
+			token:   sign("none", valid),
+			wantErr: true,
+		},
+		{
+			name:    "given tampered token when verify then return error",
+			token:   sign(auth.HS256, valid)[:10] + "x" + sign(auth.HS256, valid)[11:],
+			wantErr: true,
+		},
+		{
+			name: "given expired token when verify then return error",
+			token: sign(auth.HS256, auth.Claims{
+				Channel: "channel", Role: auth.RoleViewer, ExpiresAt: now.Add(-time.Hour).Unix(),
+			}),
+			wantErr: true,
+		},
+		{
+			name:    "given token without expiry when verify then return error",
+			token:   sign(auth.HS256, auth.Claims{Channel: "channel", Role: auth.RoleViewer}),
+			wantErr: true,
+		},
+		{
+			name: "given unknown role when verify then return error",
+			token: sign(auth.HS256, auth.Claims{
+				Channel: "channel", Role: "admin", ExpiresAt: now.Add(time.Hour).Unix(),
+			}),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(tt.token, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, auth.ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, valid, claims)
+		})
+	}
+}
+
+func TestKeyRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		config auth.Config
+		want   string
+	}{
+		{
+			name: "given default config when key activates then give viewer",
+			want: auth.RoleViewer,
+		},
+		{
+			name:   "given key publishes when key activates then give publisher",
+			config: auth.Config{KeyPublishes: true},
+			want:   auth.RolePublisher,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := auth.New(tt.config)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, verifier.KeyRole())
+		})
+	}
+}
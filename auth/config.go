@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrInvalidKeyFile is returned when the public key file can't be read.
+var ErrInvalidKeyFile = errors.New("invalid public key file")
+
+// Config is the configuration of the keys verifying tokens. A token is
+// accepted only if the key of its algorithm is configured.
+type Config struct {
+	// HS256Secret is the shared secret of HS256 tokens.
+	HS256Secret string
+
+	// RS256PublicKeyFile is the PEM file of the RSA public key of RS256 tokens.
+	RS256PublicKeyFile string
+
+	// EdDSAPublicKeyFile is the PEM file of the Ed25519 public key of EdDSA tokens.
+	EdDSAPublicKeyFile string
+
+	// Required rejects clients activating with the channel key, so that every
+	// client needs a token.
+	Required bool
+
+	// KeyPublishes gives the clients activating with the channel key the
+	// publisher role. Otherwise they are viewers, and pushing the stream needs
+	// a publisher token. Turn it on only to keep the publishers that predate
+	// tokens working.
+	KeyPublishes bool
+}
+
+// Enabled checks if any key verifying tokens is configured.
+func (c Config) Enabled() bool {
+	return c.HS256Secret != "" || c.RS256PublicKeyFile != "" || c.EdDSAPublicKeyFile != ""
+}
+
+// Validate validates that tokens can be verified if they are required.
+func (c Config) Validate() error {
+	if c.Required && !c.Enabled() {
+		return errors.New("tokens are required, but no key verifying them is configured")
+	}
+	return nil
+}
+
+// readPublicKey reads the PKIX public key in the PEM file.
+func readPublicKey(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to access %s: %w", path, ErrInvalidKeyFile)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s: %w", path, ErrInvalidKeyFile)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w: %w", path, ErrInvalidKeyFile, err)
+	}
+	return key, nil
+}
+
+// readRSAPublicKey reads the RSA public key in the PEM file.
+func readRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	key, err := readPublicKey(path)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an RSA public key: %w", path, ErrInvalidKeyFile)
+	}
+	return rsaKey, nil
+}
+
+// readEd25519PublicKey reads the Ed25519 public key in the PEM file.
+func readEd25519PublicKey(path string) (ed25519.PublicKey, error) {
+	key, err := readPublicKey(path)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key: %w", path, ErrInvalidKeyFile)
+	}
+	return edKey, nil
+}
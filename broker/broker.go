@@ -2,9 +2,11 @@
 package broker
 
 import (
+	"errors"
 	"fmt"
 	"pdn/broker/channel"
 	"pdn/broker/subscription"
+	"pdn/metric"
 	"sync"
 )
 
@@ -35,18 +37,43 @@ const (
 	FAILED       Detail = "FAILED"
 	CLEAR        Detail = "CLEAR"
 	CLOSE        Detail = "CLOSE"
+	UPDATE       Detail = "UPDATE"
+	HEALTH       Detail = "HEALTH"
+	OFFERED      Detail = "OFFERED"
+	ANSWERED     Detail = "ANSWERED"
+	CONNECTING   Detail = "CONNECTING"
+	STATS        Detail = "STATS"
 )
 
+// Route is the topic and the detail that a message is published to.
+type Route struct {
+	Topic  Topic
+	Detail Detail
+}
+
+// Event is a message received through a subscription to several routes, with
+// the route it is published to.
+type Event struct {
+	Route
+	Message any
+}
+
+// ErrClosed is returned when the broker is closed.
+var ErrClosed = errors.New("broker closed")
+
 // Broker is a message broker that manages message channels and subscriptions.
 type Broker struct {
 	mu       sync.RWMutex
 	channels map[Topic]map[Detail]*channel.Channel
+	closed   bool
+	metric   *metric.Metrics
 }
 
 // New creates a new broker instance.
-func New() *Broker {
+func New(m *metric.Metrics) *Broker {
 	return &Broker{
 		channels: make(map[Topic]map[Detail]*channel.Channel),
+		metric:   m,
 	}
 }
 
@@ -57,11 +84,12 @@ func (b *Broker) Publish(topic Topic, detail Detail, message any) error {
 		return err
 	}
 
-	ch.SendAll(message)
+	ch.SendAll(message, Event{Route: Route{Topic: topic, Detail: detail}, Message: message})
 	return nil
 }
 
-// Subscribe creates a subscription for a given topic and detail.
+// Subscribe creates a subscription for a given topic and detail. The
+// subscription to the closed broker is closed at once.
 func (b *Broker) Subscribe(topic Topic, detail Detail) *subscription.Subscription {
 	b.ensureChannel(topic, detail)
 
@@ -69,10 +97,58 @@ func (b *Broker) Subscribe(topic Topic, detail Detail) *subscription.Subscriptio
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
+	if b.closed {
+		sub.Close()
+		return sub
+	}
 	b.channels[topic][detail].AddSubscription(sub)
 	return sub
 }
 
+// SubscribeRoutes creates a subscription to all the routes. The messages are
+// received as Event in the order they are published, so the messages that one
+// publisher sends to different routes are never reordered. The subscription
+// to the closed broker is closed at once.
+func (b *Broker) SubscribeRoutes(routes ...Route) *subscription.Subscription {
+	for _, route := range routes {
+		b.ensureChannel(route.Topic, route.Detail)
+	}
+
+	sub := subscription.NewRouted()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		sub.Close()
+		return sub
+	}
+	for _, route := range routes {
+		b.channels[route.Topic][route.Detail].AddSubscription(sub)
+	}
+	return sub
+}
+
+// Close closes all the subscriptions, so that their receivers stop. Messages
+// published after it are rejected.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// NOTE: A subscription to several routes is closed only after it is
+	// removed from all of them, so that no channel sends to it after closed.
+	var subs []*subscription.Subscription
+	for _, details := range b.channels {
+		for _, ch := range details {
+			subs = append(subs, ch.Clear()...)
+		}
+	}
+	for _, sub := range subs {
+		sub.Close()
+	}
+	b.channels = make(map[Topic]map[Detail]*channel.Channel)
+	b.closed = true
+}
+
 // Unsubscribe removes a subscription for a given topic and detail.
 func (b *Broker) Unsubscribe(topic Topic, detail Detail, sub *subscription.Subscription) error {
 	ch, err := b.getChannel(topic, detail)
@@ -89,11 +165,14 @@ func (b *Broker) ensureChannel(topic Topic, detail Detail) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if b.closed {
+		return
+	}
 	if _, exists := b.channels[topic]; !exists {
 		b.channels[topic] = make(map[Detail]*channel.Channel)
 	}
 	if _, exists := b.channels[topic][detail]; !exists {
-		b.channels[topic][detail] = channel.New(topic.String(), string(detail))
+		b.channels[topic][detail] = channel.New(topic.String(), string(detail), b.metric)
 	}
 }
 
@@ -102,6 +181,9 @@ func (b *Broker) getChannel(topic Topic, detail Detail) (*channel.Channel, error
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
+	if b.closed {
+		return nil, ErrClosed
+	}
 	if details, exists := b.channels[topic]; exists {
 		if ch, exists := details[detail]; exists {
 			return ch, nil
@@ -119,6 +201,8 @@ func (t Topic) String() string {
 		return "Client"
 	case Media:
 		return "Media"
+	case Peer:
+		return "Peer"
 	default:
 		return "Unknown"
 	}
@@ -0,0 +1,50 @@
+package broker_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pdn/broker"
+	"pdn/metric"
+	"testing"
+	"time"
+)
+
+// TestSubscribeRoutesBuffersBurst tests that a subscription to several routes
+// keeps a burst of events published while its receiver is busy, in the order
+// they are published, instead of dropping them after the timeout.
+func TestSubscribeRoutesBuffersBurst(t *testing.T) {
+	const events = 100
+
+	b := broker.New(metric.New(metric.Config{}))
+	sub := b.SubscribeRoutes(
+		broker.Route{Topic: broker.Client, Detail: broker.ACTIVATE},
+		broker.Route{Topic: broker.Client, Detail: broker.DEACTIVATE},
+	)
+
+	// given the receiver is busy
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := range events {
+			detail := broker.ACTIVATE
+			if i%2 == 1 {
+				detail = broker.DEACTIVATE
+			}
+			assert.NoError(t, b.Publish(broker.Client, detail, i))
+		}
+	}()
+
+	// when the burst is published
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publishers are blocked by the busy receiver")
+	}
+
+	// then the receiver gets all the events in order
+	for i := range events {
+		e, ok := (<-sub.Receive()).(broker.Event)
+		require.True(t, ok)
+		assert.Equal(t, i, e.Message)
+	}
+}
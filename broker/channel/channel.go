@@ -4,6 +4,7 @@ package channel
 import (
 	"log"
 	"pdn/broker/subscription"
+	"pdn/metric"
 	"sync"
 	"time"
 )
@@ -14,27 +15,36 @@ type Channel struct {
 	topic  string
 	detail string
 	subs   []*subscription.Subscription
+	metric *metric.Metrics
 }
 
 // New creates a new Channel instance.
-func New(topic, detail string) *Channel {
+func New(topic, detail string, m *metric.Metrics) *Channel {
 	return &Channel{
 		topic:  topic,
 		detail: detail,
 		subs:   make([]*subscription.Subscription, 0),
+		metric: m,
 	}
 }
 
-// SendAll sends a message to all Channel.
-func (c *Channel) SendAll(message any) {
+// SendAll sends a message to all Channel. The subscriptions to several routes
+// receive the routed message instead. A message that a subscription doesn't
+// receive in time is dropped and counted.
+func (c *Channel) SendAll(message, routed any) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	for _, sub := range c.subs {
+		msg := message
+		if sub.Routed() {
+			msg = routed
+		}
 		select {
-		case sub.Send() <- message:
+		case sub.Send() <- msg:
 		case <-time.After(1 * time.Second):
 			log.Printf("Timeout occurs in sending message to Topic: %s, Detail: %s, Message:%v", c.topic, c.detail, message)
+			c.metric.IncrementDroppedMessages(c.topic, c.detail)
 		}
 	}
 }
@@ -47,6 +57,16 @@ func (c *Channel) AddSubscription(sub *subscription.Subscription) {
 	c.subs = append(c.subs, sub)
 }
 
+// Clear removes all the subscriptions of the channel and returns them.
+func (c *Channel) Clear() []*subscription.Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := c.subs
+	c.subs = nil
+	return subs
+}
+
 // RemoveSubscription removes a Subscription Channel.
 func (c *Channel) RemoveSubscription(sub *subscription.Subscription) {
 	c.mu.Lock()
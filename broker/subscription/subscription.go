@@ -1,9 +1,18 @@
 // Package subscription manages message subscriptions.
 package subscription
 
+import "sync"
+
+// routedQueueSize is the number of messages a subscription to several routes
+// buffers. It receives the events of all its routes, so it has to absorb
+// bursts, e.g. the deactivations of a whole channel at once.
+const routedQueueSize = 1024
+
 // Subscription is a message subscription.
 type Subscription struct {
-	queue chan any
+	queue  chan any
+	routed bool
+	once   sync.Once
 }
 
 // New creates a new subscription instance.
@@ -13,6 +22,21 @@ func New() *Subscription {
 	}
 }
 
+// NewRouted creates a new subscription to several routes. Its messages are
+// wrapped with the route they are published to, and buffered up to
+// routedQueueSize.
+func NewRouted() *Subscription {
+	return &Subscription{
+		queue:  make(chan any, routedQueueSize),
+		routed: true,
+	}
+}
+
+// Routed checks if the messages are wrapped with their routes.
+func (s *Subscription) Routed() bool {
+	return s.routed
+}
+
 // Send sends a message to the subscription.
 func (s *Subscription) Send() chan<- any {
 	return s.queue
@@ -23,7 +47,9 @@ func (s *Subscription) Receive() <-chan any {
 	return s.queue
 }
 
-// Close closes the subscription.
+// Close closes the subscription. Closing it again does nothing.
 func (s *Subscription) Close() {
-	close(s.queue)
+	s.once.Do(func() {
+		close(s.queue)
+	})
 }
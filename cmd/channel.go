@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"pdn/admin"
+	"time"
+)
+
+// Actions of the channel provisioning tool.
+const (
+	ActionCreate = "create"
+	ActionDelete = "delete"
+	ActionRotate = "rotate"
+)
+
+// ChannelCommand is a request to the admin API to provision a channel.
+type ChannelCommand struct {
+	Action  string
+	Admin   string
+	Token   string
+	ID      string
+	Key     string
+	Overlap time.Duration
+}
+
+// Channel provisions channels through the admin API and prints the key.
+func Channel() {
+	command, err := ParseChannel(os.Stderr, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := command.Do(http.DefaultClient, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// ParseChannel parses the command line arguments of the channel provisioning
+// tool. The first argument is the action, followed by its flags.
+func ParseChannel(w io.Writer, args []string) (ChannelCommand, error) {
+	usage := fmt.Sprintf("usage: channel %s|%s|%s -id <channel id> [flags]", ActionCreate, ActionDelete, ActionRotate)
+	if len(args) == 0 {
+		return ChannelCommand{}, errors.New(usage)
+	}
+	command := ChannelCommand{Action: args[0]}
+	switch command.Action {
+	case ActionCreate, ActionDelete, ActionRotate:
+	default:
+		return ChannelCommand{}, fmt.Errorf("unknown action %q, %s", command.Action, usage)
+	}
+
+	fs := flag.NewFlagSet("channel "+command.Action, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.StringVar(&command.Admin, "admin", fmt.Sprintf("http://localhost:%d", admin.DefaultPort), "address of admin api")
+	fs.StringVar(&command.Token, "token", os.Getenv("ADMIN_TOKEN"), "bearer token of admin api")
+	fs.StringVar(&command.ID, "id", "", "channel id")
+	fs.StringVar(&command.Key, "key", "", "channel key to set, generated if empty")
+	fs.DurationVar(&command.Overlap, "overlap", 0, "duration that the old keys are still accepted after rotation")
+	if err := fs.Parse(args[1:]); err != nil {
+		return ChannelCommand{}, fmt.Errorf("failed to parse args: %w", err)
+	}
+
+	if fs.NArg() != 0 {
+		return ChannelCommand{}, errors.New("some args are not parsed")
+	}
+	if command.ID == "" {
+		return ChannelCommand{}, errors.New(usage)
+	}
+	return command, nil
+}
+
+// Do sends the command to the admin API and writes the key of the channel, if
+// a key is added.
+func (c ChannelCommand) Do(client *http.Client, w io.Writer) error {
+	var method, path string
+	var body any
+	switch c.Action {
+	case ActionCreate:
+		method, path = http.MethodPost, "/channels"
+		body = admin.Provision{ID: c.ID, Key: c.Key}
+	case ActionDelete:
+		method, path = http.MethodDelete, "/channels/"+url.PathEscape(c.ID)
+	case ActionRotate:
+		method, path = http.MethodPost, "/channels/"+url.PathEscape(c.ID)+"/keys"
+		body = admin.Rotation{Key: c.Key, OverlapSeconds: int(c.Overlap.Seconds())}
+	}
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+	req, err := http.NewRequest(method, c.Admin+path, &payload)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request admin api: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("admin api returned %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	if res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	var credential admin.Credential
+	if err := json.NewDecoder(res.Body).Decode(&credential); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "channel: %s\nkey: %s\n", credential.ID, credential.Key)
+	return err
+}
@@ -0,0 +1,8 @@
+// Package main is entrypoint for the channel provisioning tool
+package main
+
+import "pdn/cmd"
+
+func main() {
+	cmd.Channel()
+}
@@ -2,28 +2,45 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	ossignal "os/signal"
+	"pdn/admin"
+	"pdn/auth"
 	"pdn/coordinator"
 	"pdn/database"
 	"pdn/media"
 	"pdn/metric"
 	"pdn/pdn"
 	"pdn/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-// Run starts the application.
+// Run starts the application, and shuts it down on SIGTERM or interrupt.
 func Run() {
 	config, err := SetupConfig(os.Stdout, os.Args[1:])
 	if err != nil {
+		log.Printf("error occurs in setting up config %v", err)
 		os.Exit(1)
 	}
 
-	p := pdn.New(config)
-	if err = p.Start(); err != nil {
+	p, err := pdn.New(config)
+	if err != nil {
+		log.Printf("error occurs in creating PDN %v", err)
+		os.Exit(1)
+	}
+	ctx, stop := ossignal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if err = p.Start(ctx); err != nil {
+		log.Printf("error occurs in running PDN %v", err)
 		os.Exit(1)
 	}
 }
@@ -37,6 +54,21 @@ func SetupConfig(w io.Writer, args []string) (pdn.Config, error) {
 	if err = config.Signal.Validate(); err != nil {
 		return config, err
 	}
+	if err = config.Database.DefaultPolicy.Validate(); err != nil {
+		return config, err
+	}
+	if err = config.Coordinator.Validate(); err != nil {
+		return config, err
+	}
+	if err = config.Admin.Validate(); err != nil {
+		return config, err
+	}
+	if err = config.Auth.Validate(); err != nil {
+		return config, err
+	}
+	if err = config.Validate(); err != nil {
+		return config, err
+	}
 	return config, nil
 }
 
@@ -47,22 +79,124 @@ func Parse(w io.Writer, args []string) (pdn.Config, error) {
 	cor := coordinator.Config{}
 	met := metric.Config{}
 	med := media.Config{}
+	adm := admin.Config{}
+	ath := auth.Config{}
+	var shutdownTimeout, stopTimeout time.Duration
 	fs := flag.NewFlagSet("config", flag.ContinueOnError)
 	fs.SetOutput(w)
 	fs.IntVar(&sig.Port, "port", signal.DefaultPort, "listening port")
 	fs.BoolVar(&sig.Debug, "debug", false, "debug mode")
 	fs.StringVar(&sig.KeyFile, "key", "", "key file path")
 	fs.StringVar(&sig.CertFile, "cert", "", "cert file path")
-	fs.BoolVar(&db.SetDefaultChannel, "setDefaultChannel", false, "set default channel for debug or test")
+	fs.DurationVar(&sig.PingInterval, "pingInterval", signal.DefaultPingInterval, "websocket ping interval")
+	fs.DurationVar(&sig.IdleTimeout, "idleTimeout", signal.DefaultIdleTimeout, "websocket idle timeout")
+	fs.DurationVar(&sig.ResumeGracePeriod, "resumeGracePeriod", signal.DefaultResumeGracePeriod,
+		"duration that a disconnected client can resume its session in, 0 to disable")
+	fs.Float64Var(&sig.ConnectionLimit.Rate, "connectionRate", signal.DefaultConnectionLimit.Rate,
+		"connection attempts per second allowed per IP, 0 to disable")
+	fs.IntVar(&sig.ConnectionLimit.Burst, "connectionBurst", signal.DefaultConnectionLimit.Burst,
+		"burst of connection attempts allowed per IP")
+	fs.Float64Var(&sig.AuthFailureLimit.Rate, "authFailureRate", signal.DefaultAuthFailureLimit.Rate,
+		"failed authentications per second allowed per IP, 0 to disable")
+	fs.IntVar(&sig.AuthFailureLimit.Burst, "authFailureBurst", signal.DefaultAuthFailureLimit.Burst,
+		"burst of failed authentications allowed per IP")
+	fs.Float64Var(&sig.RequestLimit.Rate, "requestRate", signal.DefaultRequestLimit.Rate,
+		"requests per second allowed per client and request type, 0 to disable")
+	fs.IntVar(&sig.RequestLimit.Burst, "requestBurst", signal.DefaultRequestLimit.Burst,
+		"burst of requests allowed per client and request type")
+	fs.Float64Var(&sig.JoinLimit.Rate, "joinRate", signal.DefaultJoinLimit.Rate,
+		"activations per second allowed per channel, 0 to disable")
+	fs.IntVar(&sig.JoinLimit.Burst, "joinBurst", signal.DefaultJoinLimit.Burst,
+		"burst of activations allowed per channel")
+	fs.IntVar(&sig.MaxViolations, "maxViolations", signal.DefaultMaxViolations,
+		"rate limit violations that a client is disconnected after, 0 to never disconnect")
+	fs.Func("allowedOrigins", "comma separated origins allowed to open websockets besides the same origin, * for any",
+		func(s string) error {
+			sig.AllowedOrigins = splitList(s)
+			return nil
+		})
+	fs.Int64Var(&sig.MaxMessageSize, "maxMessageSize", signal.DefaultMaxMessageSize,
+		"max size in bytes of a websocket message from a client, 0 for no limit")
+	fs.DurationVar(&sig.WriteTimeout, "writeTimeout", signal.DefaultWriteTimeout,
+		"duration that a websocket message must be written in, 0 for no deadline")
+	fs.BoolVar(&sig.EnableCompression, "compression", false, "negotiate permessage-deflate with clients")
+	fs.Func("subprotocols", "comma separated websocket subprotocols in the order of preference",
+		func(s string) error {
+			sig.Subprotocols = splitList(s)
+			return nil
+		})
+	fs.StringVar(&sig.MoveURL, "moveURL", "",
+		"url that clients are told to move to on shutdown, empty to reconnect to the same address")
+	fs.DurationVar(&shutdownTimeout, "shutdownTimeout", pdn.DefaultShutdownTimeout,
+		"duration that clients are given to move to another server and components to stop in on shutdown")
+	fs.DurationVar(&stopTimeout, "stopTimeout", pdn.DefaultStopTimeout,
+		"duration kept out of shutdownTimeout for components to stop in")
+	fs.BoolVar(&db.SetDefaultChannel, "setDefaultChannel", envBool("DEFAULT_CHANNEL"),
+		"set default channel for debug or test")
+	fs.BoolVar(&db.AutoCreateChannels, "autoCreateChannels", envBool("AUTO_CREATE_CHANNELS"),
+		"create any channel a client asks for with the channel ID as its key, for debug or test")
 	fs.IntVar(&cor.MaxForwardingNumber, "maxForwardingNumber",
-		coordinator.DefaultMaxForwardingNumber, "max forwarding number")
-	fs.BoolVar(&cor.SetPeerConnection, "setPeerConnection",
-		coordinator.DefaultSetPeerConnection, "set peer assisted delivery network mode")
+		coordinator.DefaultMaxForwardingNumber, "max forwarding number of clients without reported bandwidth")
+	fs.IntVar(&cor.MaxForwardingCap, "maxForwardingCap",
+		coordinator.DefaultMaxForwardingCap, "hard ceiling of forwarding number of a client")
+	fs.IntVar(&cor.StreamBitrate, "streamBitrate", coordinator.DefaultStreamBitrate, "expected stream bitrate in kbps")
+	fs.BoolVar(&db.DefaultPolicy.PeerToPeer, "setPeerConnection", false,
+		"set peer assisted delivery network mode of new channels")
+	fs.IntVar(&db.DefaultPolicy.MaxDepth, "maxDepth", database.DefaultMaxDepth,
+		"max delivery depth from media server of new channels")
+	fs.IntVar(&db.DefaultPolicy.MaxFanOut, "maxFanOut", 0,
+		"max forwarding number of a client in new channels, 0 for no channel limit")
+	fs.IntVar(&db.DefaultPolicy.MinViewers, "minViewers", 0,
+		"min viewers of new channels before peer assisted delivery starts")
+	fs.StringVar(&db.DefaultPolicy.DuplicateClient, "duplicateClient", database.DuplicateReject,
+		"policy of new channels for a client activating with the ID of an active client: reject, kick or unique")
+	fs.StringVar(&cor.Strategy, "strategy", coordinator.DefaultStrategy,
+		"forwarder selection strategy: least-loaded, bandwidth, rtt, random or round-robin")
+	fs.Float64Var(&cor.MaxPacketLoss, "maxPacketLoss", coordinator.DefaultMaxPacketLoss,
+		"max packet loss ratio of a healthy peer connection")
+	fs.DurationVar(&cor.MaxRTT, "maxRTT", coordinator.DefaultMaxRTT, "max round trip time of a healthy peer connection")
+	fs.IntVar(&cor.SuperPeerCap, "superPeerCap", coordinator.DefaultSuperPeerCap,
+		"hard ceiling of forwarding number of a super-peer")
+	fs.DurationVar(&cor.SuperPeerUptime, "superPeerUptime", coordinator.DefaultSuperPeerUptime,
+		"min uptime to be a super-peer")
+	fs.Float64Var(&cor.SuperPeerQuality, "superPeerQuality", coordinator.DefaultSuperPeerQuality,
+		"min upload quality to be a super-peer")
+	fs.IntVar(&cor.SuperPeerCapacity, "superPeerCapacity", coordinator.DefaultSuperPeerCapacity,
+		"min forwarding capacity to be a super-peer")
+	fs.DurationVar(&cor.SuperPeerInterval, "superPeerInterval", coordinator.DefaultSuperPeerInterval,
+		"interval of evaluating super-peer candidates")
+	fs.DurationVar(&cor.RebalanceInterval, "rebalanceInterval", coordinator.DefaultRebalanceInterval,
+		"interval of rebalancing delivery trees, 0 to disable")
+	fs.IntVar(&cor.RebalanceMaxMoves, "rebalanceMaxMoves", coordinator.DefaultRebalanceMaxMoves,
+		"max fetchers moved in a channel per rebalancing")
+	fs.DurationVar(&cor.RebalanceCooldown, "rebalanceCooldown", coordinator.DefaultRebalanceCooldown,
+		"min duration between two moves of a fetcher")
+	fs.Float64Var(&cor.DegradedQuality, "degradedQuality", coordinator.DefaultDegradedQuality,
+		"upload quality below which fetchers are moved off a forwarder")
+	fs.DurationVar(&cor.OfferTimeout, "offerTimeout", coordinator.DefaultOfferTimeout,
+		"deadline for a fetcher to offer a peer connection")
+	fs.DurationVar(&cor.AnswerTimeout, "answerTimeout", coordinator.DefaultAnswerTimeout,
+		"deadline for a forwarder to answer a peer connection")
+	fs.DurationVar(&cor.ConnectTimeout, "connectTimeout", coordinator.DefaultConnectTimeout,
+		"deadline for an answered peer connection to be connected")
+	fs.DurationVar(&cor.SweepInterval, "sweepInterval", coordinator.DefaultSweepInterval,
+		"interval of failing peer connections that missed their deadline")
+	fs.DurationVar(&cor.FailedPairTTL, "failedPairTTL", coordinator.DefaultFailedPairTTL,
+		"duration of not pairing a forwarder and a fetcher whose connection failed, 0 to disable")
 	fs.IntVar(&met.Port, "metricPort", metric.DefaultMetricsPort, "listening port")
 	fs.StringVar(&met.Path, "metricPath", metric.DefaultMetricsPath, "metrics path")
 	fs.StringVar(&med.IP, "IP", os.Getenv("IP"), "ip")
 	fs.StringVar(&med.MinUdpPort, "minUdpPort", os.Getenv("MinUdpPort"), "minimum UDP port for WebRTC")
 	fs.StringVar(&med.MaxUdpPort, "maxUdpPort", os.Getenv("MaxUdpPort"), "maximum UDP port for WebRTC")
+	fs.DurationVar(&med.StatsInterval, "mediaStatsInterval", media.DefaultStatsInterval,
+		"interval of reporting bytes sent by media server, 0 to disable")
+	fs.IntVar(&adm.Port, "adminPort", admin.DefaultPort, "admin api port")
+	fs.StringVar(&adm.Token, "adminToken", os.Getenv("ADMIN_TOKEN"), "bearer token of admin api, empty to disable")
+	fs.StringVar(&ath.HS256Secret, "tokenSecret", os.Getenv("TOKEN_SECRET"), "shared secret of HS256 client tokens")
+	fs.StringVar(&ath.RS256PublicKeyFile, "tokenRSAKey", "", "PEM file of RSA public key of RS256 client tokens")
+	fs.StringVar(&ath.EdDSAPublicKeyFile, "tokenEd25519Key", "", "PEM file of Ed25519 public key of EdDSA client tokens")
+	fs.BoolVar(&ath.Required, "tokenRequired", false, "reject clients activating with the channel key instead of a token")
+	fs.BoolVar(&ath.KeyPublishes, "keyPublishes", false, "let clients activating with the channel key push the stream, for publishers without tokens")
 	err := fs.Parse(args)
 	if err != nil {
 		return pdn.Config{}, fmt.Errorf("failed to parse args: %w", err)
@@ -78,5 +212,27 @@ func Parse(w io.Writer, args []string) (pdn.Config, error) {
 		Coordinator: cor,
 		Metrics:     met,
 		Media:       med,
+		Admin:       adm,
+		Auth:        ath,
+
+		ShutdownTimeout: shutdownTimeout,
+		StopTimeout:     stopTimeout,
 	}, nil
 }
+
+// envBool reports whether the environment variable is set to true.
+func envBool(name string) bool {
+	value, _ := strconv.ParseBool(os.Getenv(name))
+	return value
+}
+
+// splitList splits the comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -159,6 +159,12 @@ func TestSetupConfig(t *testing.T) {
 			expectParseError:    false,
 			expectValidateError: true,
 		},
+		{
+			name:                "given idle timeout shorter than ping interval when setup config then return error",
+			args:                []string{"-pingInterval=10s", "-idleTimeout=5s"},
+			expectParseError:    false,
+			expectValidateError: true,
+		},
 		{
 			name:                "given invalid flag format when setup config then return error",
 			args:                []string{"-extra"},
@@ -194,6 +200,18 @@ func TestSetupConfig(t *testing.T) {
 			expectParseError:    false,
 			expectValidateError: true,
 		},
+		{
+			name:                "given zero shutdown timeout when setup config then return error",
+			args:                []string{"-shutdownTimeout=0"},
+			expectParseError:    false,
+			expectValidateError: true,
+		},
+		{
+			name:                "given stop timeout not shorter than shutdown timeout when setup config then return error",
+			args:                []string{"-shutdownTimeout=5s", "-stopTimeout=5s"},
+			expectParseError:    false,
+			expectValidateError: true,
+		},
 	}
 
 	for _, tt := range tests {
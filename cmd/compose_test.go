@@ -0,0 +1,152 @@
+package cmd_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pdn/admin"
+	"pdn/auth"
+	"pdn/broker"
+	"pdn/cmd"
+	"pdn/coordinator"
+	"pdn/database/memory"
+	"pdn/metric"
+	"pdn/pool"
+	"pdn/signal"
+	"pdn/signal/controller"
+	"pdn/types/client/request"
+	"pdn/types/client/response"
+	"pdn/types/message"
+	"strings"
+	"testing"
+	"time"
+)
+
+// composeFlags returns the flags of the pdn service in the compose file. The
+// certificate flags are dropped, since the files exist only on the host.
+func composeFlags(t *testing.T, path string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var flags []string
+	command := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "command: |":
+			command = true
+		case command && strings.HasPrefix(line, "--"):
+			if !strings.HasPrefix(line, "--cert=") && !strings.HasPrefix(line, "--key=") {
+				flags = append(flags, line)
+			}
+		default:
+			command = false
+		}
+	}
+	require.NotEmpty(t, flags)
+	return flags
+}
+
+// freePort returns a port that nothing listens on.
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+	return port
+}
+
+// TestComposeDeployment tests that a server started with the flags of the
+// shipped compose files lets the broadcaster push with the key of a channel
+// provisioned through the admin api.
+func TestComposeDeployment(t *testing.T) {
+	for _, path := range []string{"../docker-compose-pdn.yml", "../docker-compose-offpdn.yml"} {
+		t.Run("given "+filepath.Base(path)+" when broadcaster activates with channel key then push", func(t *testing.T) {
+			t.Setenv("ADMIN_TOKEN", "admin")
+			port := freePort(t)
+			config, err := cmd.SetupConfig(io.Discard, append(composeFlags(t, path), fmt.Sprintf("-port=%d", port)))
+			require.NoError(t, err)
+
+			m := metric.New(config.Metrics)
+			b := broker.New(m)
+			db := memory.New(config.Database)
+			verifier, err := auth.New(config.Auth)
+			require.NoError(t, err)
+			strategy, err := pool.NewStrategy(config.Coordinator.Strategy)
+			require.NoError(t, err)
+			cod := coordinator.New(config.Coordinator, b, m, db, pool.New(db, strategy))
+			adm := httptest.NewServer(admin.New(config.Admin, db, cod))
+			t.Cleanup(adm.Close)
+			sig := signal.New(config.Signal, db, b, m, verifier)
+			started := make(chan error, 1)
+			go func() {
+				started <- sig.Start()
+			}()
+			t.Cleanup(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_ = sig.Shutdown(ctx)
+			})
+			activated := b.Subscribe(broker.Client, broker.ACTIVATE)
+			pushed := b.Subscribe(broker.Client, broker.PUSH)
+
+			// given a channel provisioned through the admin api
+			var out bytes.Buffer
+			command := cmd.ChannelCommand{Action: cmd.ActionCreate, Admin: adm.URL, Token: "admin", ID: "channel"}
+			require.NoError(t, command.Do(http.DefaultClient, &out))
+			_, key, found := strings.Cut(out.String(), "key: ")
+			require.True(t, found)
+
+			// when the broadcaster activates with the channel key
+			var conn *websocket.Conn
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+				conn, _, err = websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d", port), nil)
+				if err == nil {
+					break
+				}
+				select {
+				case startErr := <-started:
+					require.NoError(t, startErr)
+				default:
+				}
+				require.True(t, time.Now().Before(deadline), "failed to dial: %v", err)
+			}
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+			raw, err := json.Marshal(request.Activate{
+				ChannelID: "channel", ChannelKey: strings.TrimSpace(key), ClientID: "broadcaster", Version: controller.Version,
+			})
+			require.NoError(t, err)
+			require.NoError(t, conn.WriteJSON(request.Common{Type: request.ACTIVATE, Payload: raw}))
+			var res response.Activate
+			require.NoError(t, conn.ReadJSON(&res))
+			require.Equal(t, response.ACTIVATE, res.Type)
+			<-activated.Receive()
+
+			// then the broadcaster pushes the stream
+			require.NoError(t, conn.WriteJSON(request.Common{
+				Type: request.PUSH, Payload: json.RawMessage(`{"connection_id":"connection","sdp":"sdp"}`),
+			}))
+			select {
+			case msg := <-pushed.Receive():
+				assert.Equal(t, "broadcaster", msg.(message.Push).ClientID)
+			case <-time.After(time.Second):
+				t.Fatal("push is not published")
+			}
+		})
+	}
+}
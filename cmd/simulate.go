@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"pdn/coordinator"
+	"pdn/database"
+	"pdn/simulator"
+	"strconv"
+	"strings"
+)
+
+// Simulate runs the simulator and prints the results.
+func Simulate() {
+	config, verbose, err := ParseSimulation(os.Stdout, os.Args[1:])
+	if err != nil {
+		os.Exit(1)
+	}
+	if err = config.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if !verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	results, err := simulator.Run(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err = simulator.Write(os.Stdout, results); err != nil {
+		os.Exit(1)
+	}
+}
+
+// ParseSimulation parses the command line arguments of the simulator. It also
+// returns whether the logs of the coordinator are printed.
+func ParseSimulation(w io.Writer, args []string) (simulator.Config, bool, error) {
+	cor := coordinator.Config{
+		MaxPacketLoss:     coordinator.DefaultMaxPacketLoss,
+		MaxRTT:            coordinator.DefaultMaxRTT,
+		SuperPeerCap:      coordinator.DefaultSuperPeerCap,
+		SuperPeerUptime:   coordinator.DefaultSuperPeerUptime,
+		SuperPeerQuality:  coordinator.DefaultSuperPeerQuality,
+		SuperPeerCapacity: coordinator.DefaultSuperPeerCapacity,
+		SuperPeerInterval: coordinator.DefaultSuperPeerInterval,
+		RebalanceMaxMoves: coordinator.DefaultRebalanceMaxMoves,
+		RebalanceCooldown: coordinator.DefaultRebalanceCooldown,
+		DegradedQuality:   coordinator.DefaultDegradedQuality,
+		OfferTimeout:      coordinator.DefaultOfferTimeout,
+		AnswerTimeout:     coordinator.DefaultAnswerTimeout,
+		ConnectTimeout:    coordinator.DefaultConnectTimeout,
+		SweepInterval:     coordinator.DefaultSweepInterval,
+	}
+	sim := simulator.Config{
+		Strategies: simulator.DefaultStrategies,
+		Uplinks:    simulator.DefaultUplinks,
+	}
+	var verbose bool
+	fs := flag.NewFlagSet("simulator", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.Func("strategies", "comma separated forwarder selection strategies to compare (default all)", func(s string) error {
+		sim.Strategies = strings.Split(s, ",")
+		return nil
+	})
+	fs.IntVar(&cor.MaxForwardingNumber, "maxForwardingNumber",
+		coordinator.DefaultMaxForwardingNumber, "max forwarding number of clients without reported bandwidth")
+	fs.IntVar(&cor.MaxForwardingCap, "maxForwardingCap",
+		coordinator.DefaultMaxForwardingCap, "hard ceiling of forwarding number of a client")
+	fs.IntVar(&cor.StreamBitrate, "streamBitrate", coordinator.DefaultStreamBitrate, "expected stream bitrate in kbps")
+	fs.DurationVar(&cor.RebalanceInterval, "rebalanceInterval", coordinator.DefaultRebalanceInterval,
+		"interval of rebalancing delivery trees, 0 to disable")
+	fs.DurationVar(&cor.FailedPairTTL, "failedPairTTL", coordinator.DefaultFailedPairTTL,
+		"duration of not pairing a forwarder and a fetcher whose connection failed, 0 to disable")
+	fs.BoolVar(&sim.Policy.PeerToPeer, "setPeerConnection", true, "set peer assisted delivery network mode")
+	fs.IntVar(&sim.Policy.MaxDepth, "maxDepth", database.DefaultMaxDepth, "max delivery depth from media server")
+	fs.IntVar(&sim.Policy.MaxFanOut, "maxFanOut", 0, "max forwarding number of a client, 0 for no channel limit")
+	fs.IntVar(&sim.Policy.MinViewers, "minViewers", 0, "min viewers before peer assisted delivery starts")
+	fs.DurationVar(&sim.Duration, "duration", simulator.DefaultDuration, "simulated duration")
+	fs.Float64Var(&sim.Speedup, "speedup", simulator.DefaultSpeedup, "times the simulated time runs faster than real time")
+	fs.IntVar(&sim.Channels, "channels", simulator.DefaultChannels, "number of channels")
+	fs.Float64Var(&sim.ArrivalRate, "arrivalRate", simulator.DefaultArrivalRate, "viewers arriving per second per channel")
+	fs.DurationVar(&sim.MeanLifetime, "meanLifetime", simulator.DefaultMeanLifetime, "mean time viewers stay")
+	fs.Float64Var(&sim.CrashRate, "crashRate", simulator.DefaultCrashRate, "ratio of viewers leaving without deactivating")
+	fs.DurationVar(&sim.DetectionDelay, "detectionDelay", simulator.DefaultDetectionDelay,
+		"time for fetchers to notice a crashed forwarder")
+	fs.DurationVar(&sim.IdleTimeout, "idleTimeout", simulator.DefaultIdleTimeout,
+		"time for the server to deactivate a crashed client")
+	fs.Func("uplinks", "comma separated upload bandwidths in kbps that viewers are drawn from", func(s string) error {
+		uplinks, err := parseInts(s)
+		sim.Uplinks = uplinks
+		return err
+	})
+	fs.Float64Var(&sim.UplinkNoise, "uplinkNoise", simulator.DefaultUplinkNoise,
+		"max error ratio of the upload bandwidth that viewers report")
+	fs.Float64Var(&sim.MobileRate, "mobileRate", simulator.DefaultMobileRate, "ratio of viewers on mobile devices")
+	fs.Float64Var(&sim.SymmetricNATRate, "symmetricNATRate", simulator.DefaultSymmetricNATRate,
+		"ratio of viewers behind symmetric NAT")
+	fs.Float64Var(&sim.FailureRate, "failureRate", simulator.DefaultFailureRate, "ratio of peer connections that fail")
+	fs.Float64Var(&sim.StallRate, "stallRate", simulator.DefaultStallRate,
+		"ratio of peer connections that stop negotiating")
+	fs.DurationVar(&sim.SignalingDelay, "signalingDelay", simulator.DefaultSignalingDelay,
+		"delay of every step of a negotiation")
+	fs.DurationVar(&sim.RetryDelay, "retryDelay", simulator.DefaultRetryDelay,
+		"time for a viewer without the stream to pull it from media server by itself")
+	fs.DurationVar(&sim.SampleInterval, "sampleInterval", simulator.DefaultSampleInterval,
+		"interval of sampling delivery trees")
+	fs.DurationVar(&sim.HealthInterval, "healthInterval", simulator.DefaultHealthInterval,
+		"interval of viewers reporting their connections")
+	fs.Uint64Var(&sim.Seed, "seed", simulator.DefaultSeed, "seed of the trace and the outcomes of negotiations")
+	fs.BoolVar(&verbose, "verbose", false, "print logs of the coordinator")
+	if err := fs.Parse(args); err != nil {
+		return simulator.Config{}, false, fmt.Errorf("failed to parse args: %w", err)
+	}
+
+	if fs.NArg() != 0 {
+		return simulator.Config{}, false, errors.New("some args are not parsed")
+	}
+
+	sim.Coordinator = cor
+	return sim, verbose, nil
+}
+
+// parseInts parses comma separated integers.
+func parseInts(s string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(s, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q: %w", field, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
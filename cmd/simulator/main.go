@@ -0,0 +1,8 @@
+// Package main is entrypoint for the delivery simulator
+package main
+
+import "pdn/cmd"
+
+func main() {
+	cmd.Simulate()
+}
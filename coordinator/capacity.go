@@ -0,0 +1,74 @@
+package coordinator
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"pdn/database"
+)
+
+// calculateCapacity calculates how many fetchers the client can forward to.
+// The capacity is based on the reported upload bandwidth of the client and
+// lowered by its measured upload quality. Clients that didn't report their
+// bandwidth get MaxForwardingNumber. The result never exceeds the limit of
+// the channel or MaxForwardingCap, or SuperPeerCap for super-peers.
+func (c *Coordinator) calculateCapacity(channel *database.ChannelInfo, client *database.ClientInfo) int {
+	if client.Capabilities.ForwardingDisabled {
+		return 0
+	}
+
+	limit := c.config.MaxForwardingCap
+	if client.SuperPeer {
+		limit = c.config.SuperPeerCap
+	}
+	if channel != nil && channel.Policy.MaxFanOut > 0 {
+		limit = min(limit, channel.Policy.MaxFanOut)
+	}
+
+	capacity := float64(c.config.MaxForwardingNumber)
+	if client.Capabilities.UploadBandwidth > 0 {
+		capacity = float64(client.Capabilities.UploadBandwidth) / float64(c.config.StreamBitrate)
+	}
+	capacity *= min(max(client.UploadQuality, 0), 1)
+
+	return min(int(math.Floor(capacity)), limit)
+}
+
+// refreshCapacity recalculates the capacity of the client and stores it if changed.
+func (c *Coordinator) refreshCapacity(channel *database.ChannelInfo, client *database.ClientInfo) (*database.ClientInfo, error) {
+	capacity := c.calculateCapacity(channel, client)
+	if capacity == client.Capacity {
+		return client, nil
+	}
+	updated, err := c.database.UpdateClientCapacity(client.ChannelID, client.ID, capacity)
+	if err != nil {
+		return nil, fmt.Errorf("error occurs in updating client capacity %w", err)
+	}
+	return updated, nil
+}
+
+// updateUploadQuality raises or lowers the forwarding capacity of a live
+// forwarder by its measured upload quality between 0 and 1, as reported by
+// the health of its peer connections. If the capacity goes below the current
+// number of fetchers, no more fetchers are assigned to the forwarder until
+// some of them leave. A super-peer is demoted if its quality drops below
+// SuperPeerQuality. It must run in the mailbox of the channel.
+func (c *Coordinator) updateUploadQuality(channelID, clientID string, quality float64) error {
+	client, err := c.database.UpdateClientUploadQuality(channelID, clientID, min(max(quality, 0), 1))
+	if err != nil {
+		return fmt.Errorf("error occurs in updating upload quality %w", err)
+	}
+	if err := c.refreshCandidate(channelID, clientID); err != nil {
+		return err
+	}
+	log.Printf("upload quality of %s in %s updated to %.2f", clientID, channelID, quality)
+
+	if client.SuperPeer && client.UploadQuality < c.config.SuperPeerQuality {
+		channel, err := c.database.FindChannelInfoByID(channelID)
+		if err != nil {
+			return fmt.Errorf("error occurs in finding channel info %w", err)
+		}
+		return c.evaluateSuperPeer(channel, client)
+	}
+	return nil
+}
@@ -1,13 +1,133 @@
 package coordinator
 
+import (
+	"fmt"
+	"pdn/pool"
+	"time"
+)
+
 // Default values for the coordinator. If the values are not set, these values are used.
 const (
 	DefaultMaxForwardingNumber = 1
-	DefaultSetPeerConnection   = false
+	DefaultMaxForwardingCap    = 8
+	DefaultStreamBitrate       = 2500
+	DefaultStrategy            = pool.DefaultStrategy
+	DefaultMaxPacketLoss       = 0.05
+	DefaultMaxRTT              = 500 * time.Millisecond
+	DefaultSuperPeerCap        = 16
+	DefaultSuperPeerUptime     = 2 * time.Minute
+	DefaultSuperPeerQuality    = 0.9
+	DefaultSuperPeerCapacity   = 3
+	DefaultSuperPeerInterval   = 30 * time.Second
+	DefaultRebalanceInterval   = 15 * time.Second
+	DefaultRebalanceMaxMoves   = 5
+	DefaultRebalanceCooldown   = time.Minute
+	DefaultDegradedQuality     = 0.5
+	DefaultOfferTimeout        = 10 * time.Second
+	DefaultAnswerTimeout       = 10 * time.Second
+	DefaultConnectTimeout      = 20 * time.Second
+	DefaultSweepInterval       = 5 * time.Second
+	DefaultFailedPairTTL       = 5 * time.Minute
 )
 
 // Config contains the configuration for the coordinator.
 type Config struct {
+	// MaxForwardingNumber is the forwarding capacity of a client that didn't
+	// report its upload bandwidth.
 	MaxForwardingNumber int
-	SetPeerConnection   bool
+
+	// MaxForwardingCap is the hard ceiling of the forwarding capacity. Neither
+	// reported capabilities nor channel overrides can exceed it.
+	MaxForwardingCap int
+
+	// StreamBitrate is the expected bitrate of the stream in kbps. It is used
+	// to calculate how many fetchers a client can forward to.
+	StreamBitrate int
+
+	// Strategy is the name of the strategy that the pool uses to select a
+	// forwarder. See pool.NewStrategy for the available strategies.
+	Strategy string
+
+	// MaxPacketLoss and MaxRTT are the thresholds of a healthy peer connection.
+	// If a fetcher reports worse stats, it is moved to another forwarder.
+	MaxPacketLoss float64
+	MaxRTT        time.Duration
+
+	// SuperPeerCap is the hard ceiling of the forwarding capacity of super-peers.
+	SuperPeerCap int
+
+	// SuperPeerUptime, SuperPeerQuality and SuperPeerCapacity are the minimum
+	// uptime, upload quality and forwarding capacity to be a super-peer.
+	// Super-peers are demoted when they don't meet them anymore.
+	SuperPeerUptime   time.Duration
+	SuperPeerQuality  float64
+	SuperPeerCapacity int
+
+	// SuperPeerInterval is the interval of evaluating super-peer candidates.
+	SuperPeerInterval time.Duration
+
+	// RebalanceInterval is the interval of rebalancing the delivery trees.
+	// Zero disables the rebalancing.
+	RebalanceInterval time.Duration
+
+	// RebalanceMaxMoves is the maximum number of fetchers moved in a channel
+	// per rebalancing, and RebalanceCooldown is the minimum duration between
+	// two moves of the same fetcher.
+	RebalanceMaxMoves int
+	RebalanceCooldown time.Duration
+
+	// DegradedQuality is the upload quality below which the fetchers of a
+	// forwarder are moved to other forwarders by rebalancing.
+	DegradedQuality float64
+
+	// OfferTimeout, AnswerTimeout and ConnectTimeout are the deadlines of the
+	// negotiation phases of a peer connection: from created to offered, from
+	// offered to answered, and from answered to connected.
+	OfferTimeout   time.Duration
+	AnswerTimeout  time.Duration
+	ConnectTimeout time.Duration
+
+	// SweepInterval is the interval of failing peer connections that missed
+	// the deadline of their negotiation phase.
+	SweepInterval time.Duration
+
+	// FailedPairTTL is how long a forwarder and a fetcher whose peer
+	// connection failed are not paired again. Zero disables the record.
+	FailedPairTTL time.Duration
+}
+
+// Validate validates the configuration of the coordinator.
+func (c Config) Validate() error {
+	if c.MaxForwardingNumber < 0 || c.MaxForwardingNumber > c.MaxForwardingCap {
+		return fmt.Errorf("max forwarding number must be between 0 and %d, given %d",
+			c.MaxForwardingCap, c.MaxForwardingNumber)
+	}
+	if c.StreamBitrate < 1 {
+		return fmt.Errorf("stream bitrate must be positive, given %d", c.StreamBitrate)
+	}
+	if c.MaxPacketLoss < 0 || c.MaxPacketLoss > 1 {
+		return fmt.Errorf("max packet loss must be between 0 and 1, given %f", c.MaxPacketLoss)
+	}
+	if c.SuperPeerCap < c.MaxForwardingCap {
+		return fmt.Errorf("super-peer cap must not be less than %d, given %d", c.MaxForwardingCap, c.SuperPeerCap)
+	}
+	if c.SuperPeerInterval <= 0 {
+		return fmt.Errorf("super-peer interval must be positive, given %s", c.SuperPeerInterval)
+	}
+	if c.RebalanceInterval < 0 || c.RebalanceMaxMoves < 0 {
+		return fmt.Errorf("rebalance interval and max moves must not be negative")
+	}
+	if c.OfferTimeout <= 0 || c.AnswerTimeout <= 0 || c.ConnectTimeout <= 0 {
+		return fmt.Errorf("negotiation timeouts must be positive")
+	}
+	if c.SweepInterval <= 0 {
+		return fmt.Errorf("sweep interval must be positive, given %s", c.SweepInterval)
+	}
+	if c.FailedPairTTL < 0 {
+		return fmt.Errorf("failed pair ttl must not be negative, given %s", c.FailedPairTTL)
+	}
+	if _, err := pool.NewStrategy(c.Strategy); err != nil {
+		return fmt.Errorf("invalid strategy: %w", err)
+	}
+	return nil
 }
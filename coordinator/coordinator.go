@@ -2,17 +2,22 @@
 package coordinator
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/lithammer/shortuuid/v4"
 	"log"
+	"maps"
 	"pdn/broker"
+	"pdn/broker/subscription"
 	"pdn/database"
 	"pdn/metric"
 	"pdn/pool"
 	"pdn/types/client/response"
 	"pdn/types/message"
 	"runtime/debug"
+	"slices"
+	"time"
 )
 
 var (
@@ -22,62 +27,118 @@ var (
 
 // Coordinator manages the WebRTC connections that Client to Media server and Client to Client.
 type Coordinator struct {
-	config   Config
-	broker   *broker.Broker
-	metric   *metric.Metrics
-	database database.Database
-	pool     *pool.Pool
+	config     Config
+	broker     *broker.Broker
+	metric     *metric.Metrics
+	database   database.Database
+	pool       *pool.Pool
+	rebalancer *rebalancer
+	recoveries *recoveries
+	mailboxes  *mailboxes
+	ledger     *ledger
+
+	failedPairs *failedPairs
 }
 
 // New creates a new instance of Coordinator.
 func New(c Config, b *broker.Broker, m *metric.Metrics, db database.Database, p *pool.Pool) *Coordinator {
 	return &Coordinator{
-		config:   c,
-		broker:   b,
-		metric:   m,
-		database: db,
-		pool:     p,
+		config:     c,
+		broker:     b,
+		metric:     m,
+		database:   db,
+		pool:       p,
+		rebalancer: newRebalancer(),
+		recoveries: newRecoveries(),
+		mailboxes:  newMailboxes(),
+		ledger:     newLedger(),
+
+		failedPairs: newFailedPairs(),
 	}
 }
 
-// Start starts the Coordinator instance.
-func (c *Coordinator) Start() {
+// Start starts the Coordinator instance. It runs until the context is done,
+// and then waits for the events being handled.
+func (c *Coordinator) Start(ctx context.Context) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("!!! PANIC RECOVERED in handleDeactivate goroutine: %v", r)
+			log.Printf("!!! PANIC RECOVERED in coordinator loop: %v", r)
 			log.Printf("Stack trace:\n%s", debug.Stack())
 		}
 	}()
 
-	activateEvent := c.broker.Subscribe(broker.Client, broker.ACTIVATE)
-	deactivateEvent := c.broker.Subscribe(broker.Client, broker.DEACTIVATE)
-	pushEvent := c.broker.Subscribe(broker.Client, broker.PUSH)
-	pullEvent := c.broker.Subscribe(broker.Client, broker.PULL)
-	mediaConnectedEvent := c.broker.Subscribe(broker.Media, broker.CONNECTED)
-	mediaDisconnectedEvent := c.broker.Subscribe(broker.Media, broker.DISCONNECTED)
-	peerFailedEvent := c.broker.Subscribe(broker.Peer, broker.FAILED)
-	peerConnectedEvent := c.broker.Subscribe(broker.Peer, broker.CONNECTED)
-	peerDisconnectedEvent := c.broker.Subscribe(broker.Peer, broker.DISCONNECTED)
+	// NOTE: All events come through one subscription, so the events that a
+	// publisher sends are dispatched in the order they are published, e.g.
+	// PULL before DEACTIVATE of the same client.
+	handlers := map[broker.Route]func(any){
+		{Topic: broker.Client, Detail: broker.ACTIVATE}:    c.handleActivate,
+		{Topic: broker.Client, Detail: broker.DEACTIVATE}:  c.handleDeactivate,
+		{Topic: broker.Client, Detail: broker.UPDATE}:      c.handleUpdate,
+		{Topic: broker.Client, Detail: broker.HEALTH}:      c.handleHealth,
+		{Topic: broker.Client, Detail: broker.PUSH}:        c.handlePush,
+		{Topic: broker.Client, Detail: broker.PULL}:        c.handlePull,
+		{Topic: broker.Media, Detail: broker.ANSWERED}:     c.handleMediaAnswered,
+		{Topic: broker.Media, Detail: broker.CONNECTING}:   c.handleMediaConnecting,
+		{Topic: broker.Media, Detail: broker.CONNECTED}:    c.handleMediaConnected,
+		{Topic: broker.Media, Detail: broker.DISCONNECTED}: c.handleMediaDisconnected,
+		{Topic: broker.Media, Detail: broker.FAILED}:       c.handleMediaFailed,
+		{Topic: broker.Media, Detail: broker.STATS}:        c.handleMediaStats,
+		{Topic: broker.Peer, Detail: broker.OFFERED}:       c.handlePeerOffered,
+		{Topic: broker.Peer, Detail: broker.ANSWERED}:      c.handlePeerAnswered,
+		{Topic: broker.Peer, Detail: broker.CONNECTING}:    c.handlePeerConnecting,
+		{Topic: broker.Peer, Detail: broker.FAILED}:        c.handlePeerFailed,
+		{Topic: broker.Peer, Detail: broker.CONNECTED}:     c.handlePeerConnected,
+		{Topic: broker.Peer, Detail: broker.DISCONNECTED}:  c.handlePeerDisconnected,
+	}
+	events := c.broker.SubscribeRoutes(slices.Collect(maps.Keys(handlers))...)
+	superPeerTicker := time.NewTicker(c.config.SuperPeerInterval)
+	defer superPeerTicker.Stop()
+	sweepTicker := time.NewTicker(c.config.SweepInterval)
+	defer sweepTicker.Stop()
+	var rebalanceTick <-chan time.Time
+	if c.config.RebalanceInterval > 0 {
+		rebalanceTicker := time.NewTicker(c.config.RebalanceInterval)
+		defer rebalanceTicker.Stop()
+		rebalanceTick = rebalanceTicker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// NOTE: The events published before the stop are still handled,
+			// e.g. the DEACTIVATEs of the clients drained on shutdown.
+			c.drain(events, handlers)
+			c.mailboxes.wait()
+			return
+		case event, ok := <-events.Receive():
+			if !ok {
+				c.mailboxes.wait()
+				return
+			}
+			e := event.(broker.Event)
+			c.dispatch(e.Message, handlers[e.Route])
+		case <-superPeerTicker.C:
+			c.evaluateSuperPeers()
+		case <-sweepTicker.C:
+			c.sweep()
+		case <-rebalanceTick:
+			c.rebalance()
+		}
+	}
+}
+
+// drain dispatches the events left in the subscription, without waiting for
+// more.
+func (c *Coordinator) drain(events *subscription.Subscription, handlers map[broker.Route]func(any)) {
 	for {
 		select {
-		case event := <-activateEvent.Receive():
-			go c.handleActivate(event)
-		case event := <-deactivateEvent.Receive():
-			go c.handleDeactivate(event)
-		case event := <-pushEvent.Receive():
-			go c.handlePush(event)
-		case event := <-pullEvent.Receive():
-			go c.handlePull(event)
-		case event := <-mediaConnectedEvent.Receive():
-			go c.handleMediaConnected(event)
-		case event := <-mediaDisconnectedEvent.Receive():
-			go c.handleMediaDisconnected(event)
-		case event := <-peerFailedEvent.Receive():
-			go c.handlePeerFailed(event)
-		case event := <-peerConnectedEvent.Receive():
-			go c.handlePeerConnected(event)
-		case event := <-peerDisconnectedEvent.Receive():
-			go c.handlePeerDisconnected(event)
+		case event, ok := <-events.Receive():
+			if !ok {
+				return
+			}
+			e := event.(broker.Event)
+			c.dispatch(e.Message, handlers[e.Route])
+		default:
+			return
 		}
 	}
 }
@@ -95,6 +156,32 @@ func (c *Coordinator) handleActivate(event any) {
 		log.Printf("error occurs in creating client info %v", err)
 		return
 	}
+	if _, err := c.database.UpdateClientCapabilities(msg.ChannelID, msg.ClientID, msg.Capabilities); err != nil {
+		log.Printf("error occurs in updating client capabilities %v", err)
+		return
+	}
+	if err := c.refreshCandidate(msg.ChannelID, msg.ClientID); err != nil {
+		log.Printf("error occurs in refreshing candidate %v", err)
+	}
+}
+
+// handleUpdate handles the update event. update event means that a client
+// reports its changed capabilities. The score of the client in the pool is
+// updated, so the pool never picks the client if it opts out forwarding.
+func (c *Coordinator) handleUpdate(event any) {
+	msg, ok := event.(message.Update)
+	if !ok {
+		log.Printf("error occurs in parsing update message %v", event)
+		return
+	}
+
+	if _, err := c.database.UpdateClientCapabilities(msg.ChannelID, msg.ClientID, msg.Capabilities); err != nil {
+		log.Printf("error occurs in updating client capabilities %v", err)
+		return
+	}
+	if err := c.refreshCandidate(msg.ChannelID, msg.ClientID); err != nil {
+		log.Printf("error occurs in refreshing candidate %v", err)
+	}
 }
 
 func (c *Coordinator) handleDeactivate(event any) {
@@ -124,10 +211,18 @@ func (c *Coordinator) handleDeactivate(event any) {
 		// 예를 들어, c.database가 nil인 경우 등.
 		// 이 defer recover가 그런 패닉을 잡아줄 것입니다.
 	}
+	// The fetcher is offered a new upstream before it is told that the forwarder
+	// left, so it can switch without waiting for its own PULL.
 	for _, forward := range forwards {
 		if forward.IsConnected() {
 			c.metric.DecrementPeerConnections()
 		}
+		if err := c.closeConnection(forward.ID); err != nil {
+			log.Printf("error occurs in closing connection %v", err)
+		}
+		if err := c.recoverFetcher(forward); err != nil {
+			log.Printf("error occurs in recovering fetcher %v", err)
+		}
 		log.Printf("publish closed")
 		if err := c.broker.Publish(broker.ClientSocket, broker.Detail(forward.ChannelID+forward.To), response.Closed{
 			Type:         response.CLOSED,
@@ -135,9 +230,6 @@ func (c *Coordinator) handleDeactivate(event any) {
 		}); err != nil {
 			log.Printf("error occurs in publishing close message %v", err)
 		}
-		if err := c.database.DeleteConnectionInfoByID(forward.ID); err != nil {
-			log.Printf("error occurs in deleting connection info %v", err)
-		}
 	}
 
 	// 03. Find fetching connections. Because the forwarder don't know the fetcher left or just temporal issue.
@@ -147,12 +239,8 @@ func (c *Coordinator) handleDeactivate(event any) {
 		log.Printf("error occurs in finding connection info by to %v", err)
 	}
 	for _, fetch := range fetches {
-		// 이 부분은 이미 위에서 forward 루프에서 처리되었을 수도 있는 fetch.ID를 다시 삭제 시도합니다.
-		// 로직 상 의도된 것이 아니라면 중복 삭제 시도는 불필요하거나 오류가 발생할 수 있습니다.
-		// 한 번 삭제된 ID를 다시 삭제 시도하는 것은 일반적으로 데이터베이스 에러는 아니지만,
-		// 로직의 의미를 다시 확인해보시는 것이 좋습니다.
-		if err := c.database.DeleteConnectionInfoByID(fetch.ID); err != nil {
-			log.Printf("error occurs in deleting connection info %v", err)
+		if err := c.closeConnection(fetch.ID); err != nil {
+			log.Printf("error occurs in closing connection %v", err)
 		}
 		switch fetch.Type {
 		case database.PushToServer:
@@ -176,7 +264,7 @@ func (c *Coordinator) handleDeactivate(event any) {
 			}
 			if fetch.IsConnected() {
 				c.metric.DecrementPeerConnections()
-				if err := c.pool.UpdateClientScore(fetch.From, fetch.ChannelID, c.config.MaxForwardingNumber); err != nil {
+				if err := c.refreshCandidate(fetch.ChannelID, fetch.From); err != nil {
 					log.Printf("error occurs in updating client score %v", err)
 				}
 			}
@@ -184,17 +272,19 @@ func (c *Coordinator) handleDeactivate(event any) {
 			// 여기에서 "unhandled default case" 패닉이 발생할 수 있습니다.
 			// 이제 이 defer recover가 이 패닉을 잡아줄 것입니다.
 		}
-
-		// 이 부분도 위와 마찬가지로 중복 삭제 시도가 될 수 있습니다.
-		// 의도를 확인해보세요.
-		if err := c.database.DeleteConnectionInfoByID(fetch.ID); err != nil {
-			log.Printf("error occurs in deleting connection info %v", err)
-		}
 	}
 
+	if client, err := c.database.FindClientInfoByID(msg.ChannelID, msg.ClientID); err == nil && client.SuperPeer {
+		c.metric.DecrementSuperPeers()
+	}
+	c.pool.RemoveClient(msg.ClientID, msg.ChannelID)
+	c.recoveries.finish(msg.ChannelID + msg.ClientID)
 	if err := c.database.DeleteClientInfoByID(msg.ChannelID, msg.ClientID); err != nil {
 		log.Printf("error occurs in deleting client info %v", err)
 	}
+	if clients, err := c.database.FindAllClientInfosByChannelID(msg.ChannelID); err == nil && len(clients) == 0 {
+		c.failedPairs.forget(msg.ChannelID)
+	}
 
 	connInfo, err := c.database.FindUpstreamInfo(msg.ChannelID)
 	if err != nil {
@@ -210,12 +300,27 @@ func (c *Coordinator) handleDeactivate(event any) {
 		}); err != nil {
 			log.Printf("error occurs in publishing close message %v", err)
 		}
-		if err := c.database.DeleteConnectionInfoByID(connInfo.ID); err != nil {
-			log.Printf("error occurs in deleting connection info %v", err)
-		}
-		if err := c.database.DeleteChannelInfoByID(msg.ChannelID); err != nil {
-			log.Printf("error occurs in deleting channel info %v", err)
+		if err := c.closeConnection(connInfo.ID); err != nil {
+			log.Printf("error occurs in closing connection %v", err)
 		}
+		c.closeAccounts(msg.ChannelID)
+		c.closeChannel(msg.ChannelID)
+	}
+}
+
+// closeChannel deletes the channel whose broadcast ended, unless it is
+// provisioned ahead of time.
+func (c *Coordinator) closeChannel(channelID string) {
+	channel, err := c.database.FindChannelInfoByID(channelID)
+	if err != nil {
+		log.Printf("error occurs in finding channel info %v", err)
+		return
+	}
+	if channel.Provisioned {
+		return
+	}
+	if err := c.database.DeleteChannelInfoByID(channelID); err != nil {
+		log.Printf("error occurs in deleting channel info %v", err)
 	}
 }
 
@@ -233,6 +338,10 @@ func (c *Coordinator) handlePush(event any) {
 		log.Printf("error occurs in creating connection info %v", err)
 		return
 	}
+	if _, err := c.database.UpdateConnectionInfo(connInfo.ID, database.Offered); err != nil {
+		log.Printf("error occurs in updating connection info %v", err)
+		return
+	}
 
 	if err := c.broker.Publish(broker.Media, broker.UPSTREAM, message.Upstream{
 		ConnectionID: connInfo.ID,
@@ -254,6 +363,12 @@ func (c *Coordinator) handlePull(event any) {
 		return
 	}
 
+	// The client must be active, or the connection would be left behind by
+	// the client that is already gone.
+	if _, err := c.database.FindClientInfoByID(msg.ChannelID, msg.ClientID); err != nil {
+		log.Printf("error occurs in finding client info %v", err)
+		return
+	}
 	connInfo, err := c.database.CreatePullConnectionInfo(msg.ChannelID, msg.ClientID, msg.ConnectionID)
 	if err != nil {
 		log.Printf("error occurs in creating connection info %v", err)
@@ -265,6 +380,10 @@ func (c *Coordinator) handlePull(event any) {
 		log.Printf("error occurs in finding upstream info %v", err)
 		return
 	}
+	if _, err := c.database.UpdateConnectionInfo(connInfo.ID, database.Offered); err != nil {
+		log.Printf("error occurs in updating connection info %v", err)
+		return
+	}
 
 	if err := c.broker.Publish(broker.Media, broker.DOWNSTREAM, message.Downstream{
 		ConnectionID: connInfo.ID,
@@ -277,6 +396,39 @@ func (c *Coordinator) handlePull(event any) {
 	}
 }
 
+// handleMediaAnswered handles the answered event. This event means that Media
+// server answered the offer of a client.
+func (c *Coordinator) handleMediaAnswered(event any) {
+	msg, ok := event.(message.Answered)
+	if !ok {
+		log.Printf("error occurs in parsing answered message %v", event)
+		return
+	}
+	// NOTE: Media server publishes ANSWERED from the handler and CONNECTING from
+	// the state callback of the peer connection, so CONNECTING may arrive first.
+	// Then ANSWERED is stale and the transition back is ignored.
+	if _, err := c.database.UpdateConnectionInfo(msg.ConnectionID, database.Answered); err != nil &&
+		!errors.Is(err, database.ErrInvalidTransition) {
+		log.Printf("error occurs in updating connection info %v", err)
+	}
+}
+
+// handleMediaConnecting handles the connecting event. This event means that
+// Media server started ICE with a client.
+func (c *Coordinator) handleMediaConnecting(event any) {
+	msg, ok := event.(message.Connecting)
+	if !ok {
+		log.Printf("error occurs in parsing connecting message %v", event)
+		return
+	}
+	// A connection already connected may restart ICE, and then CONNECTING is
+	// not a transition to record.
+	if _, err := c.database.UpdateConnectionInfo(msg.ConnectionID, database.Connecting); err != nil &&
+		!errors.Is(err, database.ErrInvalidTransition) {
+		log.Printf("error occurs in updating connection info %v", err)
+	}
+}
+
 // handleMediaConnected handles the connected event. This event is about Media server to client
 func (c *Coordinator) handleMediaConnected(event any) {
 	msg, ok := event.(message.Connected)
@@ -294,6 +446,22 @@ func (c *Coordinator) handleMediaConnected(event any) {
 	if connInfo.IsUpstream() {
 		return
 	}
+	c.finishRecovery(connInfo.ChannelID, connInfo.To)
+	if err := c.setDepth(connInfo.ChannelID, connInfo.To, 1); err != nil {
+		log.Printf("error occurs in setting depth %v", err)
+		return
+	}
+
+	// NOTE: A client that fell back to Media server doesn't need its peer
+	// connections anymore, e.g. when the delivery between clients is turned off.
+	fetches, err := c.database.FindAllPeerConnectionInfoByTo(connInfo.ChannelID, connInfo.To)
+	if err != nil {
+		log.Printf("error occurs in finding connection info by to %v", err)
+		return
+	}
+	for _, fetch := range fetches {
+		c.clearPeerConnection(fetch)
+	}
 	if err := c.balance(connInfo.ChannelID, connInfo.To); err != nil && !errors.Is(err, ErrNoForwarder) {
 		log.Printf("error occurs in balancing %v", err)
 		log.Printf("remain fetchfrom server")
@@ -303,15 +471,30 @@ func (c *Coordinator) handleMediaConnected(event any) {
 
 // handleMediaDisconnected handles the disconnected event. This event is about Media server to client.
 // Currently, When client disconnected, we got disconnected event from deactivation event in Signal server first.
-// And Signal server will notify to Media server to close the connection. So we don't need to handle this event now.
-// But if we consider that signal disconnected but media server still connected, we should think it again.
-func (c *Coordinator) handleMediaDisconnected(_ any) {
-	// 01. Parse the event to message.Disconnected
-	//msg, ok := event.(message.Disconnected)
-	//if !ok {
-	//	log.Printf("error occurs in parsing disconnected message %v", event)
-	//	return
-	//}
+// And Signal server will notify to Media server to close the connection. So only the status is recorded here,
+// and the connection is kept because it could be connected again by ICE restart.
+func (c *Coordinator) handleMediaDisconnected(event any) {
+	msg, ok := event.(message.Disconnected)
+	if !ok {
+		log.Printf("error occurs in parsing disconnected message %v", event)
+		return
+	}
+	if _, err := c.database.UpdateConnectionInfo(msg.ConnectionID, database.Disconnected); err != nil {
+		log.Printf("error occurs in updating connection info %v", err)
+	}
+}
+
+// handleMediaFailed handles the failed event. This event is about Media server to client.
+// The connection is kept until the client leaves or pulls again, so the failure stays visible.
+func (c *Coordinator) handleMediaFailed(event any) {
+	msg, ok := event.(message.Failed)
+	if !ok {
+		log.Printf("error occurs in parsing failed message %v", event)
+		return
+	}
+	if _, err := c.database.UpdateConnectionInfo(msg.ConnectionID, database.Failed); err != nil {
+		log.Printf("error occurs in updating connection info %v", err)
+	}
 }
 
 // handlePeerFailed handles the failed event. This event is about client to client
@@ -327,6 +510,12 @@ func (c *Coordinator) handlePeerFailed(event any) {
 		log.Printf("error occurs in finding connection info by connection id %v", err)
 		return
 	}
+	if _, err := c.database.UpdateConnectionInfo(connInfo.ID, database.Failed); err != nil {
+		log.Printf("error occurs in updating connection info %v", err)
+		return
+	}
+	c.recordPeerResult(connInfo, false)
+	c.clearPeerConnection(connInfo)
 
 	if err := c.balance(connInfo.ChannelID, connInfo.To); err != nil && !errors.Is(err, ErrNoForwarder) {
 		log.Printf("error occurs in balancing %v", err)
@@ -339,6 +528,58 @@ func (c *Coordinator) handlePeerFailed(event any) {
 	}
 }
 
+// handlePeerOffered handles the offered event. This event means that the
+// fetcher sent its offer to the forwarder. A repeated offer is relayed to the
+// forwarder but doesn't change the status.
+func (c *Coordinator) handlePeerOffered(event any) {
+	msg, ok := event.(message.Offered)
+	if !ok {
+		log.Printf("error occurs in parsing offered message %v", event)
+		return
+	}
+	if _, err := c.database.UpdateConnectionInfo(msg.ConnectionID, database.Offered); err != nil &&
+		!errors.Is(err, database.ErrInvalidTransition) {
+		log.Printf("error occurs in updating connection info %v", err)
+	}
+}
+
+// handlePeerAnswered handles the answered event. This event means that the
+// forwarder sent its answer to the fetcher. A repeated answer is relayed to the
+// fetcher but doesn't change the status.
+func (c *Coordinator) handlePeerAnswered(event any) {
+	msg, ok := event.(message.Answered)
+	if !ok {
+		log.Printf("error occurs in parsing answered message %v", event)
+		return
+	}
+	if _, err := c.database.UpdateConnectionInfo(msg.ConnectionID, database.Answered); err != nil &&
+		!errors.Is(err, database.ErrInvalidTransition) {
+		log.Printf("error occurs in updating connection info %v", err)
+	}
+}
+
+// handlePeerConnecting handles the connecting event. This event means that a
+// client sent an ICE candidate. The first candidate after the answer means that
+// ICE started, and the other candidates don't change the status.
+func (c *Coordinator) handlePeerConnecting(event any) {
+	msg, ok := event.(message.Connecting)
+	if !ok {
+		log.Printf("error occurs in parsing connecting message %v", event)
+		return
+	}
+	peerConn, err := c.database.FindConnectionInfoByID(msg.ConnectionID)
+	if err != nil {
+		log.Printf("error occurs in finding connection info %v", err)
+		return
+	}
+	if peerConn.Status != database.Answered {
+		return
+	}
+	if _, err := c.database.UpdateConnectionInfo(msg.ConnectionID, database.Connecting); err != nil {
+		log.Printf("error occurs in updating connection info %v", err)
+	}
+}
+
 // handlePeerConnected handles the succeed event. This event is about client to client
 func (c *Coordinator) handlePeerConnected(event any) {
 	msg, ok := event.(message.Connected)
@@ -351,68 +592,181 @@ func (c *Coordinator) handlePeerConnected(event any) {
 		log.Printf("error occurs in updating connection info %v", err)
 		return
 	}
-	serverConn, err := c.database.FindDownstreamInfo(peerConn.ChannelID, peerConn.To)
+	forwarder, err := c.database.FindClientInfoByID(peerConn.ChannelID, peerConn.From)
 	if err != nil {
-		log.Printf("error occurs in finding downstream info %v", err)
+		log.Printf("error occurs in finding forwarder info %v", err)
 		return
 	}
-	if err := c.database.DeleteConnectionInfoByID(serverConn.ID); err != nil {
-		log.Printf("error occurs in deleting connection info %v", err)
-		return
+	if err := c.setDepth(peerConn.ChannelID, peerConn.To, forwarder.Depth+1); err != nil {
+		log.Printf("error occurs in setting depth %v", err)
 	}
+
 	c.metric.IncrementPeerConnections()
+	c.recordPeerResult(peerConn, true)
+	if err := c.refreshCandidate(peerConn.ChannelID, peerConn.From); err != nil {
+		log.Printf("error occurs in refreshing candidate %v", err)
+	}
+	c.finishRecovery(peerConn.ChannelID, peerConn.To)
+	c.releaseUpstreams(peerConn)
+}
 
-	if err := c.broker.Publish(broker.Media, broker.CLEAR, message.Clear{
-		ConnectionID: serverConn.ID,
-	}); err != nil {
-		log.Printf("error occurs in publishing closure message %v", err)
+// releaseUpstreams clears the other connections that the fetcher of the given
+// peer connection receives the stream through. They are kept until the peer
+// connection is connected, so the fetcher never loses the stream while it
+// moves to another forwarder.
+func (c *Coordinator) releaseUpstreams(peerConn *database.ConnectionInfo) {
+	serverConn, err := c.database.FindDownstreamInfo(peerConn.ChannelID, peerConn.To)
+	if err != nil && !errors.Is(err, database.ErrConnectionNotFound) {
+		log.Printf("error occurs in finding downstream info %v", err)
+	}
+	if serverConn != nil {
+		if err := c.closeConnection(serverConn.ID); err != nil {
+			log.Printf("error occurs in closing connection %v", err)
+		}
+		if err := c.broker.Publish(broker.Media, broker.CLEAR, message.Clear{
+			ConnectionID: serverConn.ID,
+		}); err != nil {
+			log.Printf("error occurs in publishing closure message %v", err)
+		}
+	}
+
+	fetches, err := c.database.FindAllPeerConnectionInfoByTo(peerConn.ChannelID, peerConn.To)
+	if err != nil {
+		log.Printf("error occurs in finding connection info by to %v", err)
 		return
 	}
+	for _, fetch := range fetches {
+		if fetch.ID == peerConn.ID {
+			continue
+		}
+		c.clearPeerConnection(fetch)
+	}
 }
 
-// handlePeerDisconnected handles the succeed event. This event is about client to client
+// clearPeerConnection deletes the peer connection and tells both clients to clear it.
+func (c *Coordinator) clearPeerConnection(conn *database.ConnectionInfo) {
+	if err := c.closeConnection(conn.ID); err != nil {
+		log.Printf("error occurs in closing connection %v", err)
+		return
+	}
+	if conn.IsConnected() {
+		c.metric.DecrementPeerConnections()
+	}
+	for _, clientID := range []string{conn.From, conn.To} {
+		if err := c.broker.Publish(broker.ClientSocket, broker.Detail(conn.ChannelID+clientID), response.Clear{
+			Type:         response.CLEAR,
+			ConnectionID: conn.ID,
+		}); err != nil {
+			log.Printf("error occurs in publishing clear message %v", err)
+		}
+	}
+	if err := c.refreshCandidate(conn.ChannelID, conn.From); err != nil {
+		log.Printf("error occurs in refreshing candidate %v", err)
+	}
+}
+
+// handlePeerDisconnected handles the disconnected event. This event is about client to client
 func (c *Coordinator) handlePeerDisconnected(event any) {
 	msg, ok := event.(message.Disconnected)
 	if !ok {
 		log.Printf("error occurs in parsing failed message %v", event)
 		return
 	}
-	if err := c.database.DeleteConnectionInfoByID(msg.ConnectionID); err != nil {
+	connInfo, err := c.database.FindConnectionInfoByID(msg.ConnectionID)
+	if err != nil {
+		log.Printf("error occurs in finding connection info by connection id %v", err)
+		return
+	}
+	if _, err := c.database.UpdateConnectionInfo(connInfo.ID, database.Disconnected); err != nil {
 		log.Printf("error occurs in updating connection info %v", err)
 		return
 	}
+	c.clearPeerConnection(connInfo)
 }
 
-func (c *Coordinator) balance(channelID, fetcherID string) error {
-	if !c.config.SetPeerConnection {
-		return nil
+// closeConnection moves the connection to Closed and removes it from the
+// topology. It fails if the connection is already closed. The viewer time of
+// the connection is settled.
+func (c *Coordinator) closeConnection(connectionID string) error {
+	connInfo, err := c.database.UpdateConnectionInfo(connectionID, database.Closed)
+	if err != nil {
+		return fmt.Errorf("error occurs in updating connection info %w", err)
 	}
-	log.Printf("balancing %s %s", channelID, fetcherID)
+	if err := c.database.DeleteConnectionInfoByID(connectionID); err != nil {
+		return fmt.Errorf("error occurs in deleting connection info %w", err)
+	}
+	c.settleAccount(connInfo)
+	return nil
+}
 
-	fetcher, err := c.database.FindClientInfoByID(channelID, fetcherID)
+// balance finds a forwarder for the fetcher and tells the fetcher to fetch from
+// it. Nothing is done if the delivery between clients is off in the channel.
+// Clients in excludes are never selected, e.g. the current forwarder of the
+// fetcher when it is moved to another one. If there is no forwarder, the
+// fetcher is added to the pool as a forwarder candidate and ErrNoForwarder is
+// returned.
+func (c *Coordinator) balance(channelID, fetcherID string, excludes ...string) error {
+	channel, err := c.database.FindChannelInfoByID(channelID)
 	if err != nil {
-		return fmt.Errorf("error finding client info: %v", err)
+		return fmt.Errorf("error occurs in finding channel info %w", err)
 	}
+	enabled, err := c.peerToPeerEnabled(channel)
+	if err != nil || !enabled {
+		return err
+	}
+	log.Printf("balancing %s %s", channelID, fetcherID)
 
-	forwarderInfo := c.pool.GetTopForwarder(channelID)
-	if forwarderInfo == nil {
-		log.Printf("no forwarder found%v", forwarderInfo)
-
-		if err := c.pool.AddClient(*fetcher); err != nil {
+	forwarderInfo, err := c.findForwarder(channelID, fetcherID, excludes...)
+	if errors.Is(err, ErrNoForwarder) {
+		log.Printf("no forwarder found for %s", fetcherID)
+		if err := c.refreshCandidate(channelID, fetcherID); err != nil {
 			return fmt.Errorf("error occurs in adding client info to forward %v", err)
 		}
-		log.Printf("added forward info to pool")
-		return nil
+		return err
+	}
+	if err != nil {
+		return err
 	}
 	log.Printf("found forwarder %v", forwarderInfo)
 
-	peerConn, err := c.database.CreatePeerConnectionInfo(channelID, forwarderInfo.ID, fetcherID, shortuuid.New())
+	return c.assign(channelID, forwarderInfo.ID, fetcherID)
+}
+
+// findForwarder finds the best forwarder for the fetcher. The fetcher, its
+// descendants and the forwarders that recently failed with it are never
+// selected, and the forwarder must be shallow enough to keep the descendants
+// of the fetcher within MaxDepth.
+func (c *Coordinator) findForwarder(channelID, fetcherID string, excludes ...string) (*database.ClientInfo, error) {
+	channel, err := c.database.FindChannelInfoByID(channelID)
+	if err != nil {
+		return nil, fmt.Errorf("error occurs in finding channel info %w", err)
+	}
+	descendants, height, err := c.findDescendants(channelID, fetcherID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding descendants: %v", err)
+	}
+	excludes = append(excludes, fetcherID)
+	excludes = append(excludes, c.failedPairs.forwarders(channelID, fetcherID)...)
+	forwarderInfo := c.pool.GetTopForwarder(channelID, append(excludes, descendants...)...)
+
+	// NOTE: The pool prefers the shallowest forwarder. So if the top forwarder
+	// is too deep, there is no forwarder that the fetcher can fetch from.
+	if forwarderInfo == nil || forwarderInfo.Depth+1+height > channel.Policy.MaxDepth {
+		return nil, ErrNoForwarder
+	}
+	return forwarderInfo, nil
+}
+
+// assign creates a peer connection from the forwarder to the fetcher and tells
+// the fetcher to fetch from the forwarder.
+func (c *Coordinator) assign(channelID, forwarderID, fetcherID string) error {
+	peerConn, err := c.database.CreatePeerConnectionInfo(channelID, forwarderID, fetcherID, shortuuid.New())
 	if err != nil {
 		return fmt.Errorf("error occurs in creating peer connection info %v", err)
 	}
 
 	c.metric.IncrementBalancingOccurs()
-	if err := c.pool.UpdateClientScore(forwarderInfo.ID, channelID, c.config.MaxForwardingNumber); err != nil {
+	if err := c.refreshCandidate(channelID, forwarderID); err != nil {
 		return fmt.Errorf("error occurs in updating client score %v", err)
 	}
 	if err := c.broker.Publish(broker.ClientSocket, broker.Detail(channelID+fetcherID), response.Forward{
@@ -423,3 +777,109 @@ func (c *Coordinator) balance(channelID, fetcherID string) error {
 	}
 	return nil
 }
+
+// setDepth records the delivery depth of the client and propagates it to the
+// clients fetching from the client. The score of the client in the pool is
+// also updated, and the client is removed from the pool if it is too deep to
+// forward.
+func (c *Coordinator) setDepth(channelID, clientID string, depth int) error {
+	visited := make(map[string]bool)
+	return c.propagateDepth(channelID, clientID, depth, visited)
+}
+
+// propagateDepth sets the depth of the client and its fetchers recursively.
+// The visited map prevents infinite recursion when the topology has a cycle.
+func (c *Coordinator) propagateDepth(channelID, clientID string, depth int, visited map[string]bool) error {
+	if visited[clientID] {
+		return fmt.Errorf("cycle detected in delivery tree at %s", clientID)
+	}
+	visited[clientID] = true
+
+	if _, err := c.database.UpdateClientDepth(channelID, clientID, depth); err != nil {
+		return fmt.Errorf("error occurs in updating client depth %w", err)
+	}
+	c.metric.ObserveClientDepth(depth)
+
+	if err := c.refreshCandidate(channelID, clientID); err != nil {
+		return err
+	}
+
+	forwards, err := c.database.FindAllPeerConnectionInfoByFrom(channelID, clientID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding forwarding connections %w", err)
+	}
+	for _, forward := range forwards {
+		if !forward.IsConnected() {
+			continue
+		}
+		if err := c.propagateDepth(channelID, forward.To, depth+1, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshCandidate recalculates the capacity of the client and updates the
+// client in the pool. The client is removed from the pool if it is too deep to
+// forward or the delivery between clients is off in the channel, and it is
+// added or rescored if it already receives the stream.
+func (c *Coordinator) refreshCandidate(channelID, clientID string) error {
+	channel, err := c.database.FindChannelInfoByID(channelID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding channel info %w", err)
+	}
+	client, err := c.database.FindClientInfoByID(channelID, clientID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding client info %w", err)
+	}
+	if client, err = c.refreshCapacity(channel, client); err != nil {
+		return err
+	}
+	enabled, err := c.peerToPeerEnabled(channel)
+	if err != nil {
+		return err
+	}
+	if !enabled || client.Depth >= channel.Policy.MaxDepth {
+		c.pool.RemoveClient(client.ID, client.ChannelID)
+		return nil
+	}
+	if client.Depth == 0 {
+		return nil
+	}
+	if err := c.pool.UpdateClientScore(client.ID, client.ChannelID); err != nil {
+		return fmt.Errorf("error occurs in updating client score %w", err)
+	}
+	return nil
+}
+
+// findDescendants returns the clients that fetch from the given client directly
+// or indirectly, and the height of the subtree under the client. They can't be
+// a forwarder of the client without making a cycle.
+func (c *Coordinator) findDescendants(channelID, clientID string) ([]string, int, error) {
+	var descendants []string
+	height := 0
+	visited := map[string]bool{clientID: true}
+	level := []string{clientID}
+	for len(level) > 0 {
+		var next []string
+		for _, current := range level {
+			forwards, err := c.database.FindAllPeerConnectionInfoByFrom(channelID, current)
+			if err != nil {
+				return nil, 0, err
+			}
+			for _, forward := range forwards {
+				if visited[forward.To] {
+					continue
+				}
+				visited[forward.To] = true
+				descendants = append(descendants, forward.To)
+				next = append(next, forward.To)
+			}
+		}
+		if len(next) > 0 {
+			height++
+		}
+		level = next
+	}
+	return descendants, height, nil
+}
@@ -0,0 +1,429 @@
+package coordinator_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pdn/broker"
+	"pdn/coordinator"
+	"pdn/database"
+	"pdn/database/memory"
+	"pdn/metric"
+	"pdn/pool"
+	"pdn/types/message"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConfig returns the default configuration with the periodic jobs slowed
+// down, so they don't interfere with the test.
+func testConfig() coordinator.Config {
+	return coordinator.Config{
+		MaxForwardingNumber: coordinator.DefaultMaxForwardingNumber,
+		MaxForwardingCap:    coordinator.DefaultMaxForwardingCap,
+		StreamBitrate:       coordinator.DefaultStreamBitrate,
+		MaxPacketLoss:       coordinator.DefaultMaxPacketLoss,
+		MaxRTT:              coordinator.DefaultMaxRTT,
+		SuperPeerCap:        coordinator.DefaultSuperPeerCap,
+		SuperPeerQuality:    coordinator.DefaultSuperPeerQuality,
+		SuperPeerInterval:   time.Hour,
+		OfferTimeout:        coordinator.DefaultOfferTimeout,
+		AnswerTimeout:       coordinator.DefaultAnswerTimeout,
+		ConnectTimeout:      coordinator.DefaultConnectTimeout,
+		SweepInterval:       time.Hour,
+	}
+}
+
+// slowDatabase delays reads to widen the window between reading and writing a
+// client, so events handled in parallel would surely lose updates.
+type slowDatabase struct {
+	database.Database
+}
+
+// FindClientInfoByID finds the client info and delays.
+func (d slowDatabase) FindClientInfoByID(channelID, clientID string) (*database.ClientInfo, error) {
+	client, err := d.Database.FindClientInfoByID(channelID, clientID)
+	time.Sleep(time.Millisecond)
+	return client, err
+}
+
+// TestEventsSerializedPerChannel tests that the events of a channel are handled
+// in order, while many channels publish events at the same time. Every healthy
+// report recovers the upload quality of the forwarder by read-modify-write, so
+// a report handled in parallel with another one of the same channel is lost.
+// Run with -race to detect data races as well.
+func TestEventsSerializedPerChannel(t *testing.T) {
+	const (
+		channels = 50
+		reports  = 9
+	)
+
+	db := slowDatabase{memory.New(database.Config{AutoCreateChannels: true})}
+	strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+	require.NoError(t, err)
+	m := metric.New(metric.Config{})
+	b := broker.New(m)
+	c := coordinator.New(testConfig(), b, m, db, pool.New(db, strategy))
+
+	connections := make(map[string]string)
+	for i := range channels {
+		channelID := fmt.Sprintf("channel-%d", i)
+		_, err := db.FindOrCreateChannelInfoByID(channelID)
+		require.NoError(t, err)
+		require.NoError(t, db.CreateClientInfo(channelID, "forwarder"))
+		require.NoError(t, db.CreateClientInfo(channelID, "fetcher"))
+		_, err = db.UpdateClientUploadQuality(channelID, "forwarder", 0)
+		require.NoError(t, err)
+		conn, err := db.CreatePeerConnectionInfo(channelID, "forwarder", "fetcher", channelID+"-conn")
+		require.NoError(t, err)
+		_, err = db.UpdateConnectionInfo(conn.ID, database.Connected)
+		require.NoError(t, err)
+		connections[channelID] = conn.ID
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.Start(ctx)
+	require.Eventually(t, func() bool {
+		return b.Publish(broker.Client, broker.HEALTH, message.Health{}) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for channelID, connectionID := range connections {
+		for range reports {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, b.Publish(broker.Client, broker.HEALTH, message.Health{
+					ChannelID: channelID,
+					ClientID:  "fetcher",
+					Reports:   map[string]database.Health{connectionID: {}},
+				}))
+			}()
+		}
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		for channelID := range connections {
+			forwarder, err := db.FindClientInfoByID(channelID, "forwarder")
+			if err != nil || forwarder.UploadQuality < 0.1*reports-0.001 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestEventsOrderedPerClient tests that the events a client publishes one after
+// another are handled in the order they are published, although they go to
+// different details. A PULL is handled only while the client is active, and an
+// ACTIVATE handled after the DEACTIVATE leaves the client behind. Run with
+// -race to detect data races as well.
+func TestEventsOrderedPerClient(t *testing.T) {
+	const (
+		channels = 20
+		clients  = 50
+	)
+
+	db := memory.New(database.Config{AutoCreateChannels: true})
+	strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+	require.NoError(t, err)
+	m := metric.New(metric.Config{})
+	b := broker.New(m)
+	c := coordinator.New(testConfig(), b, m, db, pool.New(db, strategy))
+
+	for i := range channels {
+		channelID := fmt.Sprintf("channel-%d", i)
+		_, err := db.FindOrCreateChannelInfoByID(channelID)
+		require.NoError(t, err)
+		require.NoError(t, db.CreateClientInfo(channelID, "broadcaster"))
+		_, err = db.CreatePushConnectionInfo(channelID, "broadcaster", channelID+"-upstream")
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.Start(ctx)
+	require.Eventually(t, func() bool {
+		return b.Publish(broker.Client, broker.HEALTH, message.Health{}) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := range channels {
+		channelID := fmt.Sprintf("channel-%d", i)
+		for j := range clients {
+			clientID := fmt.Sprintf("client-%d", j)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, b.Publish(broker.Client, broker.ACTIVATE, message.Activate{
+					ChannelID: channelID,
+					ClientID:  clientID,
+				}))
+				assert.NoError(t, b.Publish(broker.Client, broker.PULL, message.Pull{
+					ConnectionID: channelID + clientID,
+					ChannelID:    channelID,
+					ClientID:     clientID,
+				}))
+				assert.NoError(t, b.Publish(broker.Client, broker.DEACTIVATE, message.Deactivate{
+					ChannelID: channelID,
+					ClientID:  clientID,
+				}))
+			}()
+		}
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		for i := range channels {
+			channelID := fmt.Sprintf("channel-%d", i)
+			infos, err := db.FindAllClientInfosByChannelID(channelID)
+			if err != nil || len(infos) != 1 {
+				return false
+			}
+			connections, err := db.FindAllConnectionInfosByChannelID(channelID)
+			if err != nil || len(connections) != 1+clients {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestHealthUpdatesCapacity tests that an unhealthy report of the fetcher
+// lowers the upload quality of the forwarder and so its forwarding capacity.
+func TestHealthUpdatesCapacity(t *testing.T) {
+	const channelID = "channel"
+
+	db := memory.New(database.Config{AutoCreateChannels: true})
+	strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+	require.NoError(t, err)
+	m := metric.New(metric.Config{})
+	b := broker.New(m)
+	c := coordinator.New(testConfig(), b, m, db, pool.New(db, strategy))
+
+	_, err = db.FindOrCreateChannelInfoByID(channelID)
+	require.NoError(t, err)
+	require.NoError(t, db.CreateClientInfo(channelID, "forwarder"))
+	require.NoError(t, db.CreateClientInfo(channelID, "fetcher"))
+	_, err = db.UpdateClientCapabilities(channelID, "forwarder", database.Capabilities{
+		UploadBandwidth: 4 * coordinator.DefaultStreamBitrate,
+	})
+	require.NoError(t, err)
+	conn, err := db.CreatePeerConnectionInfo(channelID, "forwarder", "fetcher", "conn")
+	require.NoError(t, err)
+	_, err = db.UpdateConnectionInfo(conn.ID, database.Connected)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.Start(ctx)
+	require.Eventually(t, func() bool {
+		return b.Publish(broker.Client, broker.HEALTH, message.Health{}) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, b.Publish(broker.Client, broker.HEALTH, message.Health{
+		ChannelID: channelID,
+		ClientID:  "fetcher",
+		Reports:   map[string]database.Health{conn.ID: {PacketLoss: 1}},
+	}))
+	require.Eventually(t, func() bool {
+		forwarder, err := db.FindClientInfoByID(channelID, "forwarder")
+		return err == nil && forwarder.Capacity == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestSweepExpiredConnections tests that peer connections missing the deadline
+// of their negotiation phase are failed and removed, while the others are kept.
+func TestSweepExpiredConnections(t *testing.T) {
+	const channelID = "channel"
+
+	db := memory.New(database.Config{AutoCreateChannels: true})
+	strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+	require.NoError(t, err)
+	config := testConfig()
+	config.OfferTimeout = time.Millisecond
+	config.SweepInterval = 10 * time.Millisecond
+	m := metric.New(metric.Config{})
+	c := coordinator.New(config, broker.New(m), m, db, pool.New(db, strategy))
+
+	_, err = db.FindOrCreateChannelInfoByID(channelID)
+	require.NoError(t, err)
+	for _, clientID := range []string{"forwarder", "fetcher", "offerer"} {
+		require.NoError(t, db.CreateClientInfo(channelID, clientID))
+	}
+	stuck, err := db.CreatePeerConnectionInfo(channelID, "forwarder", "fetcher", "stuck")
+	require.NoError(t, err)
+	offered, err := db.CreatePeerConnectionInfo(channelID, "forwarder", "offerer", "offered")
+	require.NoError(t, err)
+	_, err = db.UpdateConnectionInfo(offered.ID, database.Offered)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		_, err := db.FindConnectionInfoByID(stuck.ID)
+		return errors.Is(err, database.ErrConnectionNotFound)
+	}, time.Second, 10*time.Millisecond)
+	_, err = db.FindConnectionInfoByID(offered.ID)
+	assert.NoError(t, err)
+}
+
+// TestOffloadAccounting tests that the bytes sent by Media server and the
+// bytes received by fetchers are counted by their source, and that the
+// reports of forwarders are not counted.
+func TestOffloadAccounting(t *testing.T) {
+	const channelID = "channel"
+
+	db := memory.New(database.Config{AutoCreateChannels: true})
+	strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+	require.NoError(t, err)
+	m := metric.New(metric.Config{})
+	b := broker.New(m)
+	c := coordinator.New(testConfig(), b, m, db, pool.New(db, strategy))
+
+	_, err = db.FindOrCreateChannelInfoByID(channelID)
+	require.NoError(t, err)
+	for _, clientID := range []string{"forwarder", "fetcher"} {
+		require.NoError(t, db.CreateClientInfo(channelID, clientID))
+	}
+	pull, err := db.CreatePullConnectionInfo(channelID, "forwarder", "pull")
+	require.NoError(t, err)
+	peer, err := db.CreatePeerConnectionInfo(channelID, "forwarder", "fetcher", "peer")
+	require.NoError(t, err)
+	for _, id := range []string{pull.ID, peer.ID} {
+		_, err = db.UpdateConnectionInfo(id, database.Connected)
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.Start(ctx)
+	require.Eventually(t, func() bool {
+		return b.Publish(broker.Media, broker.STATS, message.Stats{ConnectionID: pull.ID, Bytes: 1000}) == nil
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, b.Publish(broker.Media, broker.STATS, message.Stats{ConnectionID: pull.ID, Bytes: 3000}))
+	for clientID, bytes := range map[string]int64{"fetcher": 6000, "forwarder": 100000} {
+		require.NoError(t, b.Publish(broker.Client, broker.HEALTH, message.Health{
+			ChannelID: channelID,
+			ClientID:  clientID,
+			Reports:   map[string]database.Health{peer.ID: {BytesReceived: bytes}},
+		}))
+	}
+
+	require.Eventually(t, func() bool {
+		offload := c.Offload(channelID)
+		return offload.ServerBytes == 3000 && offload.PeerBytes == 6000
+	}, time.Second, 10*time.Millisecond)
+	offload := c.Offload(channelID)
+	assert.InDelta(t, 2.0/3, offload.Ratio(), 0.001)
+	assert.Positive(t, offload.ServerTime)
+	assert.Positive(t, offload.PeerTime)
+}
+
+// TestOrphanNotOffered tests that a fetcher whose forwarder left is not offered
+// to a new fetcher while it receives nothing, even if there is no other
+// candidate.
+func TestOrphanNotOffered(t *testing.T) {
+	const channelID = "channel"
+
+	db := memory.New(database.Config{DefaultPolicy: database.Policy{PeerToPeer: true, MaxDepth: 3}})
+	strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+	require.NoError(t, err)
+	m := metric.New(metric.Config{})
+	b := broker.New(m)
+	c := coordinator.New(testConfig(), b, m, db, pool.New(db, strategy))
+
+	key, err := database.NewChannelKey("key")
+	require.NoError(t, err)
+	_, err = db.CreateChannelInfo(channelID, key)
+	require.NoError(t, err)
+	for _, clientID := range []string{"forwarder", "orphan", "viewer", "marker"} {
+		require.NoError(t, db.CreateClientInfo(channelID, clientID))
+	}
+	peer, err := db.CreatePeerConnectionInfo(channelID, "forwarder", "orphan", "peer")
+	require.NoError(t, err)
+	_, err = db.UpdateConnectionInfo(peer.ID, database.Connected)
+	require.NoError(t, err)
+	pulls := make(map[string]string)
+	for _, clientID := range []string{"forwarder", "viewer"} {
+		pull, err := db.CreatePullConnectionInfo(channelID, clientID, clientID+"-pull")
+		require.NoError(t, err)
+		_, err = db.UpdateConnectionInfo(pull.ID, database.Answered)
+		require.NoError(t, err)
+		pulls[clientID] = pull.ID
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.Start(ctx)
+	require.Eventually(t, func() bool {
+		return b.Publish(broker.Client, broker.HEALTH, message.Health{}) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// given the forwarder receiving from Media server and the orphan from it
+	require.NoError(t, b.Publish(broker.Media, broker.CONNECTED, message.Connected{ConnectionID: pulls["forwarder"]}))
+	require.Eventually(t, func() bool {
+		orphan, err := db.FindClientInfoByID(channelID, "orphan")
+		return err == nil && orphan.Depth == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// when the forwarder leaves and a viewer starts receiving from Media server
+	require.NoError(t, b.Publish(broker.Client, broker.DEACTIVATE, message.Deactivate{ChannelID: channelID, ClientID: "forwarder"}))
+	require.NoError(t, b.Publish(broker.Media, broker.CONNECTED, message.Connected{ConnectionID: pulls["viewer"]}))
+	require.NoError(t, b.Publish(broker.Client, broker.DEACTIVATE, message.Deactivate{ChannelID: channelID, ClientID: "marker"}))
+	require.Eventually(t, func() bool {
+		_, err := db.FindClientInfoByID(channelID, "marker")
+		return errors.Is(err, database.ErrClientNotFound)
+	}, time.Second, 10*time.Millisecond)
+
+	// then the viewer is not offered the orphan
+	forwards, err := db.FindAllPeerConnectionInfoByFrom(channelID, "orphan")
+	require.NoError(t, err)
+	assert.Empty(t, forwards)
+	orphan, err := db.FindClientInfoByID(channelID, "orphan")
+	require.NoError(t, err)
+	assert.Zero(t, orphan.Depth)
+}
+
+// TestStopHandlesQueuedEvents tests that the events published before the
+// coordinator stops are handled before it returns, like the DEACTIVATEs of the
+// clients drained on shutdown.
+func TestStopHandlesQueuedEvents(t *testing.T) {
+	const channelID = "channel"
+
+	for range 20 {
+		db := memory.New(database.Config{AutoCreateChannels: true})
+		strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+		require.NoError(t, err)
+		m := metric.New(metric.Config{})
+		b := broker.New(m)
+		c := coordinator.New(testConfig(), b, m, db, pool.New(db, strategy))
+		_, err = db.FindOrCreateChannelInfoByID(channelID)
+		require.NoError(t, err)
+		require.NoError(t, db.CreateClientInfo(channelID, "client"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			c.Start(ctx)
+		}()
+		require.Eventually(t, func() bool {
+			return b.Publish(broker.Client, broker.HEALTH, message.Health{}) == nil
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, b.Publish(broker.Client, broker.DEACTIVATE, message.Deactivate{ChannelID: channelID, ClientID: "client"}))
+		cancel()
+		<-stopped
+
+		_, err = db.FindClientInfoByID(channelID, "client")
+		require.ErrorIs(t, err, database.ErrClientNotFound)
+	}
+}
@@ -0,0 +1,101 @@
+package coordinator
+
+import (
+	"log"
+	"pdn/database"
+	"sync"
+	"time"
+)
+
+// pair is a forwarder and a fetcher in a channel.
+type pair struct {
+	forwarder string
+	fetcher   string
+}
+
+// failedPairs keeps the pairs whose peer connection failed until their record
+// expires, e.g. two peers behind incompatible NATs. They are never paired
+// again while the record is kept. The records are kept per channel, so that a
+// channel only scans its own pairs.
+type failedPairs struct {
+	mu      sync.Mutex
+	expires map[string]map[pair]time.Time
+}
+
+// newFailedPairs creates a new failedPairs.
+func newFailedPairs() *failedPairs {
+	return &failedPairs{
+		expires: make(map[string]map[pair]time.Time),
+	}
+}
+
+// record records that the pair failed in the channel. The record expires
+// after the ttl.
+func (f *failedPairs) record(channelID string, p pair, ttl time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pairs, ok := f.expires[channelID]
+	if !ok {
+		pairs = make(map[pair]time.Time)
+		f.expires[channelID] = pairs
+	}
+	pairs[p] = time.Now().Add(ttl)
+}
+
+// forwarders returns the forwarders that failed with the fetcher and are not
+// expired yet.
+func (f *failedPairs) forwarders(channelID, fetcherID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	var forwarders []string
+	for p, expire := range f.expires[channelID] {
+		if p.fetcher == fetcherID && now.Before(expire) {
+			forwarders = append(forwarders, p.forwarder)
+		}
+	}
+	return forwarders
+}
+
+// prune removes the expired records, and the channels left without records.
+func (f *failedPairs) prune() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for channelID, pairs := range f.expires {
+		for p, expire := range pairs {
+			if !now.Before(expire) {
+				delete(pairs, p)
+			}
+		}
+		if len(pairs) == 0 {
+			delete(f.expires, channelID)
+		}
+	}
+}
+
+// forget removes the records of the channel.
+func (f *failedPairs) forget(channelID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.expires, channelID)
+}
+
+// recordPeerResult counts the result of the peer connection for both clients.
+// If it failed, the pair is also recorded so that they aren't paired again.
+func (c *Coordinator) recordPeerResult(conn *database.ConnectionInfo, succeeded bool) {
+	if !succeeded && c.config.FailedPairTTL > 0 {
+		c.failedPairs.record(conn.ChannelID, pair{
+			forwarder: conn.From,
+			fetcher:   conn.To,
+		}, c.config.FailedPairTTL)
+	}
+	for _, clientID := range []string{conn.From, conn.To} {
+		if _, err := c.database.UpdateClientPeerResult(conn.ChannelID, clientID, succeeded); err != nil {
+			log.Printf("error occurs in updating peer result %v", err)
+		}
+	}
+}
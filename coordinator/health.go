@@ -0,0 +1,101 @@
+package coordinator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"pdn/database"
+	"pdn/types/message"
+)
+
+// Constants for adjusting the upload quality of a forwarder by health reports.
+// Every unhealthy report halves the quality, and every healthy report
+// recovers it gradually.
+const (
+	qualityPenalty  = 0.5
+	qualityRecovery = 0.1
+)
+
+// handleHealth handles the health event. health event means that a client
+// reports the stats of its connections. Only the reports of fetchers are used
+// to check the health of peer connections and to count the bytes delivered by
+// peers, because only the fetcher can tell how well the stream arrives.
+func (c *Coordinator) handleHealth(event any) {
+	msg, ok := event.(message.Health)
+	if !ok {
+		log.Printf("error occurs in parsing health message %v", event)
+		return
+	}
+
+	for connectionID, health := range msg.Reports {
+		connInfo, err := c.database.UpdateConnectionHealth(connectionID, health)
+		if err != nil {
+			log.Printf("error occurs in updating connection health %v", err)
+			continue
+		}
+		if !connInfo.IsPeerConnection() || connInfo.To != msg.ClientID {
+			continue
+		}
+		if health.BytesReceived > 0 {
+			c.account(connInfo, health.BytesReceived)
+		}
+		if !connInfo.IsConnected() {
+			continue
+		}
+		if err := c.checkHealth(connInfo); err != nil {
+			log.Printf("error occurs in checking health %v", err)
+		}
+	}
+}
+
+// isHealthy checks if the reported stats are within the configured thresholds.
+func (c *Coordinator) isHealthy(health database.Health) bool {
+	if health.PacketLoss > c.config.MaxPacketLoss {
+		return false
+	}
+	if c.config.MaxRTT > 0 && health.RTT > c.config.MaxRTT {
+		return false
+	}
+	return true
+}
+
+// checkHealth adjusts the upload quality of the forwarder by the health of the
+// peer connection. If the connection is unhealthy, the fetcher is moved to
+// another forwarder. The unhealthy connection is kept until the new one is
+// connected.
+func (c *Coordinator) checkHealth(conn *database.ConnectionInfo) error {
+	forwarder, err := c.database.FindClientInfoByID(conn.ChannelID, conn.From)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding forwarder info %w", err)
+	}
+
+	if c.isHealthy(conn.Health) {
+		if forwarder.UploadQuality >= 1 {
+			return nil
+		}
+		return c.updateUploadQuality(conn.ChannelID, conn.From, forwarder.UploadQuality+qualityRecovery)
+	}
+
+	log.Printf("unhealthy connection %s from %s to %s", conn.ID, conn.From, conn.To)
+	c.metric.IncrementUnhealthyConnections()
+	if err := c.updateUploadQuality(conn.ChannelID, conn.From, forwarder.UploadQuality*qualityPenalty); err != nil {
+		return err
+	}
+
+	// NOTE: If the fetcher has another pending connection, it is already
+	// moving to another forwarder.
+	fetches, err := c.database.FindAllPeerConnectionInfoByTo(conn.ChannelID, conn.To)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding connection info by to %w", err)
+	}
+	for _, fetch := range fetches {
+		if fetch.ID != conn.ID && !fetch.IsConnected() {
+			return nil
+		}
+	}
+
+	if err := c.balance(conn.ChannelID, conn.To, conn.From); err != nil && !errors.Is(err, ErrNoForwarder) {
+		return err
+	}
+	return nil
+}
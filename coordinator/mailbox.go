@@ -0,0 +1,135 @@
+package coordinator
+
+import (
+	"log"
+	"pdn/types/message"
+	"runtime/debug"
+	"sync"
+)
+
+// mailboxes runs tasks of a key one by one in the order they are dispatched,
+// while tasks of different keys run in parallel. It keeps no order the
+// dispatcher doesn't have, so the caller must dispatch in the order to keep.
+// A mailbox is created with its goroutine when a task arrives and removed
+// when it becomes empty, so idle channels don't hold goroutines.
+type mailboxes struct {
+	mu    sync.Mutex
+	boxes map[string]*mailbox
+	wg    sync.WaitGroup
+}
+
+// mailbox is a queue of tasks for a key.
+type mailbox struct {
+	queue []func()
+}
+
+// newMailboxes creates a new mailboxes.
+func newMailboxes() *mailboxes {
+	return &mailboxes{
+		boxes: make(map[string]*mailbox),
+	}
+}
+
+// dispatch queues the task to the mailbox of the key.
+func (m *mailboxes) dispatch(key string, task func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if box, ok := m.boxes[key]; ok {
+		box.queue = append(box.queue, task)
+		return
+	}
+	box := &mailbox{queue: []func(){task}}
+	m.boxes[key] = box
+	m.wg.Add(1)
+	go m.run(key, box)
+}
+
+// wait waits until the tasks of all mailboxes are done.
+func (m *mailboxes) wait() {
+	m.wg.Wait()
+}
+
+// run runs the tasks of the mailbox until it becomes empty.
+func (m *mailboxes) run(key string, box *mailbox) {
+	defer m.wg.Done()
+	for {
+		m.mu.Lock()
+		if len(box.queue) == 0 {
+			delete(m.boxes, key)
+			m.mu.Unlock()
+			return
+		}
+		task := box.queue[0]
+		box.queue[0] = nil
+		box.queue = box.queue[1:]
+		m.mu.Unlock()
+
+		execute(key, task)
+	}
+}
+
+// execute runs the task and recovers a panic, so a broken event never stops
+// the other events of the channel.
+func execute(key string, task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("!!! PANIC RECOVERED in mailbox %s: %v", key, r)
+			log.Printf("Stack trace:\n%s", debug.Stack())
+		}
+	}()
+	task()
+}
+
+// dispatch queues the handler of the event to the mailbox of the channel that
+// the event belongs to. So the events of a channel are handled in the order
+// they are received, and a client never races with itself, e.g. DEACTIVATE
+// with CONNECTED.
+func (c *Coordinator) dispatch(event any, handler func(any)) {
+	c.mailboxes.dispatch(c.channelOf(event), func() {
+		handler(event)
+	})
+}
+
+// channelOf returns the channel ID that the event belongs to. Events about a
+// connection are looked up by the connection ID. If the connection is already
+// gone, the empty key is returned and the handler just finds nothing.
+func (c *Coordinator) channelOf(event any) string {
+	var connectionID string
+	switch msg := event.(type) {
+	case message.Activate:
+		return msg.ChannelID
+	case message.Deactivate:
+		return msg.ChannelID
+	case message.Update:
+		return msg.ChannelID
+	case message.Health:
+		return msg.ChannelID
+	case message.Push:
+		return msg.ChannelID
+	case message.Pull:
+		return msg.ChannelID
+	case message.Offered:
+		connectionID = msg.ConnectionID
+	case message.Answered:
+		connectionID = msg.ConnectionID
+	case message.Connecting:
+		connectionID = msg.ConnectionID
+	case message.Connected:
+		connectionID = msg.ConnectionID
+	case message.Disconnected:
+		connectionID = msg.ConnectionID
+	case message.Failed:
+		connectionID = msg.ConnectionID
+	case message.Stats:
+		connectionID = msg.ConnectionID
+	default:
+		return ""
+	}
+
+	connInfo, err := c.database.FindConnectionInfoByID(connectionID)
+	if err != nil {
+		return ""
+	}
+	return connInfo.ChannelID
+}
@@ -0,0 +1,242 @@
+package coordinator
+
+import (
+	"fmt"
+	"log"
+	"pdn/database"
+	"pdn/types/message"
+	"sync"
+	"time"
+)
+
+// Sources of the stream delivered to viewers.
+const (
+	SourceServer = "server"
+	SourcePeer   = "peer"
+)
+
+// Offload is the stream delivered to the viewers of a channel, split into the
+// one delivered by Media server and the one delivered by peers.
+type Offload struct {
+	ServerBytes int64
+	PeerBytes   int64
+	ServerTime  time.Duration
+	PeerTime    time.Duration
+}
+
+// Ratio returns the ratio of the bytes delivered by peers to all the bytes
+// delivered. It is zero if nothing is delivered yet.
+func (o Offload) Ratio() float64 {
+	total := o.ServerBytes + o.PeerBytes
+	if total == 0 {
+		return 0
+	}
+	return float64(o.PeerBytes) / float64(total)
+}
+
+// String returns the summary of the offload.
+func (o Offload) String() string {
+	return fmt.Sprintf("server %d bytes for %.1f viewer-minutes, peer %d bytes for %.1f viewer-minutes, offload %.1f%%",
+		o.ServerBytes, o.ServerTime.Minutes(), o.PeerBytes, o.PeerTime.Minutes(), o.Ratio()*100)
+}
+
+// delivery is the stream delivered through a connection since the last
+// record, and the offload ratio of the channel after it.
+type delivery struct {
+	source string
+	bytes  int64
+	time   time.Duration
+	ratio  float64
+}
+
+// account is the last record of a connection.
+type account struct {
+	channelID string
+	bytes     int64
+	at        time.Time
+}
+
+// ledger accumulates the offload of channels from the total bytes reported
+// for connections. The viewer time is counted between the records, and from
+// the last record to the close of the connection.
+type ledger struct {
+	mu       sync.Mutex
+	channels map[string]*Offload
+	accounts map[string]account
+}
+
+// newLedger creates a new ledger.
+func newLedger() *ledger {
+	return &ledger{
+		channels: make(map[string]*Offload),
+		accounts: make(map[string]account),
+	}
+}
+
+// sourceOf returns the source of the stream delivered through the connection,
+// or empty if the connection doesn't deliver to a viewer.
+func sourceOf(conn *database.ConnectionInfo) string {
+	switch conn.Type {
+	case database.PullFromServer:
+		return SourceServer
+	case database.PeerToPeer:
+		return SourcePeer
+	default:
+		return ""
+	}
+}
+
+// record records the total bytes delivered through the connection, and
+// returns what is delivered since the last record. A total less than the last
+// one means that the counter was reset, so all of it is new.
+func (l *ledger) record(conn *database.ConnectionInfo, bytes int64, now time.Time) delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	last, ok := l.accounts[conn.ID]
+	if !ok {
+		last = account{channelID: conn.ChannelID, at: conn.ConnectedAt}
+	}
+	l.accounts[conn.ID] = account{channelID: conn.ChannelID, bytes: bytes, at: now}
+	if bytes < last.bytes {
+		last.bytes = 0
+	}
+	return l.add(conn, bytes-last.bytes, last.at, now)
+}
+
+// settle counts the viewer time from the last record to now, when the
+// connection is closed, and forgets the connection.
+func (l *ledger) settle(conn *database.ConnectionInfo, now time.Time) delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	since := conn.ConnectedAt
+	if last, ok := l.accounts[conn.ID]; ok {
+		since = last.at
+		delete(l.accounts, conn.ID)
+	}
+	return l.add(conn, 0, since, now)
+}
+
+// add adds the bytes and the time since the given time to the channel of the
+// connection. A connection that was never connected has no viewer time.
+func (l *ledger) add(conn *database.ConnectionInfo, bytes int64, since, now time.Time) delivery {
+	d := delivery{source: sourceOf(conn), bytes: bytes}
+	if d.source == "" {
+		return d
+	}
+	if !since.IsZero() && now.After(since) {
+		d.time = now.Sub(since)
+	}
+
+	offload, ok := l.channels[conn.ChannelID]
+	if !ok {
+		offload = &Offload{}
+		l.channels[conn.ChannelID] = offload
+	}
+	switch d.source {
+	case SourceServer:
+		offload.ServerBytes += d.bytes
+		offload.ServerTime += d.time
+	case SourcePeer:
+		offload.PeerBytes += d.bytes
+		offload.PeerTime += d.time
+	}
+	d.ratio = offload.Ratio()
+	return d
+}
+
+// offload returns the offload of the channel so far.
+func (l *ledger) offload(channelID string) Offload {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offload, ok := l.channels[channelID]; ok {
+		return *offload
+	}
+	return Offload{}
+}
+
+// close removes the channel and its connections, and returns the offload of
+// the channel. It returns false if nothing was delivered in the channel.
+func (l *ledger) close(channelID string) (Offload, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for id, a := range l.accounts {
+		if a.channelID == channelID {
+			delete(l.accounts, id)
+		}
+	}
+	offload, ok := l.channels[channelID]
+	if !ok {
+		return Offload{}, false
+	}
+	delete(l.channels, channelID)
+	return *offload, true
+}
+
+// Offload returns the stream delivered to the viewers of the channel so far.
+func (c *Coordinator) Offload(channelID string) Offload {
+	return c.ledger.offload(channelID)
+}
+
+// handleMediaStats handles the stats event. This event is about the bytes
+// Media server sent to a client.
+func (c *Coordinator) handleMediaStats(event any) {
+	msg, ok := event.(message.Stats)
+	if !ok {
+		log.Printf("error occurs in parsing stats message %v", event)
+		return
+	}
+	connInfo, err := c.database.FindConnectionInfoByID(msg.ConnectionID)
+	if err != nil {
+		log.Printf("error occurs in finding connection info by connection id %v", err)
+		return
+	}
+	c.account(connInfo, msg.Bytes)
+}
+
+// account records the total bytes delivered through the connection. Nothing is
+// recorded once the channel is closed.
+func (c *Coordinator) account(conn *database.ConnectionInfo, bytes int64) {
+	if _, err := c.database.FindChannelInfoByID(conn.ChannelID); err != nil {
+		return
+	}
+	c.observeDelivery(conn.ChannelID, c.ledger.record(conn, bytes, time.Now()))
+}
+
+// settleAccount counts the viewer time of the closed connection.
+func (c *Coordinator) settleAccount(conn *database.ConnectionInfo) {
+	if _, err := c.database.FindChannelInfoByID(conn.ChannelID); err != nil {
+		return
+	}
+	c.observeDelivery(conn.ChannelID, c.ledger.settle(conn, time.Now()))
+}
+
+// observeDelivery updates the metrics of the channel by the delivery.
+func (c *Coordinator) observeDelivery(channelID string, d delivery) {
+	if d.source == "" {
+		return
+	}
+	c.metric.AddDeliveredBytes(channelID, d.source, d.bytes)
+	c.metric.AddViewerTime(channelID, d.source, d.time)
+	c.metric.SetOffloadRatio(channelID, d.ratio)
+}
+
+// closeAccounts settles the connections of the closing channel and writes the
+// summary of its offload. The metrics of the channel are deleted.
+func (c *Coordinator) closeAccounts(channelID string) {
+	conns, err := c.database.FindAllConnectionInfosByChannelID(channelID)
+	if err != nil {
+		log.Printf("error occurs in finding connection infos %v", err)
+	}
+	for _, conn := range conns {
+		c.settleAccount(conn)
+	}
+
+	if offload, ok := c.ledger.close(channelID); ok {
+		log.Printf("delivery summary of channel %s: %s", channelID, offload)
+	}
+	c.metric.DeleteChannelDelivery(channelID)
+}
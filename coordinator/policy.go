@@ -0,0 +1,100 @@
+package coordinator
+
+import (
+	"fmt"
+	"log"
+	"pdn/broker"
+	"pdn/database"
+	"pdn/types/client/response"
+)
+
+var (
+	// ErrInvalidPolicy is an error that occurs when a channel policy is invalid.
+	ErrInvalidPolicy = fmt.Errorf("invalid policy")
+)
+
+// peerToPeerEnabled checks if the delivery between clients is on in the
+// channel and the channel has enough viewers for it. The publisher is not
+// counted as a viewer.
+func (c *Coordinator) peerToPeerEnabled(channel *database.ChannelInfo) (bool, error) {
+	if !channel.Policy.PeerToPeer {
+		return false, nil
+	}
+	if channel.Policy.MinViewers == 0 {
+		return true, nil
+	}
+
+	clients, err := c.database.FindAllClientInfosByChannelID(channel.ID)
+	if err != nil {
+		return false, fmt.Errorf("error occurs in finding client infos %w", err)
+	}
+	viewers := len(clients)
+	if upstream, err := c.database.FindUpstreamInfo(channel.ID); err == nil && upstream != nil {
+		viewers--
+	}
+	return viewers >= channel.Policy.MinViewers, nil
+}
+
+// SetChannelPolicy changes the delivery policy of the channel at runtime. The
+// policy is applied to the clients of the channel in its mailbox.
+func (c *Coordinator) SetChannelPolicy(channelID string, policy database.Policy) (*database.ChannelInfo, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	channel, err := c.database.UpdateChannelPolicy(channelID, policy)
+	if err != nil {
+		return nil, fmt.Errorf("error occurs in updating channel policy %w", err)
+	}
+
+	c.mailboxes.dispatch(channelID, func() {
+		if err := c.applyPolicy(channelID); err != nil {
+			log.Printf("error occurs in applying policy of channel %s %v", channelID, err)
+		}
+	})
+	return channel, nil
+}
+
+// applyPolicy refreshes the capacity and the candidacy of every client in the
+// channel by its policy. If the delivery between clients is off, every
+// fetcher is told to fall back to Media server. The peer connections are
+// cleared when the fetcher receives the stream from Media server, so the
+// fetcher never loses the stream. Tighter limits of depth and fan-out are
+// applied by rebalancing.
+func (c *Coordinator) applyPolicy(channelID string) error {
+	channel, err := c.database.FindChannelInfoByID(channelID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding channel info %w", err)
+	}
+	clients, err := c.database.FindAllClientInfosByChannelID(channelID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding client infos %w", err)
+	}
+	for _, client := range clients {
+		if err := c.refreshCandidate(channelID, client.ID); err != nil {
+			return err
+		}
+	}
+	if channel.Policy.PeerToPeer {
+		return nil
+	}
+
+	for _, client := range clients {
+		forwards, err := c.database.FindAllPeerConnectionInfoByFrom(channelID, client.ID)
+		if err != nil {
+			return fmt.Errorf("error occurs in finding connection info by from %w", err)
+		}
+		for _, forward := range forwards {
+			if !forward.IsConnected() {
+				c.clearPeerConnection(forward)
+				continue
+			}
+			if err := c.broker.Publish(broker.ClientSocket, broker.Detail(channelID+forward.To), response.Fallback{
+				Type:         response.FALLBACK,
+				ConnectionID: forward.ID,
+			}); err != nil {
+				log.Printf("error occurs in publishing fallback message %v", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package coordinator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"pdn/database"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Reasons of moving a fetcher by rebalancing.
+const (
+	reasonOverloaded = "overloaded"
+	reasonDegraded   = "degraded"
+	reasonDeep       = "deep"
+	reasonServer     = "server"
+)
+
+// rebalancer keeps the state of the periodic rebalancing.
+type rebalancer struct {
+	mu        sync.Mutex
+	lastMoves map[string]time.Time
+}
+
+// newRebalancer creates a new rebalancer.
+func newRebalancer() *rebalancer {
+	return &rebalancer{
+		lastMoves: make(map[string]time.Time),
+	}
+}
+
+// inCooldown checks if the fetcher has been moved within the cooldown.
+func (r *rebalancer) inCooldown(key string, cooldown time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last, ok := r.lastMoves[key]
+	return ok && time.Since(last) < cooldown
+}
+
+// record records that the fetcher is moved now.
+func (r *rebalancer) record(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastMoves[key] = time.Now()
+}
+
+// forget removes the records older than the cooldown.
+func (r *rebalancer) forget(cooldown time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, last := range r.lastMoves {
+		if time.Since(last) >= cooldown {
+			delete(r.lastMoves, key)
+		}
+	}
+}
+
+// SetRebalance turns the periodic rebalancing of the channel on or off.
+func (c *Coordinator) SetRebalance(channelID string, enabled bool) error {
+	if _, err := c.database.UpdateChannelRebalanceDisabled(channelID, !enabled); err != nil {
+		return fmt.Errorf("error occurs in updating channel info %w", err)
+	}
+	return nil
+}
+
+// rebalance improves the delivery trees of all channels. Each channel is
+// rebalanced in its mailbox, so moves never race with the events of the
+// channel.
+func (c *Coordinator) rebalance() {
+	channels, err := c.database.FindAllChannelInfos()
+	if err != nil {
+		log.Printf("error occurs in finding channel infos %v", err)
+		return
+	}
+	for _, channel := range channels {
+		if channel.RebalanceDisabled || !channel.Policy.PeerToPeer {
+			continue
+		}
+		c.mailboxes.dispatch(channel.ID, func() {
+			if err := c.rebalanceChannel(channel.ID); err != nil {
+				log.Printf("error occurs in rebalancing channel %s %v", channel.ID, err)
+			}
+		})
+	}
+	c.rebalancer.forget(c.config.RebalanceCooldown)
+}
+
+// rebalanceChannel goes through the connections of the channel and moves at
+// most RebalanceMaxMoves fetchers. Fetchers of overloaded or degraded
+// forwarders are moved first. Then fetchers in deep chains are moved closer
+// to the media server, and viewers still on the media server are moved onto
+// peers that now have capacity. Every move is make-before-break: the old
+// connection is released only after the new one is connected.
+func (c *Coordinator) rebalanceChannel(channelID string) error {
+	clients, err := c.database.FindAllClientInfosByChannelID(channelID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding client infos %w", err)
+	}
+
+	// NOTE: The candidates are refreshed first, because the channel could
+	// reach the minimum viewers of its policy since the last rebalancing.
+	for _, client := range clients {
+		if err := c.refreshCandidate(channelID, client.ID); err != nil {
+			return err
+		}
+	}
+
+	moves := 0
+	for _, client := range clients {
+		forwards, err := c.database.FindAllPeerConnectionInfoByFrom(channelID, client.ID)
+		if err != nil {
+			return fmt.Errorf("error occurs in finding connection info by from %w", err)
+		}
+		forwards = slices.DeleteFunc(forwards, func(conn *database.ConnectionInfo) bool {
+			return !conn.IsConnected()
+		})
+
+		reason := reasonDegraded
+		if client.UploadQuality >= c.config.DegradedQuality {
+			if len(forwards) <= client.Capacity {
+				continue
+			}
+			// NOTE: The newest fetchers are moved, because the older ones
+			// are likely to be more stable on the forwarder.
+			slices.SortFunc(forwards, func(a, b *database.ConnectionInfo) int {
+				return b.ConnectedAt.Compare(a.ConnectedAt)
+			})
+			forwards = forwards[:len(forwards)-client.Capacity]
+			reason = reasonOverloaded
+		}
+
+		for _, forward := range forwards {
+			if moves >= c.config.RebalanceMaxMoves {
+				return nil
+			}
+			if c.move(channelID, forward.To, reason, nil, client.ID) {
+				moves++
+			}
+		}
+	}
+
+	for _, client := range clients {
+		if moves >= c.config.RebalanceMaxMoves {
+			return nil
+		}
+		switch {
+		case client.Depth > 1:
+			upstreams, err := c.findUpstreamClients(channelID, client.ID)
+			if err != nil {
+				return err
+			}
+			if c.move(channelID, client.ID, reasonDeep, func(forwarder *database.ClientInfo) bool {
+				return forwarder.Depth+1 < client.Depth
+			}, upstreams...) {
+				moves++
+			}
+		case client.Depth == 1:
+			if _, err := c.database.FindDownstreamInfo(channelID, client.ID); err != nil {
+				continue
+			}
+			if c.move(channelID, client.ID, reasonServer, nil) {
+				moves++
+			}
+		}
+	}
+	return nil
+}
+
+// findUpstreamClients returns the forwarders that the client fetches from.
+func (c *Coordinator) findUpstreamClients(channelID, clientID string) ([]string, error) {
+	fetches, err := c.database.FindAllPeerConnectionInfoByTo(channelID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("error occurs in finding connection info by to %w", err)
+	}
+	var upstreams []string
+	for _, fetch := range fetches {
+		upstreams = append(upstreams, fetch.From)
+	}
+	return upstreams, nil
+}
+
+// move moves the fetcher to the best forwarder if accept allows it. Fetchers
+// that were moved within the cooldown or are already moving are skipped. It
+// returns true if the move is started.
+func (c *Coordinator) move(
+	channelID, fetcherID, reason string,
+	accept func(forwarder *database.ClientInfo) bool,
+	excludes ...string,
+) bool {
+	key := channelID + fetcherID
+	if c.rebalancer.inCooldown(key, c.config.RebalanceCooldown) {
+		return false
+	}
+	fetches, err := c.database.FindAllPeerConnectionInfoByTo(channelID, fetcherID)
+	if err != nil {
+		log.Printf("error occurs in finding connection info by to %v", err)
+		return false
+	}
+	for _, fetch := range fetches {
+		if !fetch.IsConnected() {
+			return false
+		}
+	}
+
+	forwarder, err := c.findForwarder(channelID, fetcherID, excludes...)
+	if err != nil {
+		if !errors.Is(err, ErrNoForwarder) {
+			log.Printf("error occurs in finding forwarder %v", err)
+		}
+		return false
+	}
+	if accept != nil && !accept(forwarder) {
+		return false
+	}
+	if err := c.assign(channelID, forwarder.ID, fetcherID); err != nil {
+		log.Printf("error occurs in assigning forwarder %v", err)
+		return false
+	}
+
+	c.rebalancer.record(key)
+	c.metric.IncrementRebalanceMoves(reason)
+	log.Printf("rebalancing moves %s in %s to %s: %s", fetcherID, channelID, forwarder.ID, reason)
+	return true
+}
@@ -0,0 +1,143 @@
+package coordinator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"pdn/broker"
+	"pdn/database"
+	"pdn/types/client/response"
+	"sync"
+	"time"
+)
+
+// recoveries keeps the time when fetchers lost their forwarder, until they
+// receive the stream again.
+type recoveries struct {
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+// newRecoveries creates a new recoveries.
+func newRecoveries() *recoveries {
+	return &recoveries{
+		started: make(map[string]time.Time),
+	}
+}
+
+// start records that the fetcher lost its forwarder now. The first loss is
+// kept if the fetcher loses another forwarder while recovering.
+func (r *recoveries) start(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.started[key]; !ok {
+		r.started[key] = time.Now()
+	}
+}
+
+// finish removes the record of the fetcher and returns the time it took to
+// recover. It returns false if the fetcher was not recovering.
+func (r *recoveries) finish(key string) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	started, ok := r.started[key]
+	if !ok {
+		return 0, false
+	}
+	delete(r.started, key)
+	return time.Since(started), true
+}
+
+// recoverFetcher sets up a new upstream for the fetcher that lost its forwarder. The
+// fetcher is offered another forwarder, or told to pull from the Media server
+// if there is none. The leaving forwarder is never selected. Until then, the
+// fetcher and its subtree are not offered to other fetchers. Nothing is done
+// if the fetcher still receives the stream through another connection.
+func (c *Coordinator) recoverFetcher(lost *database.ConnectionInfo) error {
+	receiving, err := c.isReceiving(lost.ChannelID, lost.To, lost.ID)
+	if err != nil {
+		return err
+	}
+	if receiving {
+		return nil
+	}
+	c.recoveries.start(lost.ChannelID + lost.To)
+	if err := c.detach(lost.ChannelID, lost.To); err != nil {
+		return err
+	}
+
+	channel, err := c.database.FindChannelInfoByID(lost.ChannelID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding channel info %w", err)
+	}
+	enabled, err := c.peerToPeerEnabled(channel)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		err := c.balance(lost.ChannelID, lost.To, lost.From)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrNoForwarder) {
+			log.Printf("error occurs in balancing %v", err)
+		}
+	}
+
+	if err := c.broker.Publish(broker.ClientSocket, broker.Detail(lost.ChannelID+lost.To), response.Fallback{
+		Type:         response.FALLBACK,
+		ConnectionID: lost.ID,
+	}); err != nil {
+		return fmt.Errorf("error occurs in publishing fallback message %w", err)
+	}
+	return nil
+}
+
+// detach takes the client that lost its upstream and the clients fetching from
+// it out of the pool, since they receive nothing until the client has a new
+// upstream. Their depth is reset, so they become candidates again only when the
+// new upstream is connected and the depths are set again.
+func (c *Coordinator) detach(channelID, clientID string) error {
+	descendants, _, err := c.findDescendants(channelID, clientID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding descendants %w", err)
+	}
+	for _, id := range append(descendants, clientID) {
+		if _, err := c.database.UpdateClientDepth(channelID, id, 0); err != nil {
+			return fmt.Errorf("error occurs in updating client depth %w", err)
+		}
+		c.pool.RemoveClient(id, channelID)
+	}
+	return nil
+}
+
+// isReceiving checks if the client receives the stream through a connected
+// connection other than the given one.
+func (c *Coordinator) isReceiving(channelID, clientID, exceptID string) (bool, error) {
+	serverConn, err := c.database.FindDownstreamInfo(channelID, clientID)
+	if err != nil && !errors.Is(err, database.ErrConnectionNotFound) {
+		return false, fmt.Errorf("error occurs in finding downstream info %w", err)
+	}
+	if serverConn != nil && serverConn.IsConnected() {
+		return true, nil
+	}
+	fetches, err := c.database.FindAllPeerConnectionInfoByTo(channelID, clientID)
+	if err != nil {
+		return false, fmt.Errorf("error occurs in finding connection info by to %w", err)
+	}
+	for _, fetch := range fetches {
+		if fetch.ID != exceptID && fetch.IsConnected() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// finishRecovery records the time to recovery if the client was recovering
+// from losing its forwarder.
+func (c *Coordinator) finishRecovery(channelID, clientID string) {
+	if d, ok := c.recoveries.finish(channelID + clientID); ok {
+		log.Printf("%s in %s recovered in %v", clientID, channelID, d)
+		c.metric.ObserveRecoveryTime(d)
+	}
+}
@@ -0,0 +1,103 @@
+package coordinator
+
+import (
+	"fmt"
+	"log"
+	"pdn/broker"
+	"pdn/database"
+	"pdn/types/client/response"
+	"time"
+)
+
+// evaluateSuperPeers evaluates all clients in all channels and promotes or
+// demotes them. It runs periodically because uptime changes without events.
+// Each channel is evaluated in its mailbox, so it never races with the events
+// of the channel.
+func (c *Coordinator) evaluateSuperPeers() {
+	channels, err := c.database.FindAllChannelInfos()
+	if err != nil {
+		log.Printf("error occurs in finding channel infos %v", err)
+		return
+	}
+	for _, channel := range channels {
+		c.mailboxes.dispatch(channel.ID, func() {
+			c.evaluateChannelSuperPeers(channel)
+		})
+	}
+}
+
+// evaluateChannelSuperPeers evaluates all clients in the channel.
+func (c *Coordinator) evaluateChannelSuperPeers(channel *database.ChannelInfo) {
+	clients, err := c.database.FindAllClientInfosByChannelID(channel.ID)
+	if err != nil {
+		log.Printf("error occurs in finding client infos %v", err)
+		return
+	}
+	for _, client := range clients {
+		if err := c.evaluateSuperPeer(channel, client); err != nil {
+			log.Printf("error occurs in evaluating super-peer %v", err)
+		}
+	}
+}
+
+// qualifiesAsSuperPeer checks if the client is stable and capable enough to be
+// a super-peer. The capacity is calculated as a regular peer, so a super-peer
+// is demoted when it can't serve enough fetchers even with the regular cap.
+// Nobody qualifies while the delivery between clients is off in the channel.
+func (c *Coordinator) qualifiesAsSuperPeer(channel *database.ChannelInfo, client *database.ClientInfo) (bool, error) {
+	if client.Depth == 0 || client.Capabilities.ForwardingDisabled {
+		return false, nil
+	}
+	if time.Since(client.CreatedAt) < c.config.SuperPeerUptime {
+		return false, nil
+	}
+	if client.UploadQuality < c.config.SuperPeerQuality {
+		return false, nil
+	}
+	enabled, err := c.peerToPeerEnabled(channel)
+	if err != nil || !enabled {
+		return false, err
+	}
+	regular := *client
+	regular.SuperPeer = false
+	return c.calculateCapacity(channel, &regular) >= c.config.SuperPeerCapacity, nil
+}
+
+// evaluateSuperPeer promotes or demotes the client and tells the client its new role.
+func (c *Coordinator) evaluateSuperPeer(channel *database.ChannelInfo, client *database.ClientInfo) error {
+	qualified, err := c.qualifiesAsSuperPeer(channel, client)
+	if err != nil {
+		return err
+	}
+	if qualified == client.SuperPeer {
+		return nil
+	}
+
+	if _, err := c.database.UpdateClientSuperPeer(client.ChannelID, client.ID, qualified); err != nil {
+		return fmt.Errorf("error occurs in updating super-peer %w", err)
+	}
+	role := response.RolePeer
+	if qualified {
+		role = response.RoleSuperPeer
+		c.metric.IncrementSuperPeers()
+	} else {
+		c.metric.DecrementSuperPeers()
+	}
+	log.Printf("client %s in %s is now %s", client.ID, client.ChannelID, role)
+
+	if err := c.refreshCandidate(client.ChannelID, client.ID); err != nil {
+		return err
+	}
+	updated, err := c.database.FindClientInfoByID(client.ChannelID, client.ID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding client info %w", err)
+	}
+	if err := c.broker.Publish(broker.ClientSocket, broker.Detail(client.ChannelID+client.ID), response.Role{
+		Type:     response.ROLE,
+		Role:     role,
+		Capacity: updated.Capacity,
+	}); err != nil {
+		return fmt.Errorf("error occurs in publishing role message %w", err)
+	}
+	return nil
+}
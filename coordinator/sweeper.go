@@ -0,0 +1,87 @@
+package coordinator
+
+import (
+	"fmt"
+	"log"
+	"pdn/database"
+	"time"
+)
+
+// deadline returns when the connection misses the deadline of its current
+// negotiation phase. It returns false if the connection is not negotiating.
+func (c *Coordinator) deadline(conn *database.ConnectionInfo) (time.Time, bool) {
+	var timeout time.Duration
+	switch conn.Status {
+	case database.Created:
+		timeout = c.config.OfferTimeout
+	case database.Offered:
+		timeout = c.config.AnswerTimeout
+	case database.Answered, database.Connecting:
+		timeout = c.config.ConnectTimeout
+	default:
+		return time.Time{}, false
+	}
+
+	since, ok := conn.Transitions[conn.Status]
+	if !ok {
+		since = conn.CreatedAt
+	}
+	return since.Add(timeout), true
+}
+
+// sweep fails the peer connections of all channels that missed their
+// deadline. Each channel is swept in its mailbox, so it never races with the
+// events of the channel. The expired failed pairs are pruned as well.
+func (c *Coordinator) sweep() {
+	c.failedPairs.prune()
+	channels, err := c.database.FindAllChannelInfos()
+	if err != nil {
+		log.Printf("error occurs in finding channel infos %v", err)
+		return
+	}
+	for _, channel := range channels {
+		c.mailboxes.dispatch(channel.ID, func() {
+			if err := c.sweepChannel(channel.ID); err != nil {
+				log.Printf("error occurs in sweeping channel %s %v", channel.ID, err)
+			}
+		})
+	}
+}
+
+// sweepChannel fails the peer connections of the channel that missed their
+// deadline, e.g. when a tab froze during the negotiation. The connection is
+// cleared on both clients, which releases the slot of the forwarder, and the
+// fetcher is recovered if it doesn't receive the stream otherwise.
+func (c *Coordinator) sweepChannel(channelID string) error {
+	clients, err := c.database.FindAllClientInfosByChannelID(channelID)
+	if err != nil {
+		return fmt.Errorf("error occurs in finding client infos %w", err)
+	}
+
+	now := time.Now()
+	for _, client := range clients {
+		forwards, err := c.database.FindAllPeerConnectionInfoByFrom(channelID, client.ID)
+		if err != nil {
+			return fmt.Errorf("error occurs in finding connection info by from %w", err)
+		}
+		for _, forward := range forwards {
+			deadline, ok := c.deadline(forward)
+			if !ok || now.Before(deadline) {
+				continue
+			}
+
+			log.Printf("connection %s from %s to %s timed out in %s", forward.ID, forward.From, forward.To, forward.Status)
+			if _, err := c.database.UpdateConnectionInfo(forward.ID, database.Failed); err != nil {
+				log.Printf("error occurs in updating connection info %v", err)
+				continue
+			}
+			c.metric.IncrementNegotiationTimeouts(forward.Status.String())
+			c.recordPeerResult(forward, false)
+			c.clearPeerConnection(forward)
+			if err := c.recoverFetcher(forward); err != nil {
+				log.Printf("error occurs in recovering fetcher %v", err)
+			}
+		}
+	}
+	return nil
+}
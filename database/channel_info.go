@@ -1,23 +1,178 @@
 package database
 
-import "time"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"slices"
+	"time"
+)
+
+// DefaultMaxDepth is the default maximum delivery depth of a channel.
+const DefaultMaxDepth = 3
+
+// Policies of a channel for a client activating with the ID of another active
+// client.
+const (
+	// DuplicateReject rejects the newcomer.
+	DuplicateReject = "reject"
+
+	// DuplicateKick closes the session of the old client and lets the newcomer in.
+	DuplicateKick = "kick"
+
+	// DuplicateUnique assigns a unique ID to the newcomer.
+	DuplicateUnique = "unique"
+)
+
+// Policy is the peer-assisted delivery policy of a channel. New channels get
+// the default policy of the server, and it can be changed at runtime.
+type Policy struct {
+	// PeerToPeer turns the delivery between clients on or off. If it is off,
+	// every viewer fetches from the media server.
+	PeerToPeer bool
+
+	// MaxDepth is the maximum delivery depth of a client. Clients fetching
+	// from the media server have depth 1, so MaxDepth 1 means that no client
+	// fetches from other clients.
+	MaxDepth int
+
+	// MaxFanOut limits the number of fetchers of a client in the channel
+	// under the limit of the server. Zero means no limit of the channel.
+	MaxFanOut int
+
+	// MinViewers is the number of viewers in the channel before the delivery
+	// between clients starts. Small channels are served by the media server.
+	MinViewers int
+
+	// DuplicateClient is how a client activating with the ID of another
+	// active client is treated. Empty means DuplicateReject.
+	DuplicateClient string
+}
+
+// Validate validates the policy.
+func (p Policy) Validate() error {
+	if p.MaxDepth < 1 {
+		return fmt.Errorf("max depth must be positive, given %d", p.MaxDepth)
+	}
+	if p.MaxFanOut < 0 {
+		return fmt.Errorf("max fan-out must not be negative, given %d", p.MaxFanOut)
+	}
+	if p.MinViewers < 0 {
+		return fmt.Errorf("min viewers must not be negative, given %d", p.MinViewers)
+	}
+	switch p.DuplicateClient {
+	case "", DuplicateReject, DuplicateKick, DuplicateUnique:
+	default:
+		return fmt.Errorf("duplicate client must be %s, %s or %s, given %q",
+			DuplicateReject, DuplicateKick, DuplicateUnique, p.DuplicateClient)
+	}
+	return nil
+}
+
+// KeyParams are the argon2id parameters that a key is hashed with. They are
+// stored with the hash, so the keys hashed before the parameters change still
+// verify.
+type KeyParams struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+	Length  uint32
+}
+
+// DefaultKeyParams are the parameters that new keys are hashed with.
+var DefaultKeyParams = KeyParams{Time: 2, Memory: 19 * 1024, Threads: 1, Length: 32}
+
+// ChannelKey is a salted hash of a key of a channel. A key expires at
+// ExpiresAt after it is rotated, and zero means that it never expires.
+type ChannelKey struct {
+	Salt      []byte
+	Hash      []byte
+	Params    KeyParams
+	ExpiresAt time.Time
+}
+
+// NewChannelKey hashes the key with a random salt.
+func NewChannelKey(key string) (ChannelKey, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return ChannelKey{}, fmt.Errorf("failed to read random salt: %w", err)
+	}
+	return ChannelKey{Salt: salt, Hash: hashKey(salt, key, DefaultKeyParams), Params: DefaultKeyParams}, nil
+}
+
+// hashKey hashes the salted key by argon2id with the parameters. Nothing is
+// returned for the invalid parameters.
+func hashKey(salt []byte, key string, params KeyParams) []byte {
+	if params.Time == 0 || params.Threads == 0 {
+		return nil
+	}
+	return argon2.IDKey([]byte(key), salt, params.Time, params.Memory, params.Threads, params.Length)
+}
+
+// Valid checks if the key is not expired at the given time.
+func (k ChannelKey) Valid(now time.Time) bool {
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
 
 // ChannelInfo is a struct for channel information.
 type ChannelInfo struct {
-	ID        string
-	Key       string
+	ID   string
+	Keys []ChannelKey
+
+	// Provisioned tells that the channel is created ahead of time by the
+	// operator. It is kept after the broadcast ends, while a channel created
+	// on demand is deleted.
+	Provisioned bool
+
+	Policy Policy
+
+	// RebalanceDisabled turns off the periodic rebalancing of the channel.
+	RebalanceDisabled bool
+
 	CreatedAt time.Time
 }
 
-// Authenticate checks if the given channel ID and key are authenticated.
+// Authenticate checks if the given key is one of the valid keys of the
+// channel. Every key is compared in constant time.
 func (c *ChannelInfo) Authenticate(key string) bool {
-	return c.Key == key
+	now := time.Now()
+	matched := 0
+	for _, k := range c.Keys {
+		hash := hashKey(k.Salt, key, k.Params)
+		if len(hash) > 0 && subtle.ConstantTimeCompare(hash, k.Hash) == 1 && k.Valid(now) {
+			matched = 1
+		}
+	}
+	return matched == 1
+}
+
+// RotateKey returns the keys of the channel with the new key added. The old
+// keys still valid expire after the overlap, so the clients can move to the
+// new key in the meantime. The expired keys are removed.
+func (c *ChannelInfo) RotateKey(key ChannelKey, overlap time.Duration, now time.Time) []ChannelKey {
+	expiresAt := now.Add(overlap)
+	keys := make([]ChannelKey, 0, len(c.Keys)+1)
+	for _, k := range c.Keys {
+		if !k.Valid(now) || overlap <= 0 {
+			continue
+		}
+		if k.ExpiresAt.IsZero() || k.ExpiresAt.After(expiresAt) {
+			k.ExpiresAt = expiresAt
+		}
+		keys = append(keys, k)
+	}
+	return append(keys, key)
 }
 
 // DeepCopy creates a deep copy of the given ChannelInfo.
 func (c *ChannelInfo) DeepCopy() *ChannelInfo {
 	return &ChannelInfo{
-		ID:  c.ID,
-		Key: c.Key,
+		ID:                c.ID,
+		Keys:              slices.Clone(c.Keys),
+		Provisioned:       c.Provisioned,
+		Policy:            c.Policy,
+		RebalanceDisabled: c.RebalanceDisabled,
+		CreatedAt:         c.CreatedAt,
 	}
 }
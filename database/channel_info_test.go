@@ -0,0 +1,67 @@
+package database_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pdn/database"
+	"testing"
+	"time"
+)
+
+// TestRotateKey tests that the old key is accepted only for the overlap after rotation.
+func TestRotateKey(t *testing.T) {
+	oldKey, err := database.NewChannelKey("old")
+	require.NoError(t, err)
+	newKey, err := database.NewChannelKey("new")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		overlap time.Duration
+		wantOld bool
+	}{
+		{
+			name:    "given overlap when rotate key then accept both keys",
+			overlap: time.Hour,
+			wantOld: true,
+		},
+		{
+			name:    "given no overlap when rotate key then accept new key only",
+			overlap: 0,
+			wantOld: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel := &database.ChannelInfo{ID: "channel", Keys: []database.ChannelKey{oldKey}}
+			require.True(t, channel.Authenticate("old"))
+
+			channel.Keys = channel.RotateKey(newKey, tt.overlap, time.Now())
+			assert.True(t, channel.Authenticate("new"))
+			assert.Equal(t, tt.wantOld, channel.Authenticate("old"))
+			assert.False(t, channel.Authenticate("other"))
+		})
+	}
+}
+
+// TestAuthenticateKeyParams tests that a key is verified with the parameters it
+// was hashed with, after the default parameters change.
+func TestAuthenticateKeyParams(t *testing.T) {
+	defaults := database.DefaultKeyParams
+	t.Cleanup(func() {
+		database.DefaultKeyParams = defaults
+	})
+
+	database.DefaultKeyParams = database.KeyParams{Time: 1, Memory: 8 * 1024, Threads: 1, Length: 16}
+	oldKey, err := database.NewChannelKey("old")
+	require.NoError(t, err)
+	database.DefaultKeyParams = defaults
+	newKey, err := database.NewChannelKey("new")
+	require.NoError(t, err)
+
+	channel := &database.ChannelInfo{ID: "channel", Keys: []database.ChannelKey{oldKey, newKey, {}}}
+	assert.True(t, channel.Authenticate("old"))
+	assert.True(t, channel.Authenticate("new"))
+	assert.False(t, channel.Authenticate("other"))
+}
@@ -2,18 +2,98 @@ package database
 
 import "time"
 
+// Device classes that clients report.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceTV      = "tv"
+)
+
+// NAT types that clients detect from their own ICE gathering.
+const (
+	NATOpen               = "open"
+	NATFullCone           = "full-cone"
+	NATRestrictedCone     = "restricted-cone"
+	NATPortRestrictedCone = "port-restricted-cone"
+	NATSymmetric          = "symmetric"
+)
+
+// Capabilities is the delivery capabilities reported by a client. Zero values
+// mean that the capability is unknown.
+type Capabilities struct {
+	// UploadBandwidth is the estimated upload bandwidth of the client in kbps.
+	UploadBandwidth int
+
+	// RTT is the round trip time between the client and the media server.
+	RTT time.Duration
+
+	// DeviceClass is the class of the device, e.g. DeviceDesktop.
+	DeviceClass string
+
+	// NATType is the NAT type of the client, e.g. NATSymmetric.
+	NATType string
+
+	// ForwardingDisabled is true if the client is not willing to forward
+	// the stream to other clients.
+	ForwardingDisabled bool
+}
+
 // ClientInfo is a struct for client information.
 type ClientInfo struct {
 	ID        string
 	ChannelID string
+
+	// Depth is the number of hops between the media server and the client.
+	// Clients fetching from the media server have depth 1, and clients
+	// fetching from a peer have the depth of the peer plus 1. Zero means
+	// that the client doesn't receive the stream yet.
+	Depth int
+
+	Capabilities Capabilities
+
+	// UploadQuality is the measured upload quality of the client between 0
+	// and 1. It lowers the forwarding capacity of a client whose upload is
+	// worse than it reported.
+	UploadQuality float64
+
+	// Capacity is the number of fetchers that the client can forward to.
+	Capacity int
+
+	// SuperPeer is true if the client is promoted to a relay hub. Super-peers
+	// have a higher forwarding limit and are preferred as forwarders.
+	SuperPeer bool
+
+	// PeerSuccesses and PeerFailures are the numbers of peer connections of
+	// the client that were connected or failed, as either side.
+	PeerSuccesses int
+	PeerFailures  int
+
 	CreatedAt time.Time
 }
 
+// PeerSuccessRate returns the rate of the peer connections of the client that
+// were connected. A client without any peer connection is assumed to succeed.
+func (u *ClientInfo) PeerSuccessRate() float64 {
+	attempts := u.PeerSuccesses + u.PeerFailures
+	if attempts == 0 {
+		return 1
+	}
+	return float64(u.PeerSuccesses) / float64(attempts)
+}
+
 // DeepCopy creates a deep copy of the given ClientInfo.
 func (u *ClientInfo) DeepCopy() *ClientInfo {
 	return &ClientInfo{
-		ID:        u.ID,
-		ChannelID: u.ChannelID,
-		CreatedAt: u.CreatedAt,
+		ID:            u.ID,
+		ChannelID:     u.ChannelID,
+		Depth:         u.Depth,
+		Capabilities:  u.Capabilities,
+		UploadQuality: u.UploadQuality,
+		Capacity:      u.Capacity,
+		SuperPeer:     u.SuperPeer,
+		PeerSuccesses: u.PeerSuccesses,
+		PeerFailures:  u.PeerFailures,
+		CreatedAt:     u.CreatedAt,
 	}
 }
@@ -3,4 +3,11 @@ package database
 // Config contains the configuration for the database.
 type Config struct {
 	SetDefaultChannel bool
+
+	// AutoCreateChannels creates any channel a client asks for, with the
+	// channel ID as its key. It is only for debug or test.
+	AutoCreateChannels bool
+
+	// DefaultPolicy is the policy of new channels.
+	DefaultPolicy Policy
 }
@@ -1,15 +1,94 @@
 package database
 
 import (
+	"fmt"
 	"time"
 )
 
-// Status is the status of the connection.
+// Status is the status of the connection in its lifecycle.
+type Status int
+
+// Statuses of the connection. A connection is created by the coordinator,
+// negotiated by the clients or the media server, and closed when it is
+// removed from the topology.
 const (
-	Initialized = iota
+	Created Status = iota
+	Offered
+	Answered
+	Connecting
 	Connected
+	Disconnected
+	Failed
+	Closed
 )
 
+// transitions is the statuses that each status can move to. The negotiation
+// statuses can be skipped, because the server doesn't see every step of the
+// negotiation, e.g. candidates exchanged before the answer. A disconnected
+// connection can be connected again by ICE restart.
+var transitions = map[Status][]Status{
+	Created:      {Offered, Answered, Connecting, Connected, Failed, Closed},
+	Offered:      {Answered, Connecting, Connected, Failed, Closed},
+	Answered:     {Connecting, Connected, Failed, Closed},
+	Connecting:   {Connected, Failed, Closed},
+	Connected:    {Disconnected, Failed, Closed},
+	Disconnected: {Connecting, Connected, Failed, Closed},
+	Failed:       {Closed},
+	Closed:       {},
+}
+
+// String returns the string representation of the Status.
+func (s Status) String() string {
+	switch s {
+	case Created:
+		return "created"
+	case Offered:
+		return "offered"
+	case Answered:
+		return "answered"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Failed:
+		return "failed"
+	case Closed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// CanTransition checks if the status can move to the given status.
+func (s Status) CanTransition(to Status) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError is returned when a connection can't move from its status to
+// the requested one, e.g. a duplicate or stale event.
+type TransitionError struct {
+	ConnectionID string
+	From         Status
+	To           Status
+}
+
+// Error returns the message of the error.
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: %s to %s: %v", e.ConnectionID, e.From, e.To, ErrInvalidTransition)
+}
+
+// Is reports whether the error is ErrInvalidTransition.
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
 // Type is the type of the connection that never changes.
 const (
 	PushToServer = iota
@@ -17,6 +96,19 @@ const (
 	PeerToPeer
 )
 
+// Health is the stats of a connection reported by a client.
+type Health struct {
+	RTT        time.Duration
+	Jitter     time.Duration
+	PacketLoss float64
+	Bitrate    int // kbps
+	ReportedAt time.Time
+
+	// BytesReceived is the total bytes the client received through the
+	// connection since it was connected.
+	BytesReceived int64
+}
+
 // ConnectionInfo is a struct for WebRTC connection information.
 type ConnectionInfo struct {
 	ID          string
@@ -24,9 +116,13 @@ type ConnectionInfo struct {
 	To          string
 	From        string
 	Type        int
-	Status      int
+	Status      Status
+	Health      Health
 	CreatedAt   time.Time
 	ConnectedAt time.Time
+
+	// Transitions is the time when the connection moved to each status.
+	Transitions map[Status]time.Time
 }
 
 // Authorize checks if the given channel ID and client ID are authorized.
@@ -62,8 +158,30 @@ func (c *ConnectionInfo) IsConnected() bool {
 	return c.Status == Connected
 }
 
+// Transition moves the connection to the given status and records the time.
+// It returns a TransitionError if the move is not allowed.
+func (c *ConnectionInfo) Transition(to Status) error {
+	if !c.Status.CanTransition(to) {
+		return &TransitionError{ConnectionID: c.ID, From: c.Status, To: to}
+	}
+	now := time.Now()
+	c.Status = to
+	if c.Transitions == nil {
+		c.Transitions = make(map[Status]time.Time)
+	}
+	c.Transitions[to] = now
+	if to == Connected {
+		c.ConnectedAt = now
+	}
+	return nil
+}
+
 // DeepCopy creates a deep copy of the given ConnectionInfo.
 func (c *ConnectionInfo) DeepCopy() *ConnectionInfo {
+	transitions := make(map[Status]time.Time, len(c.Transitions))
+	for status, at := range c.Transitions {
+		transitions[status] = at
+	}
 	return &ConnectionInfo{
 		ID:          c.ID,
 		ChannelID:   c.ChannelID,
@@ -71,7 +189,9 @@ func (c *ConnectionInfo) DeepCopy() *ConnectionInfo {
 		From:        c.From,
 		Status:      c.Status,
 		Type:        c.Type,
+		Health:      c.Health,
 		CreatedAt:   c.CreatedAt,
 		ConnectedAt: c.ConnectedAt,
+		Transitions: transitions,
 	}
 }
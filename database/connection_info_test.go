@@ -0,0 +1,76 @@
+package database_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"pdn/database"
+	"testing"
+)
+
+// TestTransition tests that the connection moves only along its lifecycle.
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    []database.Status
+		to      database.Status
+		wantErr bool
+	}{
+		{
+			name: "given offered connection when answered then succeed",
+			path: []database.Status{database.Offered},
+			to:   database.Answered,
+		},
+		{
+			name: "given created connection when connected then succeed",
+			to:   database.Connected,
+		},
+		{
+			name: "given disconnected connection when connected again then succeed",
+			path: []database.Status{database.Connected, database.Disconnected},
+			to:   database.Connected,
+		},
+		{
+			name:    "given connected connection when connected again then return error",
+			path:    []database.Status{database.Connected},
+			to:      database.Connected,
+			wantErr: true,
+		},
+		{
+			name:    "given answered connection when offered then return error",
+			path:    []database.Status{database.Offered, database.Answered},
+			to:      database.Offered,
+			wantErr: true,
+		},
+		{
+			name:    "given failed connection when connected then return error",
+			path:    []database.Status{database.Failed},
+			to:      database.Connected,
+			wantErr: true,
+		},
+		{
+			name:    "given closed connection when closed again then return error",
+			path:    []database.Status{database.Closed},
+			to:      database.Closed,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &database.ConnectionInfo{ID: "conn"}
+			for _, status := range tt.path {
+				assert.NoError(t, conn.Transition(status))
+			}
+
+			err := conn.Transition(tt.to)
+			if tt.wantErr {
+				var transitionErr *database.TransitionError
+				assert.ErrorAs(t, err, &transitionErr)
+				assert.ErrorIs(t, err, database.ErrInvalidTransition)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.to, conn.Status)
+			assert.Contains(t, conn.Transitions, tt.to)
+		})
+	}
+}
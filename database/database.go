@@ -37,17 +37,32 @@ var (
 
 	// ErrConnectionNotFound is returned when the connection is not found.
 	ErrConnectionNotFound = errors.New("connection not found")
+
+	// ErrInvalidTransition is returned when the connection can't move to the given status.
+	ErrInvalidTransition = errors.New("invalid connection status transition")
 )
 
 // Database is an interface for database operations.
 type Database interface {
 	EnsureDefaultChannelInfo(channelID, channelKey string) error
+	CreateChannelInfo(id string, key ChannelKey) (*ChannelInfo, error)
 	FindOrCreateChannelInfoByID(id string) (*ChannelInfo, error)
+	FindChannelInfoByID(id string) (*ChannelInfo, error)
 	FindAllChannelInfos() ([]*ChannelInfo, error)
+	UpdateChannelPolicy(id string, policy Policy) (*ChannelInfo, error)
+	UpdateChannelRebalanceDisabled(id string, disabled bool) (*ChannelInfo, error)
+	UpdateChannelKeys(id string, keys []ChannelKey) (*ChannelInfo, error)
 	DeleteChannelInfoByID(id string) error
 	CreateClientInfo(channelID, clientID string) error
 	DeleteClientInfoByID(channelID, clientID string) error
 	FindClientInfoByID(channelID, clientID string) (*ClientInfo, error)
+	UpdateClientDepth(channelID, clientID string, depth int) (*ClientInfo, error)
+	UpdateClientCapabilities(channelID, clientID string, capabilities Capabilities) (*ClientInfo, error)
+	UpdateClientUploadQuality(channelID, clientID string, quality float64) (*ClientInfo, error)
+	UpdateClientCapacity(channelID, clientID string, capacity int) (*ClientInfo, error)
+	UpdateClientSuperPeer(channelID, clientID string, superPeer bool) (*ClientInfo, error)
+	UpdateClientPeerResult(channelID, clientID string, succeeded bool) (*ClientInfo, error)
+	FindAllClientInfosByChannelID(channelID string) ([]*ClientInfo, error)
 	CreatePushConnectionInfo(channelID, clientID, connectionID string) (*ConnectionInfo, error)
 	CreatePullConnectionInfo(channelID, clientID, connectionID string) (*ConnectionInfo, error)
 	CreatePeerConnectionInfo(channelID, from, to, connectionID string) (*ConnectionInfo, error)
@@ -56,6 +71,8 @@ type Database interface {
 	FindAllPeerConnectionInfoByFrom(channelID, from string) ([]*ConnectionInfo, error)
 	FindAllPeerConnectionInfoByTo(channelID, from string) ([]*ConnectionInfo, error)
 	FindConnectionInfoByID(ConnectionID string) (*ConnectionInfo, error)
-	UpdateConnectionInfo(connectionID string, status int) (*ConnectionInfo, error)
+	FindAllConnectionInfosByChannelID(channelID string) ([]*ConnectionInfo, error)
+	UpdateConnectionInfo(connectionID string, status Status) (*ConnectionInfo, error)
+	UpdateConnectionHealth(connectionID string, health Health) (*ConnectionInfo, error)
 	DeleteConnectionInfoByID(connectionID string) error
 }
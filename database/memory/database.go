@@ -6,12 +6,15 @@ import (
 	"github.com/hashicorp/go-memdb"
 	"log"
 	"pdn/database"
+	"slices"
 	"time"
 )
 
 // DB is a memory-backed database.
 type DB struct {
-	db *memdb.MemDB
+	db            *memdb.MemDB
+	defaultPolicy database.Policy
+	autoCreate    bool
 }
 
 // New creates a new memory-backed database.
@@ -21,7 +24,9 @@ func New(config database.Config) *DB {
 		panic(err)
 	}
 	newDB := &DB{
-		db: db,
+		db:            db,
+		defaultPolicy: config.DefaultPolicy,
+		autoCreate:    config.AutoCreateChannels,
 	}
 	if config.SetDefaultChannel {
 		if err := newDB.EnsureDefaultChannelInfo(database.DefaultChannelID, database.DefaultChannelKey); err != nil {
@@ -35,27 +40,44 @@ func New(config database.Config) *DB {
 // EnsureDefaultChannelInfo creates a new channel if it doesn't exist. This is
 // used for testing and debugging purposes.
 func (d *DB) EnsureDefaultChannelInfo(channelID, channelKey string) error {
+	key, err := database.NewChannelKey(channelKey)
+	if err != nil {
+		return err
+	}
+	if _, err := d.CreateChannelInfo(channelID, key); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateChannelInfo provisions a new channel with the key.
+func (d *DB) CreateChannelInfo(id string, key database.ChannelKey) (*database.ChannelInfo, error) {
 	txn := d.db.Txn(true)
 	defer txn.Abort()
-	existing, err := txn.First(tblChannels, idxChannelID, channelID)
+	existing, err := txn.First(tblChannels, idxChannelID, id)
 	if err != nil {
-		return fmt.Errorf("find channel by channelID: %w", err)
+		return nil, fmt.Errorf("find channel by channelID: %w", err)
 	}
 	if existing != nil {
-		return fmt.Errorf("%s: %w", channelID, database.ErrChannelAlreadyExists)
+		return nil, fmt.Errorf("%s: %w", id, database.ErrChannelAlreadyExists)
 	}
 	info := &database.ChannelInfo{
-		ID:  channelID,
-		Key: channelKey,
+		ID:          id,
+		Keys:        []database.ChannelKey{key},
+		Provisioned: true,
+		Policy:      d.defaultPolicy,
+		CreatedAt:   time.Now(),
 	}
 	if err := txn.Insert(tblChannels, info); err != nil {
-		return fmt.Errorf("insert channel: %w", err)
+		return nil, fmt.Errorf("insert channel: %w", err)
 	}
 	txn.Commit()
-	return nil
+	return info.DeepCopy(), nil
 }
 
-// FindOrCreateChannelInfoByID finds a channel by its ID.
+// FindOrCreateChannelInfoByID finds a channel by its ID. The channel is
+// created with the channel ID as its key only if channels are created on
+// demand.
 func (d *DB) FindOrCreateChannelInfoByID(id string) (*database.ChannelInfo, error) {
 	txn := d.db.Txn(true)
 	defer txn.Abort()
@@ -64,10 +86,19 @@ func (d *DB) FindOrCreateChannelInfoByID(id string) (*database.ChannelInfo, erro
 		return nil, fmt.Errorf("find project by public key: %w", err)
 	}
 	if raw == nil {
+		if !d.autoCreate {
+			return nil, fmt.Errorf("%s: %w", id, database.ErrChannelNotFound)
+		}
+		key, err := database.NewChannelKey(id)
+		if err != nil {
+			return nil, err
+		}
 		// Channel not found, create a new one
 		info := &database.ChannelInfo{
-			ID:  id,
-			Key: id,
+			ID:        id,
+			Keys:      []database.ChannelKey{key},
+			Policy:    d.defaultPolicy,
+			CreatedAt: time.Now(),
 		}
 		if err := txn.Insert(tblChannels, info); err != nil {
 			return nil, fmt.Errorf("insert channel: %w", err)
@@ -79,6 +110,20 @@ func (d *DB) FindOrCreateChannelInfoByID(id string) (*database.ChannelInfo, erro
 	return raw.(*database.ChannelInfo).DeepCopy(), nil
 }
 
+// FindChannelInfoByID finds a channel by its ID without creating it.
+func (d *DB) FindChannelInfoByID(id string) (*database.ChannelInfo, error) {
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+	raw, err := txn.First(tblChannels, idxChannelID, id)
+	if err != nil {
+		return nil, fmt.Errorf("find channel by channelID: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", id, database.ErrChannelNotFound)
+	}
+	return raw.(*database.ChannelInfo).DeepCopy(), nil
+}
+
 // FindAllChannelInfos retrieves all channel information from the database.
 func (d *DB) FindAllChannelInfos() ([]*database.ChannelInfo, error) {
 	txn := d.db.Txn(false) // Read-only transaction
@@ -103,6 +148,66 @@ func (d *DB) FindAllChannelInfos() ([]*database.ChannelInfo, error) {
 	return channelInfos, nil
 }
 
+// UpdateChannelPolicy updates the delivery policy of the channel.
+func (d *DB) UpdateChannelPolicy(id string, policy database.Policy) (*database.ChannelInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblChannels, idxChannelID, id)
+	if err != nil {
+		return nil, fmt.Errorf("find channel by channelID: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", id, database.ErrChannelNotFound)
+	}
+	info := raw.(*database.ChannelInfo).DeepCopy()
+	info.Policy = policy
+	if err := txn.Insert(tblChannels, info); err != nil {
+		return nil, fmt.Errorf("insert channel: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
+// UpdateChannelRebalanceDisabled turns the periodic rebalancing of the channel on or off.
+func (d *DB) UpdateChannelRebalanceDisabled(id string, disabled bool) (*database.ChannelInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblChannels, idxChannelID, id)
+	if err != nil {
+		return nil, fmt.Errorf("find channel by channelID: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", id, database.ErrChannelNotFound)
+	}
+	info := raw.(*database.ChannelInfo).DeepCopy()
+	info.RebalanceDisabled = disabled
+	if err := txn.Insert(tblChannels, info); err != nil {
+		return nil, fmt.Errorf("insert channel: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
+// UpdateChannelKeys replaces the keys of the channel.
+func (d *DB) UpdateChannelKeys(id string, keys []database.ChannelKey) (*database.ChannelInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblChannels, idxChannelID, id)
+	if err != nil {
+		return nil, fmt.Errorf("find channel by channelID: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", id, database.ErrChannelNotFound)
+	}
+	info := raw.(*database.ChannelInfo).DeepCopy()
+	info.Keys = slices.Clone(keys)
+	if err := txn.Insert(tblChannels, info); err != nil {
+		return nil, fmt.Errorf("insert channel: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
 // DeleteChannelInfoByID deletes a channel by its ID.
 func (d *DB) DeleteChannelInfoByID(id string) error {
 	txn := d.db.Txn(true)
@@ -134,9 +239,10 @@ func (d *DB) CreateClientInfo(channelID, clientID string) error {
 	}
 
 	info := &database.ClientInfo{
-		ChannelID: channelID,
-		ID:        clientID,
-		CreatedAt: time.Now(),
+		ChannelID:     channelID,
+		ID:            clientID,
+		UploadQuality: 1,
+		CreatedAt:     time.Now(),
 	}
 	if err := txn.Insert(tblClients, info); err != nil {
 		return fmt.Errorf("insert user: %w", err)
@@ -159,6 +265,148 @@ func (d *DB) FindClientInfoByID(channelID, clientID string) (*database.ClientInf
 	return raw.(*database.ClientInfo).DeepCopy(), nil
 }
 
+// UpdateClientDepth updates the delivery depth of the client.
+func (d *DB) UpdateClientDepth(channelID, clientID string, depth int) (*database.ClientInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblClients, idxClientID, channelID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("find user by username: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", clientID, database.ErrClientNotFound)
+	}
+	info := raw.(*database.ClientInfo).DeepCopy()
+	info.Depth = depth
+	if err := txn.Insert(tblClients, info); err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
+// UpdateClientCapabilities updates the capabilities reported by the client.
+func (d *DB) UpdateClientCapabilities(
+	channelID, clientID string,
+	capabilities database.Capabilities,
+) (*database.ClientInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblClients, idxClientID, channelID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("find user by username: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", clientID, database.ErrClientNotFound)
+	}
+	info := raw.(*database.ClientInfo).DeepCopy()
+	info.Capabilities = capabilities
+	if err := txn.Insert(tblClients, info); err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
+// UpdateClientUploadQuality updates the measured upload quality of the client.
+func (d *DB) UpdateClientUploadQuality(channelID, clientID string, quality float64) (*database.ClientInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblClients, idxClientID, channelID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("find user by username: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", clientID, database.ErrClientNotFound)
+	}
+	info := raw.(*database.ClientInfo).DeepCopy()
+	info.UploadQuality = quality
+	if err := txn.Insert(tblClients, info); err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
+// UpdateClientCapacity updates the forwarding capacity of the client.
+func (d *DB) UpdateClientCapacity(channelID, clientID string, capacity int) (*database.ClientInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblClients, idxClientID, channelID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("find user by username: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", clientID, database.ErrClientNotFound)
+	}
+	info := raw.(*database.ClientInfo).DeepCopy()
+	info.Capacity = capacity
+	if err := txn.Insert(tblClients, info); err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
+// UpdateClientSuperPeer promotes the client to a super-peer or demotes it.
+func (d *DB) UpdateClientSuperPeer(channelID, clientID string, superPeer bool) (*database.ClientInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblClients, idxClientID, channelID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("find user by username: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", clientID, database.ErrClientNotFound)
+	}
+	info := raw.(*database.ClientInfo).DeepCopy()
+	info.SuperPeer = superPeer
+	if err := txn.Insert(tblClients, info); err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
+// UpdateClientPeerResult counts a connected or failed peer connection of the client.
+func (d *DB) UpdateClientPeerResult(channelID, clientID string, succeeded bool) (*database.ClientInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblClients, idxClientID, channelID, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("find user by username: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", clientID, database.ErrClientNotFound)
+	}
+	info := raw.(*database.ClientInfo).DeepCopy()
+	if succeeded {
+		info.PeerSuccesses++
+	} else {
+		info.PeerFailures++
+	}
+	if err := txn.Insert(tblClients, info); err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+	txn.Commit()
+	return info.DeepCopy(), nil
+}
+
+// FindAllClientInfosByChannelID finds all clients in the channel.
+func (d *DB) FindAllClientInfosByChannelID(channelID string) ([]*database.ClientInfo, error) {
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+	iter, err := txn.Get(tblClients, idxClientChannelID, channelID)
+	if err != nil {
+		return nil, fmt.Errorf("find users by channelID: %w", err)
+	}
+	var clients []*database.ClientInfo
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		clients = append(clients, raw.(*database.ClientInfo).DeepCopy())
+	}
+	return clients, nil
+}
+
 // DeleteClientInfoByID deletes a user by their ID.
 func (d *DB) DeleteClientInfoByID(channelID, clientID string) error {
 	txn := d.db.Txn(true)
@@ -198,14 +446,16 @@ func (d *DB) CreatePushConnectionInfo(channelID, clientID, connectionID string)
 		return nil, fmt.Errorf("%s: %w", connectionID, database.ErrConnectionAlreadyExists)
 	}
 
+	now := time.Now()
 	newConn := &database.ConnectionInfo{
-		ID:        connectionID,
-		ChannelID: channelID,
-		From:      clientID,
-		To:        database.MediaServerID,
-		Status:    database.Initialized,
-		Type:      database.PushToServer,
-		CreatedAt: time.Now(),
+		ID:          connectionID,
+		ChannelID:   channelID,
+		From:        clientID,
+		To:          database.MediaServerID,
+		Status:      database.Created,
+		Type:        database.PushToServer,
+		CreatedAt:   now,
+		Transitions: map[database.Status]time.Time{database.Created: now},
 	}
 
 	if err := txn.Insert(tblConnections, newConn); err != nil {
@@ -227,14 +477,16 @@ func (d *DB) CreatePullConnectionInfo(channelID, clientID, connectionID string)
 		return nil, fmt.Errorf("%s: %w", connectionID, database.ErrConnectionAlreadyExists)
 	}
 
+	now := time.Now()
 	newConn := &database.ConnectionInfo{
-		ID:        connectionID,
-		ChannelID: channelID,
-		From:      database.MediaServerID,
-		To:        clientID,
-		Status:    database.Initialized,
-		Type:      database.PullFromServer,
-		CreatedAt: time.Now(),
+		ID:          connectionID,
+		ChannelID:   channelID,
+		From:        database.MediaServerID,
+		To:          clientID,
+		Status:      database.Created,
+		Type:        database.PullFromServer,
+		CreatedAt:   now,
+		Transitions: map[database.Status]time.Time{database.Created: now},
 	}
 
 	if err := txn.Insert(tblConnections, newConn); err != nil {
@@ -255,14 +507,16 @@ func (d *DB) CreatePeerConnectionInfo(channelID, from, to, connectionID string)
 	if raw != nil {
 		return nil, fmt.Errorf("%s: %w", from, database.ErrConnectionAlreadyExists)
 	}
+	now := time.Now()
 	newConn := &database.ConnectionInfo{
-		ID:        connectionID,
-		ChannelID: channelID,
-		From:      from,
-		To:        to,
-		Status:    database.Initialized,
-		Type:      database.PeerToPeer,
-		CreatedAt: time.Now(),
+		ID:          connectionID,
+		ChannelID:   channelID,
+		From:        from,
+		To:          to,
+		Status:      database.Created,
+		Type:        database.PeerToPeer,
+		CreatedAt:   now,
+		Transitions: map[database.Status]time.Time{database.Created: now},
 	}
 	if err := txn.Insert(tblConnections, newConn); err != nil {
 		return nil, fmt.Errorf("insert connection: %w", err)
@@ -306,6 +560,21 @@ func (d *DB) FindDownstreamInfo(channelID, to string) (*database.ConnectionInfo,
 	return nil, database.ErrConnectionNotFound
 }
 
+// FindAllConnectionInfosByChannelID finds all connections of the channel regardless of their type.
+func (d *DB) FindAllConnectionInfosByChannelID(channelID string) ([]*database.ConnectionInfo, error) {
+	txn := d.db.Txn(false)
+	defer txn.Abort()
+	iter, err := txn.Get(tblConnections, idxConnChannelID, channelID)
+	if err != nil {
+		return nil, fmt.Errorf("find connection by channelID: %w", err)
+	}
+	var connections []*database.ConnectionInfo
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		connections = append(connections, raw.(*database.ConnectionInfo).DeepCopy())
+	}
+	return connections, nil
+}
+
 // FindAllPeerConnectionInfoByFrom finds a connection by its from field.
 func (d *DB) FindAllPeerConnectionInfoByFrom(channelID, from string) ([]*database.ConnectionInfo, error) {
 	txn := d.db.Txn(false)
@@ -367,7 +636,7 @@ func (d *DB) FindConnectionInfoByID(ConnectionID string) (*database.ConnectionIn
 }
 
 // UpdateConnectionInfo updates the connection status.
-func (d *DB) UpdateConnectionInfo(connectionID string, status int) (*database.ConnectionInfo, error) {
+func (d *DB) UpdateConnectionInfo(connectionID string, status database.Status) (*database.ConnectionInfo, error) {
 	txn := d.db.Txn(true)
 	defer txn.Abort()
 	raw, err := txn.First(tblConnections, idxConnID, connectionID)
@@ -378,8 +647,29 @@ func (d *DB) UpdateConnectionInfo(connectionID string, status int) (*database.Co
 		return nil, fmt.Errorf("%s: %w", connectionID, database.ErrConnectionNotFound)
 	}
 	info := raw.(*database.ConnectionInfo).DeepCopy()
-	info.Status = status
-	info.ConnectedAt = time.Now()
+	if err := info.Transition(status); err != nil {
+		return nil, err
+	}
+	if err := txn.Insert(tblConnections, info); err != nil {
+		return nil, fmt.Errorf("insert connection: %w", err)
+	}
+	txn.Commit()
+	return info, nil
+}
+
+// UpdateConnectionHealth updates the latest health report of the connection.
+func (d *DB) UpdateConnectionHealth(connectionID string, health database.Health) (*database.ConnectionInfo, error) {
+	txn := d.db.Txn(true)
+	defer txn.Abort()
+	raw, err := txn.First(tblConnections, idxConnID, connectionID)
+	if err != nil {
+		return nil, fmt.Errorf("find connection by connectionID: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%s: %w", connectionID, database.ErrConnectionNotFound)
+	}
+	info := raw.(*database.ConnectionInfo).DeepCopy()
+	info.Health = health
 	if err := txn.Insert(tblConnections, info); err != nil {
 		return nil, fmt.Errorf("insert connection: %w", err)
 	}
@@ -5,13 +5,21 @@ import (
 	"fmt"
 	"github.com/pion/webrtc/v4"
 	"strconv"
+	"time"
 )
 
+// DefaultStatsInterval is the default interval of reporting the stats of connections.
+const DefaultStatsInterval = 10 * time.Second
+
 // Config defines the configuration for the media server.
 type Config struct {
 	IP         string // ip for media server.
 	MinUdpPort string // Minimum UDP port for WebRTC
 	MaxUdpPort string // Maximum UDP port for WebRTC
+
+	// StatsInterval is the interval of reporting the bytes sent through
+	// connections. Zero disables the reports.
+	StatsInterval time.Duration
 }
 
 // SetPortRange sets the ephemeral UDP port range for WebRTC.
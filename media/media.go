@@ -2,11 +2,13 @@
 package media
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"pdn/media/stream"
 	"pdn/metric"
 	"sync"
+	"time"
 
 	"github.com/pion/webrtc/v4"
 	"pdn/broker"
@@ -25,6 +27,9 @@ type Media struct {
 	connections      map[string]*webrtc.PeerConnection
 	connectionConfig webrtc.Configuration
 	config           Config
+
+	// NOTE: handlers are the goroutines handling events, waited on stop.
+	handlers sync.WaitGroup
 }
 
 // Default WebRTC configuration.
@@ -49,24 +54,37 @@ func New(c Config, b *broker.Broker, m *metric.Metrics) *Media {
 	}
 }
 
-// Start starts the Media instance.
-func (m *Media) Start() {
+// Start starts the Media instance. It runs until the context is done, and
+// then closes all connections after the events being handled.
+func (m *Media) Start(ctx context.Context) {
 	upEvent := m.broker.Subscribe(broker.Media, broker.UPSTREAM)
 	downEvent := m.broker.Subscribe(broker.Media, broker.DOWNSTREAM)
 	clearEvent := m.broker.Subscribe(broker.Media, broker.CLEAR)
 	closeEvent := m.broker.Subscribe(broker.Media, broker.CLOSE)
+	var statsTick <-chan time.Time
+	if m.config.StatsInterval > 0 {
+		statsTicker := time.NewTicker(m.config.StatsInterval)
+		defer statsTicker.Stop()
+		statsTick = statsTicker.C
+	}
 
 	for {
 		var err error
 		select {
+		case <-ctx.Done():
+			m.handlers.Wait()
+			m.closeAll()
+			return
 		case event := <-upEvent.Receive():
-			go m.handleUpstream(event)
+			m.handle(func() { m.handleUpstream(event) })
 		case event := <-downEvent.Receive():
-			go m.handleDownstream(event)
+			m.handle(func() { m.handleDownstream(event) })
 		case event := <-clearEvent.Receive():
-			go m.handleClear(event)
+			m.handle(func() { m.handleClear(event) })
 		case event := <-closeEvent.Receive():
-			go m.handleCloseChannel(event)
+			m.handle(func() { m.handleCloseChannel(event) })
+		case <-statsTick:
+			m.handle(m.reportStats)
 		}
 		if err != nil {
 			log.Printf("Failed to handle event in Media: %v", err)
@@ -74,6 +92,31 @@ func (m *Media) Start() {
 	}
 }
 
+// handle runs the handler of an event in its own goroutine.
+func (m *Media) handle(handler func()) {
+	m.handlers.Add(1)
+	go func() {
+		defer m.handlers.Done()
+		handler()
+	}()
+}
+
+// closeAll closes all connections, so that the clients are told by WebRTC
+// that the media server left.
+func (m *Media) closeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for connectionID, conn := range m.connections {
+		if err := conn.Close(); err != nil {
+			log.Printf("failed to close connection %s: %v", connectionID, err)
+		}
+	}
+	log.Printf("Media: closed %d connections", len(m.connections))
+	m.connections = make(map[string]*webrtc.PeerConnection)
+	m.streams = make(map[string]*stream.Stream)
+}
+
 // handleUpstream handles a push event.
 func (m *Media) handleUpstream(event any) {
 	up, ok := event.(message.Upstream)
@@ -95,6 +138,11 @@ func (m *Media) handleUpstream(event any) {
 		log.Printf("failed to publish up response: %v", err)
 		return
 	}
+	if err := m.broker.Publish(broker.Media, broker.ANSWERED, message.Answered{
+		ConnectionID: up.ConnectionID,
+	}); err != nil {
+		log.Printf("failed to publish answered message: %v", err)
+	}
 }
 
 // handleDownstream handles a pull event.
@@ -119,6 +167,11 @@ func (m *Media) handleDownstream(event any) {
 		log.Printf("failed to publish down response: %v", err)
 		return
 	}
+	if err := m.broker.Publish(broker.Media, broker.ANSWERED, message.Answered{
+		ConnectionID: down.ConnectionID,
+	}); err != nil {
+		log.Printf("failed to publish answered message: %v", err)
+	}
 }
 
 // handleClear handles a close event.
@@ -268,6 +321,12 @@ func (m *Media) publishStateChange(conn *webrtc.PeerConnection, connectionID str
 	conn.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		//log.Printf("Peer %s: ICE Peer State has changed to %s", connectionID, state.String())
 		switch state {
+		case webrtc.PeerConnectionStateConnecting:
+			if err := m.broker.Publish(broker.Media, broker.CONNECTING, message.Connecting{
+				ConnectionID: connectionID,
+			}); err != nil {
+				log.Printf("failed to publish connecting message: %v", err)
+			}
 		case webrtc.PeerConnectionStateConnected:
 			log.Printf("Media: connection %s: Connected", connectionID)
 			m.metric.IncrementWebRTCConnections()
@@ -286,8 +345,18 @@ func (m *Media) publishStateChange(conn *webrtc.PeerConnection, connectionID str
 			//}
 		case webrtc.PeerConnectionStateDisconnected:
 			log.Printf("Media: connection %s: Disconnected", connectionID)
+			if err := m.broker.Publish(broker.Media, broker.DISCONNECTED, message.Disconnected{
+				ConnectionID: connectionID,
+			}); err != nil {
+				log.Printf("failed to publish disconnected message: %v", err)
+			}
 		case webrtc.PeerConnectionStateFailed:
 			log.Printf("Media: connection %s: Failed", connectionID)
+			if err := m.broker.Publish(broker.Media, broker.FAILED, message.Failed{
+				ConnectionID: connectionID,
+			}); err != nil {
+				log.Printf("failed to publish failed message: %v", err)
+			}
 		default:
 		}
 	})
@@ -306,3 +375,29 @@ func (m *Media) registerStream(connectionID string, s *stream.Stream) {
 	defer m.mu.Unlock()
 	m.streams[connectionID] = s
 }
+
+// reportStats publishes the total bytes sent through each connection, so the
+// bytes delivered by Media server are counted.
+func (m *Media) reportStats() {
+	m.mu.RLock()
+	connections := make(map[string]*webrtc.PeerConnection, len(m.connections))
+	for id, conn := range m.connections {
+		connections[id] = conn
+	}
+	m.mu.RUnlock()
+
+	for id, conn := range connections {
+		var bytes int64
+		for _, stats := range conn.GetStats() {
+			if transport, ok := stats.(webrtc.TransportStats); ok {
+				bytes += int64(transport.BytesSent)
+			}
+		}
+		if err := m.broker.Publish(broker.Media, broker.STATS, message.Stats{
+			ConnectionID: id,
+			Bytes:        bytes,
+		}); err != nil {
+			log.Printf("failed to publish stats message: %v", err)
+		}
+	}
+}
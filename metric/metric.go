@@ -2,6 +2,7 @@
 package metric
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -39,12 +40,33 @@ type Metrics struct {
 	peerConnections prometheus.Gauge
 
 	balancingOccurs prometheus.Counter
+	clientDepth     prometheus.Histogram
+
+	unhealthyConnections prometheus.Counter
+	superPeers           prometheus.Gauge
+	rebalanceMoves       *prometheus.CounterVec
+	recoveryTime         prometheus.Histogram
+	negotiationTimeouts  *prometheus.CounterVec
+
+	deliveredBytes *prometheus.CounterVec
+	viewerSeconds  *prometheus.CounterVec
+	offloadRatio   *prometheus.GaugeVec
+
+	rateLimited          *prometheus.CounterVec
+	rateLimitDisconnects prometheus.Counter
+
+	droppedMessages *prometheus.CounterVec
 }
 
 // New creates a new Metrics instance with the specified configuration.
 func New(c Config) *Metrics {
 	return &Metrics{
 		config: c,
+		httpServer: &http.Server{
+			Addr:              fmt.Sprintf(":%d", c.Port),
+			Handler:           promhttp.Handler(),
+			ReadHeaderTimeout: 5 * time.Second,
+		},
 		webSocketConnections: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "websocket_connections_total",
 			Help: "Current number of WebSocket connections.",
@@ -93,6 +115,56 @@ func New(c Config) *Metrics {
 			Name: "balancing_occurs_total",
 			Help: "Total number of load balancing occurrences.",
 		}),
+		clientDepth: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "client_delivery_depth",
+			Help:    "Delivery depth of clients from the media server.",
+			Buckets: prometheus.LinearBuckets(1, 1, 8),
+		}),
+		unhealthyConnections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "unhealthy_peer_connections_total",
+			Help: "Total number of unhealthy peer connection reports.",
+		}),
+		superPeers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "super_peers_total",
+			Help: "Current number of super-peers.",
+		}),
+		rebalanceMoves: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rebalance_moves_total",
+			Help: "Total number of fetchers moved by rebalancing.",
+		}, []string{"reason"}),
+		recoveryTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "fetcher_recovery_seconds",
+			Help:    "Time from losing a forwarder to receiving the stream again.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+		}),
+		negotiationTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "negotiation_timeouts_total",
+			Help: "Total number of peer connections failed by missing the deadline of a negotiation phase.",
+		}, []string{"status"}),
+		deliveredBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "delivered_bytes_total",
+			Help: "Total bytes of the stream delivered to viewers by the source.",
+		}, []string{"channel", "source"}),
+		viewerSeconds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "viewer_seconds_total",
+			Help: "Total seconds that viewers received the stream by the source.",
+		}, []string{"channel", "source"}),
+		offloadRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "offload_ratio",
+			Help: "Ratio of the bytes delivered by peers to all the bytes delivered to viewers.",
+		}, []string{"channel"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limited_total",
+			Help: "Total number of events rejected by rate limits.",
+		}, []string{"scope"}), // Scope: "connection", "auth", "request" or "join"
+		rateLimitDisconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limit_disconnects_total",
+			Help: "Total number of clients disconnected for exceeding rate limits repeatedly.",
+		}),
+		droppedMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "broker_dropped_messages_total",
+			Help: "Total number of broker messages dropped because a subscriber didn't receive them in time.",
+		}, []string{"topic", "detail"}),
 	}
 }
 
@@ -110,22 +182,36 @@ func (m *Metrics) registerMetrics() {
 	prometheus.MustRegister(m.pullConnections)
 	prometheus.MustRegister(m.peerConnections)
 	prometheus.MustRegister(m.balancingOccurs)
+	prometheus.MustRegister(m.clientDepth)
+	prometheus.MustRegister(m.unhealthyConnections)
+	prometheus.MustRegister(m.superPeers)
+	prometheus.MustRegister(m.rebalanceMoves)
+	prometheus.MustRegister(m.recoveryTime)
+	prometheus.MustRegister(m.negotiationTimeouts)
+	prometheus.MustRegister(m.deliveredBytes)
+	prometheus.MustRegister(m.viewerSeconds)
+	prometheus.MustRegister(m.offloadRatio)
+	prometheus.MustRegister(m.rateLimited)
+	prometheus.MustRegister(m.rateLimitDisconnects)
+	prometheus.MustRegister(m.droppedMessages)
 }
 
-// Start initializes and starts the metrics HTTP server.
-func (m *Metrics) Start() {
+// Start initializes and starts the metrics HTTP server. The system metrics
+// are collected until the context is done, and Start returns after both the
+// collection and the server are stopped.
+func (m *Metrics) Start(ctx context.Context) {
 	m.registerMetrics()
-	m.httpServer = &http.Server{
-		Addr:              fmt.Sprintf(":%d", m.config.Port),
-		Handler:           promhttp.Handler(),
-		ReadHeaderTimeout: 5 * time.Second,
-	}
 
-	go m.UpdateSystemMetrics()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.UpdateSystemMetrics(ctx)
+	}()
 	log.Printf("Starting metrics server on port %d at path %s", m.config.Port, m.config.Path)
 	if err := m.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Error starting metrics server: %v", err)
 	}
+	<-done
 }
 
 // Stop gracefully shuts down the metrics server.
@@ -137,8 +223,9 @@ func (m *Metrics) Stop() error {
 	return nil
 }
 
-// UpdateSystemMetrics collects and updates system-level metrics (e.g., memory usage).
-func (m *Metrics) UpdateSystemMetrics() {
+// UpdateSystemMetrics collects and updates system-level metrics (e.g., memory usage)
+// until the context is done.
+func (m *Metrics) UpdateSystemMetrics(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 	netStats, err := net.IOCounters(false)
@@ -146,14 +233,17 @@ func (m *Metrics) UpdateSystemMetrics() {
 		panic(err)
 	}
 	prev := netStats[0]
-	for range ticker.C {
-		prev, err = m.collectMetrics(prev)
-		if err != nil {
-			log.Printf("Error collecting metrics: %v", err)
+	for {
+		select {
+		case <-ticker.C:
+			prev, err = m.collectMetrics(prev)
+			if err != nil {
+				log.Printf("Error collecting metrics: %v", err)
+			}
+		case <-ctx.Done():
+			log.Println("Stopping metrics collection")
+			return
 		}
-		//case <-stop:
-		//	log.Println("Stopping metrics collection")
-		//	return
 	}
 }
 
@@ -263,3 +353,76 @@ func (m *Metrics) DecrementPullConnections() {
 func (m *Metrics) IncrementBalancingOccurs() {
 	m.balancingOccurs.Inc()
 }
+
+// ObserveClientDepth records the delivery depth of a client.
+func (m *Metrics) ObserveClientDepth(depth int) {
+	m.clientDepth.Observe(float64(depth))
+}
+
+// IncrementUnhealthyConnections increments the number of unhealthy peer connection reports by 1.
+func (m *Metrics) IncrementUnhealthyConnections() {
+	m.unhealthyConnections.Inc()
+}
+
+// IncrementSuperPeers increments the number of super-peers by 1.
+func (m *Metrics) IncrementSuperPeers() {
+	m.superPeers.Inc()
+}
+
+// DecrementSuperPeers decrements the number of super-peers by 1.
+func (m *Metrics) DecrementSuperPeers() {
+	m.superPeers.Dec()
+}
+
+// IncrementRebalanceMoves increments the number of fetchers moved by rebalancing for the reason.
+func (m *Metrics) IncrementRebalanceMoves(reason string) {
+	m.rebalanceMoves.WithLabelValues(reason).Inc()
+}
+
+// ObserveRecoveryTime records the time that a fetcher took to receive the stream again after losing its forwarder.
+func (m *Metrics) ObserveRecoveryTime(d time.Duration) {
+	m.recoveryTime.Observe(d.Seconds())
+}
+
+// IncrementNegotiationTimeouts increments the number of peer connections that timed out in the status by 1.
+func (m *Metrics) IncrementNegotiationTimeouts(status string) {
+	m.negotiationTimeouts.WithLabelValues(status).Inc()
+}
+
+// AddDeliveredBytes adds the bytes delivered to viewers of the channel by the source.
+func (m *Metrics) AddDeliveredBytes(channelID, source string, bytes int64) {
+	m.deliveredBytes.WithLabelValues(channelID, source).Add(float64(bytes))
+}
+
+// AddViewerTime adds the time that viewers of the channel received the stream by the source.
+func (m *Metrics) AddViewerTime(channelID, source string, d time.Duration) {
+	m.viewerSeconds.WithLabelValues(channelID, source).Add(d.Seconds())
+}
+
+// SetOffloadRatio sets the ratio of the bytes delivered by peers in the channel.
+func (m *Metrics) SetOffloadRatio(channelID string, ratio float64) {
+	m.offloadRatio.WithLabelValues(channelID).Set(ratio)
+}
+
+// IncrementRateLimited increments the number of events rejected by the rate limit of the scope by 1.
+func (m *Metrics) IncrementRateLimited(scope string) {
+	m.rateLimited.WithLabelValues(scope).Inc()
+}
+
+// IncrementRateLimitDisconnects increments the number of clients disconnected for exceeding rate limits by 1.
+func (m *Metrics) IncrementRateLimitDisconnects() {
+	m.rateLimitDisconnects.Inc()
+}
+
+// DeleteChannelDelivery deletes the delivery metrics of the closed channel.
+func (m *Metrics) DeleteChannelDelivery(channelID string) {
+	labels := prometheus.Labels{"channel": channelID}
+	m.deliveredBytes.DeletePartialMatch(labels)
+	m.viewerSeconds.DeletePartialMatch(labels)
+	m.offloadRatio.DeletePartialMatch(labels)
+}
+
+// IncrementDroppedMessages increments the number of broker messages dropped in the topic and detail by 1.
+func (m *Metrics) IncrementDroppedMessages(topic, detail string) {
+	m.droppedMessages.WithLabelValues(topic, detail).Inc()
+}
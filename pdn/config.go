@@ -2,13 +2,33 @@
 package pdn
 
 import (
+	"errors"
+	"fmt"
+	"pdn/admin"
+	"pdn/auth"
 	"pdn/coordinator"
 	"pdn/database"
 	"pdn/media"
 	"pdn/metric"
 	"pdn/signal"
+	"time"
 )
 
+// Default durations of the shutdown.
+const (
+	// DefaultShutdownTimeout is the default duration that the PDN must shut
+	// down in.
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// DefaultStopTimeout is the default duration kept out of the shutdown
+	// timeout for the components to stop in.
+	DefaultStopTimeout = 5 * time.Second
+)
+
+// ErrInvalidShutdown is returned when the shutdown leaves no time to drain
+// the clients or to stop the components.
+var ErrInvalidShutdown = errors.New("invalid shutdown timeout")
+
 // Config contains the configuration for the PDN.
 type Config struct {
 	Signal      signal.Config
@@ -16,4 +36,22 @@ type Config struct {
 	Coordinator coordinator.Config
 	Metrics     metric.Config
 	Media       media.Config
+	Admin       admin.Config
+	Auth        auth.Config
+
+	// ShutdownTimeout is the duration that the clients are given to move to
+	// another server and the components to stop in, on shutdown. StopTimeout
+	// of it is for the components, and the rest is for the clients.
+	ShutdownTimeout time.Duration
+	StopTimeout     time.Duration
+}
+
+// Validate validates that the shutdown gives time to both the clients and the
+// components.
+func (c Config) Validate() error {
+	if c.StopTimeout <= 0 || c.ShutdownTimeout <= c.StopTimeout {
+		return fmt.Errorf("stop timeout %s must be positive and shorter than shutdown timeout %s: %w",
+			c.StopTimeout, c.ShutdownTimeout, ErrInvalidShutdown)
+	}
+	return nil
 }
@@ -2,7 +2,12 @@
 package pdn
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"pdn/admin"
+	"pdn/auth"
 	"pdn/broker"
 	"pdn/coordinator"
 	"pdn/database"
@@ -11,10 +16,12 @@ import (
 	"pdn/metric"
 	"pdn/pool"
 	"pdn/signal"
+	"sync"
 )
 
 // PDN contains servers and configuration.
 type PDN struct {
+	config      Config
 	broker      *broker.Broker
 	database    database.Database
 	media       *media.Media
@@ -22,19 +29,32 @@ type PDN struct {
 	signal      *signal.Signal
 	metric      *metric.Metrics
 	pool        *pool.Pool
+	admin       *admin.Admin
 }
 
 // New creates a new instance of PDN.
-func New(config Config) *PDN {
+func New(config Config) (*PDN, error) {
+	strategy, err := pool.NewStrategy(config.Coordinator.Strategy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create strategy: %w", err)
+	}
+
+	verifier, err := auth.New(config.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token verifier: %w", err)
+	}
+
 	met := metric.New(config.Metrics)
-	brk := broker.New()
+	brk := broker.New(met)
 	db := memory.New(config.Database)
 	med := media.New(config.Media, brk, met)
-	pl := pool.New(db)
+	pl := pool.New(db, strategy)
 	cod := coordinator.New(config.Coordinator, brk, met, db, pl)
-	sig := signal.New(config.Signal, db, brk, met)
+	sig := signal.New(config.Signal, db, brk, met, verifier)
+	adm := admin.New(config.Admin, db, cod)
 
 	return &PDN{
+		config:      config,
 		broker:      brk,
 		database:    db,
 		media:       med,
@@ -42,16 +62,85 @@ func New(config Config) *PDN {
 		coordinator: cod,
 		signal:      sig,
 		metric:      met,
+		admin:       adm,
+	}, nil
+}
+
+// Start runs the signal server and the other components until the context is
+// done, e.g. by SIGTERM, and then shuts them down within the shutdown timeout.
+func (p *PDN) Start(ctx context.Context) error {
+	componentCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var components sync.WaitGroup
+	run := func(start func()) {
+		components.Add(1)
+		go func() {
+			defer components.Done()
+			start()
+		}()
+	}
+	run(func() { p.metric.Start(componentCtx) })
+	run(func() { p.media.Start(componentCtx) })
+	run(func() { p.coordinator.Start(componentCtx) })
+	run(func() {
+		if err := p.admin.Start(); err != nil {
+			log.Printf("failed to start admin server: %v", err)
+		}
+	})
+
+	started := make(chan error, 1)
+	go func() {
+		started <- p.signal.Start()
+	}()
+
+	var startErr error
+	select {
+	case <-ctx.Done():
+	case err := <-started:
+		if err != nil {
+			startErr = fmt.Errorf("failed to start signal server: %w", err)
+		}
 	}
+	return errors.Join(startErr, p.shutdown(cancel, &components))
 }
 
-// Start runs the signal server and metrics server.
-func (p *PDN) Start() error {
-	go p.metric.Start()
-	go p.media.Start()
-	go p.coordinator.Start()
-	if err := p.signal.Start(); err != nil {
-		return fmt.Errorf("failed to start signal server: %w", err)
+// shutdown drains the signal server first, so that the coordinator and the
+// media server handle the clients leaving. Then it stops the components and
+// closes the broker after them. The drain and the stop have their own
+// deadlines, so a slow drain doesn't leave the components no time to stop.
+// The broker is kept open if the components don't stop in time, since they
+// may still use it.
+func (p *PDN) shutdown(cancel context.CancelFunc, components *sync.WaitGroup) error {
+	log.Printf("Shutting down PDN in %s", p.config.ShutdownTimeout)
+
+	var errs []error
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), p.config.ShutdownTimeout-p.config.StopTimeout)
+	defer cancelDrain()
+	if err := p.signal.Shutdown(drainCtx); err != nil {
+		errs = append(errs, err)
+	}
+
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), p.config.StopTimeout)
+	defer cancelStop()
+	cancel()
+	if err := p.admin.Shutdown(stopCtx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := p.metric.Stop(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to stop metrics server: %w", err))
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		components.Wait()
+	}()
+	select {
+	case <-stopped:
+		p.broker.Close()
+	case <-stopCtx.Done():
+		errs = append(errs, fmt.Errorf("failed to stop components: %w", stopCtx.Err()))
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -4,16 +4,36 @@ package pool
 import (
 	"github.com/wangjia184/sortedset"
 	"pdn/database"
+	"slices"
 	"sync"
-	"time"
 )
 
-// Constants defining the bit allocation for score calculation.
+// Constants defining the bit allocation for score calculation. The depth has
+// the highest priority, so the shallowest forwarder is always preferred. Then
+// super-peers are preferred, and the class of the client by its reported
+// capabilities follows. The bits below them are filled by the Strategy of the
+// pool.
 const (
-	ConnectionCountBits = 61
-	CreatedAtBits       = 29
+	DepthBits     = 60
+	SuperPeerBits = 59
+	ClassBits     = 57
 )
 
+// MaxClass is the class of a client that has no known drawback as a forwarder.
+const MaxClass = 1<<(SuperPeerBits-ClassBits) - 1
+
+// Constants for lowering the class of a client that fails peer connections a
+// lot. The rate is used only after enough attempts, so a single unlucky
+// failure doesn't matter.
+const (
+	MinPeerAttempts    = 3
+	LowPeerSuccessRate = 0.5
+)
+
+// MaxScoredDepth is the deepest depth that can be distinguished in the score.
+// Forwarders deeper than this are scored the same as MaxScoredDepth.
+const MaxScoredDepth = 7
+
 // channelSet manages a single channel's sorted set and its lock
 type channelSet struct {
 	mutex sync.RWMutex
@@ -25,13 +45,15 @@ type Pool struct {
 	globalMutex sync.RWMutex
 	sets        map[string]*channelSet
 	database    database.Database
+	strategy    Strategy
 }
 
-// New initializes a new Pool with a database reference
-func New(db database.Database) *Pool {
+// New initializes a new Pool with a database reference and a strategy
+func New(db database.Database, strategy Strategy) *Pool {
 	return &Pool{
 		sets:     make(map[string]*channelSet),
 		database: db,
+		strategy: strategy,
 	}
 }
 
@@ -58,10 +80,37 @@ func (p *Pool) getOrCreateSet(channelID string) *channelSet {
 	return cs
 }
 
-// calculateScore calculates the score based on connection count and created time
-func calculateScore(connectionCount int64, createdAt time.Time) int64 {
-	elapsedSeconds := int64(time.Since(createdAt).Seconds())
-	return (connectionCount << ConnectionCountBits) | (elapsedSeconds << CreatedAtBits)
+// calculateScore calculates the score based on depth, tier, class and the score of the strategy
+func (p *Pool) calculateScore(client database.ClientInfo, connectionCount int64) int64 {
+	shallowness := int64(MaxScoredDepth - min(max(client.Depth, 0), MaxScoredDepth))
+	score := min(max(p.strategy.Score(Candidate{
+		Client:          client,
+		ConnectionCount: connectionCount,
+	}), 0), MaxStrategyScore)
+	var superPeer int64
+	if client.SuperPeer {
+		superPeer = 1
+	}
+	return (shallowness << DepthBits) | (superPeer << SuperPeerBits) |
+		(calculateClass(client) << ClassBits) | score
+}
+
+// calculateClass calculates the class of the client by its reported
+// capabilities and its history. Mobile devices, clients behind symmetric NAT
+// and clients that fail peer connections a lot are less suitable as
+// forwarders.
+func calculateClass(client database.ClientInfo) int64 {
+	class := int64(MaxClass)
+	if client.Capabilities.DeviceClass == database.DeviceMobile {
+		class--
+	}
+	if client.Capabilities.NATType == database.NATSymmetric {
+		class--
+	}
+	if client.PeerSuccesses+client.PeerFailures >= MinPeerAttempts && client.PeerSuccessRate() < LowPeerSuccessRate {
+		class--
+	}
+	return max(class, 0)
 }
 
 // getConnectionCount retrieves the connection count for a client ID from the database
@@ -80,60 +129,63 @@ func (p *Pool) AddClient(client database.ClientInfo) error {
 	cs.mutex.Lock()
 	defer cs.mutex.Unlock()
 
+	return p.addClient(cs, client)
+}
+
+// addClient adds the client to the given set. The caller must hold the lock of the set.
+func (p *Pool) addClient(cs *channelSet, client database.ClientInfo) error {
+	if client.Capabilities.ForwardingDisabled {
+		cs.set.Remove(client.ID)
+		return nil
+	}
 	connectionCount, err := p.getConnectionCount(client.ID, client.ChannelID)
 	if err != nil {
 		return err
 	}
+	if connectionCount >= int64(client.Capacity) {
+		cs.set.Remove(client.ID)
+		return nil
+	}
 
-	score := calculateScore(connectionCount, client.CreatedAt)
+	score := p.calculateScore(client, connectionCount)
 	cs.set.AddOrUpdate(client.ID, sortedset.SCORE(score), client)
 	return nil
 }
 
-// UpdateClientScore recalculates the score for a specific client in a channel
-func (p *Pool) UpdateClientScore(clientID, channelID string, maxForwardingNum int) error {
+// UpdateClientScore recalculates the score for a specific client in a channel.
+// The client is removed from the pool if it forwards as many as its capacity.
+func (p *Pool) UpdateClientScore(clientID, channelID string) error {
 	cs := p.getOrCreateSet(channelID)
 
 	cs.mutex.Lock()
 	defer cs.mutex.Unlock()
 
-	node := cs.set.GetByKey(clientID)
-	if node == nil {
-		clientInfo, err := p.database.FindClientInfoByID(channelID, clientID)
-		if err != nil {
-			return err
-		}
-		if err := p.AddClient(*clientInfo); err != nil {
-			return err
-		}
-		return nil
-	}
-	client := node.Value.(database.ClientInfo)
-	connectionCount, err := p.getConnectionCount(clientID, channelID)
+	// NOTE: The client info is always read again because the depth and the
+	// capacity of the client could be changed after it was added to the pool.
+	client, err := p.database.FindClientInfoByID(channelID, clientID)
 	if err != nil {
 		return err
 	}
-	if connectionCount >= int64(maxForwardingNum) {
-		cs.set.Remove(client.ID)
-		return nil
-	}
-	newScore := calculateScore(connectionCount, client.CreatedAt)
-	cs.set.AddOrUpdate(client.ID, sortedset.SCORE(newScore), client)
-	return nil
+	return p.addClient(cs, *client)
 }
 
-// GetTopForwarder retrieves the highest scored forwarder for a specific channel
-func (p *Pool) GetTopForwarder(channelID string) *database.ClientInfo {
+// GetTopForwarder retrieves the highest scored forwarder for a specific channel.
+// Clients in excludes are skipped, e.g. the fetcher itself and its descendants.
+func (p *Pool) GetTopForwarder(channelID string, excludes ...string) *database.ClientInfo {
 	cs := p.getOrCreateSet(channelID)
 	cs.mutex.RLock()
 	defer cs.mutex.RUnlock()
 
-	topNode := cs.set.PeekMax()
-	if topNode == nil {
-		return nil
-	}
-	client := topNode.Value.(database.ClientInfo)
-	return &client
+	var top *database.ClientInfo
+	cs.set.IterFuncByRankRange(-1, 1, func(key string, value interface{}) bool {
+		if slices.Contains(excludes, key) {
+			return true
+		}
+		client := value.(database.ClientInfo)
+		top = &client
+		return false
+	})
+	return top
 }
 
 // RemoveClient removes a client from the pool for a specific channel
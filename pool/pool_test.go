@@ -0,0 +1,103 @@
+package pool_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"pdn/database"
+	"pdn/database/memory"
+	"pdn/pool"
+	"slices"
+	"testing"
+)
+
+// TestGetTopForwarder tests that the pool prefers the shallowest forwarder.
+func TestGetTopForwarder(t *testing.T) {
+	const channelID = "channel"
+
+	tests := []struct {
+		name       string
+		depths     map[string]int
+		optOuts    []string
+		superPeers []string
+		failers    []string
+		excludes   []string
+		want       string
+	}{
+		{
+			name:   "given forwarders in different depths when get top then return shallowest",
+			depths: map[string]int{"deep": 3, "shallow": 1, "middle": 2},
+			want:   "shallow",
+		},
+		{
+			name:     "given shallowest forwarder excluded when get top then return next shallowest",
+			depths:   map[string]int{"deep": 3, "shallow": 1, "middle": 2},
+			excludes: []string{"shallow"},
+			want:     "middle",
+		},
+		{
+			name:    "given shallowest forwarder opted out when get top then return next shallowest",
+			depths:  map[string]int{"deep": 3, "shallow": 1, "middle": 2},
+			optOuts: []string{"shallow"},
+			want:    "middle",
+		},
+		{
+			name:       "given super-peer in same depth when get top then return super-peer",
+			depths:     map[string]int{"peer": 1, "super": 1, "deep": 2},
+			superPeers: []string{"super"},
+			want:       "super",
+		},
+		{
+			name:       "given super-peer deeper when get top then return shallower peer",
+			depths:     map[string]int{"peer": 1, "super": 2},
+			superPeers: []string{"super"},
+			want:       "peer",
+		},
+		{
+			name:    "given forwarder failing a lot in same depth when get top then return other forwarder",
+			depths:  map[string]int{"failer": 1, "peer": 1},
+			failers: []string{"failer"},
+			want:    "peer",
+		},
+		{
+			name:     "given all forwarders excluded when get top then return nil",
+			depths:   map[string]int{"shallow": 1},
+			excludes: []string{"shallow"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := memory.New(database.Config{AutoCreateChannels: true})
+			strategy, err := pool.NewStrategy(pool.DefaultStrategy)
+			assert.NoError(t, err)
+			p := pool.New(db, strategy)
+			for id, depth := range tt.depths {
+				assert.NoError(t, db.CreateClientInfo(channelID, id))
+				_, err := db.UpdateClientDepth(channelID, id, depth)
+				assert.NoError(t, err)
+				_, err = db.UpdateClientCapacity(channelID, id, 1)
+				assert.NoError(t, err)
+				_, err = db.UpdateClientSuperPeer(channelID, id, slices.Contains(tt.superPeers, id))
+				assert.NoError(t, err)
+				if slices.Contains(tt.failers, id) {
+					for range pool.MinPeerAttempts {
+						_, err = db.UpdateClientPeerResult(channelID, id, false)
+						assert.NoError(t, err)
+					}
+				}
+				client, err := db.UpdateClientCapabilities(channelID, id, database.Capabilities{
+					ForwardingDisabled: slices.Contains(tt.optOuts, id),
+				})
+				assert.NoError(t, err)
+				assert.NoError(t, p.AddClient(*client))
+			}
+
+			got := p.GetTopForwarder(channelID, tt.excludes...)
+			if tt.want == "" {
+				assert.Nil(t, got)
+				return
+			}
+			assert.NotNil(t, got)
+			assert.Equal(t, tt.want, got.ID)
+		})
+	}
+}
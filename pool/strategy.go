@@ -0,0 +1,163 @@
+package pool
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"pdn/database"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the built-in strategies.
+const (
+	LeastLoaded       = "least-loaded"
+	BandwidthWeighted = "bandwidth"
+	RTTAware          = "rtt"
+	Random            = "random"
+	RoundRobin        = "round-robin"
+)
+
+// DefaultStrategy is the strategy used when no strategy is configured.
+const DefaultStrategy = LeastLoaded
+
+// MaxStrategyScore is the maximum score that a strategy can return. The bits
+// above it are reserved for the depth, the tier and the class of the forwarder.
+const MaxStrategyScore = 1<<ClassBits - 1
+
+// reportedBit is set in the score of strategies that prefer candidates which
+// reported the capability the strategy depends on.
+const reportedBit = ClassBits - 1
+
+// Constants defining the bit allocation for the least-loaded strategy.
+const (
+	ConnectionCountBits = 53
+	CreatedAtBits       = 21
+)
+
+// maxConnectionCount is the largest connection count that can be distinguished in the score.
+const maxConnectionCount = 1<<(ClassBits-ConnectionCountBits) - 1
+
+// Candidate is a forwarder candidate to be scored by a Strategy.
+type Candidate struct {
+	Client          database.ClientInfo
+	ConnectionCount int64
+}
+
+// Strategy scores forwarder candidates. The candidate with the highest score
+// is selected as a forwarder. The score is calculated when a candidate is
+// added or updated, so a strategy can change the order of candidates by
+// returning a different score for the same candidate.
+type Strategy interface {
+	// Name returns the name of the strategy.
+	Name() string
+
+	// Score returns the score of the candidate between 0 and MaxStrategyScore.
+	Score(candidate Candidate) int64
+}
+
+// NewStrategy creates a built-in strategy by its name.
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case LeastLoaded:
+		return leastLoaded{}, nil
+	case BandwidthWeighted:
+		return bandwidthWeighted{}, nil
+	case RTTAware:
+		return rttAware{}, nil
+	case Random:
+		return random{}, nil
+	case RoundRobin:
+		return &roundRobin{}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy: %s", name)
+	}
+}
+
+// elapsedSeconds returns the elapsed seconds since the client was created.
+func elapsedSeconds(candidate Candidate) int64 {
+	return max(int64(time.Since(candidate.Client.CreatedAt).Seconds()), 0)
+}
+
+// leastLoaded prefers the candidate with the fewest connections, then the oldest one.
+type leastLoaded struct{}
+
+// Name returns the name of the strategy.
+func (leastLoaded) Name() string {
+	return LeastLoaded
+}
+
+// Score returns the score based on connection count and created time.
+func (leastLoaded) Score(candidate Candidate) int64 {
+	idle := maxConnectionCount - min(max(candidate.ConnectionCount, 0), maxConnectionCount)
+	elapsed := min(elapsedSeconds(candidate), 1<<(ConnectionCountBits-CreatedAtBits)-1)
+	return (idle << ConnectionCountBits) | (elapsed << CreatedAtBits)
+}
+
+// bandwidthWeighted prefers the candidate with the largest upload bandwidth
+// per connection. Candidates that didn't report bandwidth are scored by the
+// least-loaded strategy below all the others.
+type bandwidthWeighted struct{}
+
+// Name returns the name of the strategy.
+func (bandwidthWeighted) Name() string {
+	return BandwidthWeighted
+}
+
+// Score returns the score based on the upload bandwidth per connection.
+func (bandwidthWeighted) Score(candidate Candidate) int64 {
+	bandwidth := int64(candidate.Client.Capabilities.UploadBandwidth)
+	if bandwidth <= 0 {
+		return leastLoaded{}.Score(candidate) >> 1
+	}
+	perConnection := bandwidth / (candidate.ConnectionCount + 1)
+	return 1<<reportedBit | min(perConnection, 1<<reportedBit-1)
+}
+
+// rttAware prefers the candidate with the lowest round trip time. Candidates
+// that didn't report RTT are scored by the least-loaded strategy below all
+// the others.
+type rttAware struct{}
+
+// Name returns the name of the strategy.
+func (rttAware) Name() string {
+	return RTTAware
+}
+
+// Score returns the score based on the round trip time.
+func (rttAware) Score(candidate Candidate) int64 {
+	rtt := candidate.Client.Capabilities.RTT.Microseconds()
+	if rtt <= 0 {
+		return leastLoaded{}.Score(candidate) >> 1
+	}
+	return 1<<reportedBit | (1<<reportedBit - 1 - min(rtt, 1<<reportedBit-1))
+}
+
+// random selects a candidate randomly.
+type random struct{}
+
+// Name returns the name of the strategy.
+func (random) Name() string {
+	return Random
+}
+
+// Score returns a random score.
+func (random) Score(_ Candidate) int64 {
+	return rand.Int64N(MaxStrategyScore + 1)
+}
+
+// roundRobin selects candidates in turn. Every scoring gives a lower score
+// than before, so a candidate goes to the end of the queue whenever its score
+// is updated after forwarding.
+type roundRobin struct {
+	sequence atomic.Int64
+}
+
+// Name returns the name of the strategy.
+func (*roundRobin) Name() string {
+	return RoundRobin
+}
+
+// Score returns a score lower than all the previous scores.
+func (r *roundRobin) Score(_ Candidate) int64 {
+	return MaxStrategyScore - r.sequence.Add(1)%(MaxStrategyScore+1)
+}
@@ -0,0 +1,84 @@
+package pool_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"pdn/database"
+	"pdn/pool"
+	"testing"
+	"time"
+)
+
+// TestStrategyScore tests that the built-in strategies prefer the expected candidate.
+func TestStrategyScore(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		strategy  string
+		preferred pool.Candidate
+		other     pool.Candidate
+	}{
+		{
+			name:      "given least-loaded when fewer connections then preferred",
+			strategy:  pool.LeastLoaded,
+			preferred: pool.Candidate{Client: database.ClientInfo{CreatedAt: now}, ConnectionCount: 0},
+			other:     pool.Candidate{Client: database.ClientInfo{CreatedAt: now}, ConnectionCount: 1},
+		},
+		{
+			name:      "given least-loaded when same connections then older preferred",
+			strategy:  pool.LeastLoaded,
+			preferred: pool.Candidate{Client: database.ClientInfo{CreatedAt: now.Add(-time.Hour)}},
+			other:     pool.Candidate{Client: database.ClientInfo{CreatedAt: now}},
+		},
+		{
+			name:     "given bandwidth when larger bandwidth per connection then preferred",
+			strategy: pool.BandwidthWeighted,
+			preferred: pool.Candidate{Client: database.ClientInfo{
+				Capabilities: database.Capabilities{UploadBandwidth: 20000},
+			}, ConnectionCount: 1},
+			other: pool.Candidate{Client: database.ClientInfo{
+				Capabilities: database.Capabilities{UploadBandwidth: 8000},
+			}},
+		},
+		{
+			name:     "given bandwidth when bandwidth unknown then reported one preferred",
+			strategy: pool.BandwidthWeighted,
+			preferred: pool.Candidate{Client: database.ClientInfo{
+				Capabilities: database.Capabilities{UploadBandwidth: 1},
+			}},
+			other: pool.Candidate{Client: database.ClientInfo{CreatedAt: now.Add(-time.Hour)}},
+		},
+		{
+			name:     "given rtt when lower rtt then preferred",
+			strategy: pool.RTTAware,
+			preferred: pool.Candidate{Client: database.ClientInfo{
+				Capabilities: database.Capabilities{RTT: 20 * time.Millisecond},
+			}},
+			other: pool.Candidate{Client: database.ClientInfo{
+				Capabilities: database.Capabilities{RTT: 80 * time.Millisecond},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := pool.NewStrategy(tt.strategy)
+			assert.NoError(t, err)
+			assert.Greater(t, strategy.Score(tt.preferred), strategy.Score(tt.other))
+		})
+	}
+}
+
+// TestRoundRobin tests that the round-robin strategy gives lower scores over time.
+func TestRoundRobin(t *testing.T) {
+	strategy, err := pool.NewStrategy(pool.RoundRobin)
+	assert.NoError(t, err)
+	first := strategy.Score(pool.Candidate{})
+	second := strategy.Score(pool.Candidate{})
+	assert.Greater(t, first, second)
+}
+
+// TestNewStrategy tests that an unknown strategy is rejected.
+func TestNewStrategy(t *testing.T) {
+	_, err := pool.NewStrategy("unknown")
+	assert.Error(t, err)
+}
@@ -5,26 +5,79 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"pdn/signal/limiter"
+	"time"
 )
 
 const (
 	// DefaultPort is the default port number for the server.
 	DefaultPort = 7070
+
+	// DefaultPingInterval is the default interval of websocket pings.
+	DefaultPingInterval = 10 * time.Second
+
+	// DefaultIdleTimeout is the default duration that an idle connection is closed after.
+	DefaultIdleTimeout = 30 * time.Second
+
+	// DefaultResumeGracePeriod is the default duration that a client can resume its session in.
+	DefaultResumeGracePeriod = 10 * time.Second
+
+	// DefaultMaxMessageSize is the default max size in bytes of a message from the client.
+	DefaultMaxMessageSize = 64 << 10
+
+	// DefaultWriteTimeout is the default duration that a message to the client must be written in.
+	DefaultWriteTimeout = 10 * time.Second
+
+	// DefaultMaxViolations is the default number of rate limit violations that a client is disconnected after.
+	DefaultMaxViolations = 20
+)
+
+// Below are the default rate limits of the server.
+var (
+	DefaultConnectionLimit  = limiter.Limit{Rate: 5, Burst: 20}
+	DefaultAuthFailureLimit = limiter.Limit{Rate: 0.2, Burst: 10}
+	DefaultRequestLimit     = limiter.Limit{Rate: 20, Burst: 50}
+	DefaultJoinLimit        = limiter.Limit{Rate: 20, Burst: 100}
 )
 
 // Below is the Error message for the server.
 var (
-	ErrInvalidPort     = errors.New("invalid port")
-	ErrInvalidCertFile = errors.New("invalid cert file")
-	ErrInvalidKeyFile  = errors.New("invalid key file")
+	ErrInvalidPort      = errors.New("invalid port")
+	ErrInvalidCertFile  = errors.New("invalid cert file")
+	ErrInvalidKeyFile   = errors.New("invalid key file")
+	ErrInvalidHeartbeat = errors.New("invalid heartbeat")
+	ErrInvalidGrace     = errors.New("invalid resume grace period")
+	ErrInvalidViolation = errors.New("invalid max violations")
+	ErrInvalidHardening = errors.New("invalid connection hardening")
 )
 
-// Config is the configuration for creating a Server instance.
+// Config is the configuration for creating a Server instance. The connection
+// hardening, i.e. the allowed origins, the max message size and the write
+// timeout, is off in debug mode.
 type Config struct {
 	Port     int
 	Debug    bool
 	CertFile string
 	KeyFile  string
+
+	PingInterval time.Duration
+	IdleTimeout  time.Duration
+
+	ResumeGracePeriod time.Duration
+
+	ConnectionLimit  limiter.Limit
+	AuthFailureLimit limiter.Limit
+	RequestLimit     limiter.Limit
+	JoinLimit        limiter.Limit
+	MaxViolations    int
+
+	AllowedOrigins    []string
+	MaxMessageSize    int64
+	WriteTimeout      time.Duration
+	EnableCompression bool
+	Subprotocols      []string
+
+	MoveURL string
 }
 
 // IsSame checks if the given config is the same as the current one.
@@ -38,6 +91,30 @@ func (c Config) Validate() error {
 		return fmt.Errorf("must be between 1 and 65535, given %d: %w", c.Port, ErrInvalidPort)
 	}
 
+	if c.PingInterval <= 0 || c.IdleTimeout <= c.PingInterval {
+		return fmt.Errorf("idle timeout %s must be longer than ping interval %s: %w",
+			c.IdleTimeout, c.PingInterval, ErrInvalidHeartbeat)
+	}
+
+	if c.ResumeGracePeriod < 0 {
+		return fmt.Errorf("must not be negative, given %s: %w", c.ResumeGracePeriod, ErrInvalidGrace)
+	}
+
+	for _, limit := range []limiter.Limit{c.ConnectionLimit, c.AuthFailureLimit, c.RequestLimit, c.JoinLimit} {
+		if err := limit.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.MaxViolations < 0 {
+		return fmt.Errorf("must not be negative, given %d: %w", c.MaxViolations, ErrInvalidViolation)
+	}
+
+	if c.MaxMessageSize < 0 || c.WriteTimeout < 0 {
+		return fmt.Errorf("max message size %d and write timeout %s must not be negative: %w",
+			c.MaxMessageSize, c.WriteTimeout, ErrInvalidHardening)
+	}
+
 	if c.CertFile == "" && c.KeyFile == "" {
 		return nil
 	}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"pdn/signal/limiter"
+	"time"
+)
+
+// Config contains the configuration for the controller.
+type Config struct {
+	// PingInterval is the interval of websocket pings sent to the client.
+	PingInterval time.Duration
+
+	// IdleTimeout is the duration that the connection is closed after if
+	// nothing, including pongs, is received from the client.
+	IdleTimeout time.Duration
+
+	// WriteTimeout is the duration that a message to the client must be
+	// written in. Zero means no deadline.
+	WriteTimeout time.Duration
+
+	// ResumeGracePeriod is the duration that a client is kept active after
+	// its websocket is closed, so that it can resume the session. Zero
+	// deactivates the client at once.
+	ResumeGracePeriod time.Duration
+
+	// MoveURL is the URL that the clients are told to move to when the
+	// server shuts down. Empty tells them to reconnect to the same address.
+	MoveURL string
+
+	// ConnectionLimit limits the connection attempts per IP.
+	ConnectionLimit limiter.Limit
+
+	// AuthFailureLimit limits the failed authentications per IP. An IP out of
+	// it can't connect until the limit is refilled.
+	AuthFailureLimit limiter.Limit
+
+	// RequestLimit limits the requests per client and request type.
+	RequestLimit limiter.Limit
+
+	// JoinLimit limits the activations per channel.
+	JoinLimit limiter.Limit
+
+	// MaxViolations is the number of requests rejected by the rate limit that
+	// the client is disconnected after. Zero never disconnects.
+	MaxViolations int
+}
@@ -4,35 +4,57 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
+	"pdn/auth"
 	"pdn/broker"
+	"pdn/broker/subscription"
 	"pdn/database"
 	"pdn/metric"
 	"pdn/types/client/request"
 	"pdn/types/client/response"
 	"pdn/types/message"
+	"sync"
+	"time"
 )
 
 // Controller handles HTTP requests.
 type Controller struct {
+	config   Config
 	broker   *broker.Broker
 	database database.Database
 	metric   *metric.Metrics
+	verifier *auth.Verifier
+	limiters limiters
+
+	mu        sync.Mutex
+	sessions  map[string]*session
+	draining  bool
+	processes sync.WaitGroup
 }
 
 // New creates a new instance of Controller.
-func New(b *broker.Broker, db database.Database, m *metric.Metrics) *Controller {
+func New(c Config, b *broker.Broker, db database.Database, m *metric.Metrics, v *auth.Verifier) *Controller {
 	return &Controller{
+		config:   c,
 		broker:   b,
 		database: db,
 		metric:   m,
+		verifier: v,
+		limiters: newLimiters(c),
+		sessions: make(map[string]*session),
 	}
 }
 
 // Process handles HTTP requests.
 func (c *Controller) Process(conn *websocket.Conn) error {
+	if err := c.enter(); err != nil {
+		return err
+	}
+	defer c.processes.Done()
+
 	c.metric.IncrementWebSocketConnections()
 	defer c.metric.DecrementWebSocketConnections()
 
@@ -40,118 +62,286 @@ func (c *Controller) Process(conn *websocket.Conn) error {
 
 	// 01. Build the context for control response goroutine
 	ctx, cancel := context.WithCancel(context.Background())
-	defer func() {
-		cancel()
-	}()
+	defer cancel()
+
+	// 02. Close the connection if the client is idle. Every pong or request
+	// from the client extends the deadline.
+	if err := c.extendDeadline(conn); err != nil {
+		return fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	conn.SetPongHandler(func(string) error {
+		return c.extendDeadline(conn)
+	})
 
-	// 02. Authenticate the connection
-	channelID, userID, err := c.authenticate(conn)
+	// 03. Authenticate the connection
+	activation, sess, resumed, err := c.authenticate(conn)
 	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			c.failed(conn.RemoteAddr().String())
+		}
 		c.metric.IncrementClientConnectionFailures()
 		return fmt.Errorf("failed to authenticate: %w", err)
 	}
+	channelID, userID := activation.ChannelID, activation.ClientID
 
-	if err := c.broker.Publish(broker.Client, broker.ACTIVATE, message.Activate{
-		ChannelID: channelID,
-		ClientID:  userID,
-	}); err != nil {
-		c.metric.IncrementClientConnectionFailures()
-		return fmt.Errorf("failed to publish connected message: %w", err)
-	}
-	defer func() {
-		if err := c.broker.Publish(broker.Client, broker.DEACTIVATE, message.Deactivate{
-			ChannelID: channelID,
-			ClientID:  userID,
+	// 04. Activate the client, unless it resumed the session and is still
+	// active in its connections.
+	if !resumed {
+		if err := c.broker.Publish(broker.Client, broker.ACTIVATE, message.Activate{
+			ChannelID:    channelID,
+			ClientID:     userID,
+			Capabilities: sess.grant.limit(toCapabilities(activation.Capabilities)),
 		}); err != nil {
-			log.Printf("failed to publish left message: %v", err)
+			c.metric.IncrementClientConnectionFailures()
+			c.discard(sess)
+			return fmt.Errorf("failed to publish connected message: %w", err)
 		}
-	}()
+	}
 
 	c.metric.IncrementClientConnectionSuccesses()
 
-	go c.sendResponse(ctx, conn, channelID, userID)
+	// 05. Replay the messages queued while the client was away, and then
+	// send the others as they come. The session is released after the
+	// response goroutine stops.
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+		c.release(sess)
+	}()
+	if err := c.replay(conn, sess); err != nil {
+		close(done)
+		return fmt.Errorf("failed to replay queued messages: %w", err)
+	}
+	go func() {
+		defer close(done)
+		c.sendResponse(ctx, conn, sess.sub)
+	}()
 
-	if err := c.receiveRequest(conn, channelID, userID); err != nil {
+	if err := c.receiveRequest(conn, sess); err != nil {
 		return fmt.Errorf("failed to receive request: %w", err)
 	}
 	return nil
 }
 
-// authenticate authenticates the connection.
-func (c *Controller) authenticate(conn *websocket.Conn) (string, string, error) {
+// authenticate authenticates the connection and returns the activation payload
+// and the session of the client. The session is resumed if the client gives
+// the resume token of the session left in its grace period. If the activation
+// request is rejected, the client is told why by an error response.
+func (c *Controller) authenticate(conn *websocket.Conn) (request.Activate, *session, bool, error) {
 	// 01. Parse the request from the client
 	var req request.Common
 	if err := conn.ReadJSON(&req); err != nil {
-		return "", "", fmt.Errorf("failed to read authentication message: %w", err)
+		return request.Activate{}, nil, false, fmt.Errorf("failed to read authentication message: %w", err)
+	}
+	payload, policy, granted, err := c.activate(req)
+	var version int
+	var enabled features
+	if err == nil {
+		version, enabled, err = negotiate(payload)
+	}
+	if err == nil {
+		err = c.join(payload.ChannelID)
 	}
+	if err != nil {
+		if err := c.write(conn, toError(req, err)); err != nil {
+			log.Printf("error occurs in sending error response %v", err)
+		}
+		return request.Activate{}, nil, false, err
+	}
+
+	// 02. Resume or open the session
+	var sess *session
+	resumed := false
+	if enabled[request.FeatureResume] && payload.ResumeToken != "" {
+		sess, resumed = c.resume(conn, payload.ChannelID, payload.ClientID, payload.ResumeToken, granted)
+	}
+	if !resumed {
+		if sess, err = c.open(conn, payload.ChannelID, payload.ClientID, enabled, granted, policy); err != nil {
+			if err := c.write(conn, toError(req, err)); err != nil {
+				log.Printf("error occurs in sending error response %v", err)
+			}
+			return request.Activate{}, nil, false, fmt.Errorf("failed to open session: %w", err)
+		}
+	}
+	payload.ClientID = sess.clientID
+
+	res := response.Activate{
+		Type:     response.ACTIVATE,
+		Message:  "FetchFromPeer established",
+		ClientID: sess.clientID,
+		Version:  version,
+		Features: enabled.list(),
+		Resumed:  resumed,
+	}
+	if enabled[request.FeatureResume] {
+		res.ResumeToken = c.token(sess)
+	}
+
+	if err := c.write(conn, res); err != nil {
+		if resumed {
+			c.release(sess)
+		} else {
+			c.discard(sess)
+		}
+		return request.Activate{}, nil, false, fmt.Errorf("failed to send activation response: %w", err)
+	}
+
+	return payload, sess, resumed, nil
+}
+
+// activate checks the activation request against the channel, and returns the
+// policy of the channel for duplicate client IDs and what the credential of
+// the client grants. The client gives either a signed token or the channel key.
+func (c *Controller) activate(req request.Common) (request.Activate, string, grant, error) {
 	if req.Type != request.ACTIVATE {
-		return "", "", fmt.Errorf("expected type '%s', got '%s'", request.ACTIVATE, req.Type)
+		return request.Activate{}, "", grant{}, fmt.Errorf("%w type: expected '%s', got '%s'", ErrInvalidRequest, request.ACTIVATE, req.Type)
 	}
 	var payload request.Activate
 	if err := json.Unmarshal(req.Payload, &payload); err != nil {
-		return "", "", fmt.Errorf("failed to unmarshal activation payload: %w", err)
+		return request.Activate{}, "", grant{}, fmt.Errorf("failed to unmarshal activation payload: %w: %w", ErrInvalidPayload, err)
 	}
 
-	// 02. Authenticate the channel
+	// NOTE: An unknown channel is rejected like a wrong key, so that clients
+	// can't tell which channels are provisioned.
 	channelInfo, err := c.database.FindOrCreateChannelInfoByID(payload.ChannelID)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to find channel info: %w", err)
+	if errors.Is(err, database.ErrChannelNotFound) {
+		return request.Activate{}, "", grant{}, fmt.Errorf("%w channel key", ErrUnauthorized)
 	}
-	if !channelInfo.Authenticate(payload.ChannelKey) {
-		return "", "", fmt.Errorf("invalid key: %s", payload.ChannelKey)
+	if err != nil {
+		return request.Activate{}, "", grant{}, fmt.Errorf("failed to find channel info: %w", err)
 	}
+	policy := channelInfo.Policy.DuplicateClient
 
-	res := response.Activate{
-		Type:    response.ACTIVATE,
-		Message: "FetchFromPeer established",
+	if payload.Token != "" {
+		claims, err := c.verifier.Verify(payload.Token, time.Now())
+		if err != nil {
+			return request.Activate{}, "", grant{}, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+		}
+		if claims.Channel != payload.ChannelID {
+			return request.Activate{}, "", grant{}, fmt.Errorf("%w token of channel %s", ErrUnauthorized, claims.Channel)
+		}
+		if claims.Client != "" {
+			if payload.ClientID != "" && payload.ClientID != claims.Client {
+				return request.Activate{}, "", grant{}, fmt.Errorf("%w token of client %s", ErrUnauthorized, claims.Client)
+			}
+			payload.ClientID = claims.Client
+		}
+		return payload, policy, grant{role: claims.Role, limits: claims.Limits}, nil
 	}
 
-	if err := conn.WriteJSON(res); err != nil {
-		return "", "", fmt.Errorf("failed to send activation response: %w", err)
+	if c.verifier.Required() {
+		return request.Activate{}, "", grant{}, fmt.Errorf("%w: token required", ErrUnauthorized)
 	}
-
-	return payload.ChannelID, payload.ClientID, nil
+	if !channelInfo.Authenticate(payload.ChannelKey) {
+		return request.Activate{}, "", grant{}, fmt.Errorf("%w channel key", ErrUnauthorized)
+	}
+	return payload, policy, grant{role: c.verifier.KeyRole()}, nil
 }
 
 // sendResponse sends response to the client.
-func (c *Controller) sendResponse(ctx context.Context, conn *websocket.Conn, channelID, userID string) {
-	detail := broker.Detail(channelID + userID)
-	sub := c.broker.Subscribe(broker.ClientSocket, detail)
-	defer func() {
-		if err := c.broker.Unsubscribe(broker.ClientSocket, detail, sub); err != nil {
-			log.Printf("Error occurs in unsubscribe: %v", err)
-		}
-	}()
+func (c *Controller) sendResponse(ctx context.Context, conn *websocket.Conn, sub *subscription.Subscription) {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case msg := <-sub.Receive():
-			if err := conn.WriteJSON(msg); err != nil {
+		case <-ticker.C:
+			deadline := time.Now().Add(c.config.PingInterval)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				log.Printf("Failed to send ping: %v", err)
+				return
+			}
+		case msg, ok := <-sub.Receive():
+			if !ok {
+				return
+			}
+			if err := c.write(conn, msg); err != nil {
 				log.Printf("Failed to send response: %v", err)
 				return
 			}
+			if _, ok := msg.(response.Move); ok {
+				c.kick(conn, response.CloseMoved, moveReason)
+				return
+			}
 		}
 	}
 }
 
+// write writes the message to the client within the write timeout.
+func (c *Controller) write(conn *websocket.Conn, msg any) error {
+	if c.config.WriteTimeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout)); err != nil {
+			return fmt.Errorf("failed to set write deadline: %w", err)
+		}
+	}
+	return conn.WriteJSON(msg)
+}
+
+// extendDeadline extends the read deadline of the connection by the idle timeout.
+func (c *Controller) extendDeadline(conn *websocket.Conn) error {
+	return conn.SetReadDeadline(time.Now().Add(c.config.IdleTimeout))
+}
+
 // receiveRequest receives request from the websocket and call handleRequest.
-func (c *Controller) receiveRequest(conn *websocket.Conn, channelID, userID string) error {
+// A failed request is answered by an error response, and a succeeded one by an
+// ACK response if the client asked for it, when the features are enabled. The
+// client exceeding the rate limits repeatedly is disconnected.
+func (c *Controller) receiveRequest(conn *websocket.Conn, s *session) error {
+	channelID, userID, enabled := s.channelID, s.clientID, s.features
+	violations := 0
 	for {
 		var req request.Common
 		if err := conn.ReadJSON(&req); err != nil {
 			return fmt.Errorf("failed to parse common message: %v", err)
 		}
-		if err := c.handleRequest(req, channelID, userID); err != nil {
+		if err := c.extendDeadline(conn); err != nil {
+			return fmt.Errorf("failed to set read deadline: %v", err)
+		}
+		err := c.allow(s, req.Type)
+		if err == nil {
+			err = c.handleRequest(req, s)
+		}
+		if err != nil {
 			log.Printf("Error handling request: %v", err)
+			if errors.Is(err, ErrRateLimited) {
+				violations++
+			}
+			if c.config.MaxViolations > 0 && violations >= c.config.MaxViolations {
+				// NOTE: The close frame tells the client why, instead of the
+				// error response that may not be sent before it.
+				c.metric.IncrementRateLimitDisconnects()
+				c.drop(s, response.CloseRateLimited, "rate limit exceeded")
+				return fmt.Errorf("disconnected after %d rate limit violations", violations)
+			}
+			if enabled[request.FeatureError] {
+				c.reply(channelID, userID, toError(req, err))
+			}
 			continue
 		}
+		if req.Ack && enabled[request.FeatureAck] {
+			c.reply(channelID, userID, response.Ack{
+				Type:      response.ACK,
+				RequestID: req.RequestID,
+			})
+		}
 	}
 }
 
-// handleRequest parse the request type and call the corresponding handler function
-func (c *Controller) handleRequest(req request.Common, channelID, userID string) error {
+// handleRequest parse the request type and call the corresponding handler function.
+// A request whose feature is not enabled for the client, or that the role of
+// the client doesn't allow, is rejected.
+func (c *Controller) handleRequest(req request.Common, s *session) error {
+	if feature, ok := requiredFeatures[req.Type]; ok && !s.features[feature] {
+		return fmt.Errorf("%w: %s requires %s", ErrFeatureDisabled, req.Type, feature)
+	}
+	if err := s.grant.authorize(req.Type); err != nil {
+		return err
+	}
+	channelID, userID := s.channelID, s.clientID
+
 	var err error
 	switch req.Type {
 	case request.PUSH:
@@ -168,8 +358,14 @@ func (c *Controller) handleRequest(req request.Common, channelID, userID string)
 		err = c.handleDisconnected(req, channelID, userID)
 	case request.FAILED:
 		err = c.handleFailed(req, channelID, userID)
+	case request.UPDATE:
+		err = c.handleUpdate(req, channelID, userID, s.grant)
+	case request.HEALTH:
+		err = c.handleHealth(req, channelID, userID)
+	case request.KICK:
+		err = c.handleKick(req, channelID)
 	default:
-		err = fmt.Errorf("invalid request type: %s", req.Type)
+		err = fmt.Errorf("%w type: %s", ErrInvalidRequest, req.Type)
 	}
 	return err
 }
@@ -178,7 +374,7 @@ func (c *Controller) handleRequest(req request.Common, channelID, userID string)
 func (c *Controller) handlePush(req request.Common, channelID, userID string) error {
 	var payload request.Push
 	if err := json.Unmarshal(req.Payload, &payload); err != nil {
-		return fmt.Errorf("failed to unmarshal push payload: %w", err)
+		return fmt.Errorf("failed to unmarshal push payload: %w: %w", ErrInvalidPayload, err)
 	}
 
 	msg := message.Push{
@@ -197,7 +393,7 @@ func (c *Controller) handlePush(req request.Common, channelID, userID string) er
 func (c *Controller) handlePull(req request.Common, channelID, userID string) error {
 	var payload request.Pull
 	if err := json.Unmarshal(req.Payload, &payload); err != nil {
-		return fmt.Errorf("failed to unmarshal pull payload: %w", err)
+		return fmt.Errorf("failed to unmarshal pull payload: %w: %w", ErrInvalidPayload, err)
 	}
 
 	msg := message.Pull{
@@ -217,14 +413,18 @@ func (c *Controller) handlePull(req request.Common, channelID, userID string) er
 func (c *Controller) handleSignal(req request.Common, channelID, userID string) error {
 	var payload request.Signal
 	if err := json.Unmarshal(req.Payload, &payload); err != nil {
-		return fmt.Errorf("failed to unmarshal signal payload: %w", err)
+		return fmt.Errorf("failed to unmarshal signal payload: %w: %w", ErrInvalidPayload, err)
 	}
 	connInfo, err := c.database.FindConnectionInfoByID(payload.ConnectionID)
 	if err != nil {
 		return fmt.Errorf("failed to find connection info: %w", err)
 	}
 	if !connInfo.Authorize(channelID, userID) {
-		return fmt.Errorf("unauthorized connection exchange: %s", payload.ConnectionID)
+		return fmt.Errorf("%w connection exchange: %s", ErrUnauthorized, payload.ConnectionID)
+	}
+
+	if err := c.advanceBySignal(connInfo, payload.SignalType); err != nil {
+		return err
 	}
 
 	counterpart := connInfo.GetCounterpart(userID)
@@ -241,18 +441,58 @@ func (c *Controller) handleSignal(req request.Common, channelID, userID string)
 	return nil
 }
 
+// advanceBySignal tells the coordinator that the peer connection moves forward
+// by the type of the signal. The coordinator owns the status and changes it in
+// the order of the events of the channel, so the status is never written here.
+// Candidates after ICE started are not told, since they don't change the
+// status.
+func (c *Controller) advanceBySignal(connInfo *database.ConnectionInfo, signalType string) error {
+	switch signalType {
+	case request.SignalAnswer:
+		if err := c.broker.Publish(broker.Peer, broker.ANSWERED, message.Answered{
+			ConnectionID: connInfo.ID,
+		}); err != nil {
+			return fmt.Errorf("failed to publish answered message: %w", err)
+		}
+	case request.SignalCandidate:
+		if connInfo.Status != database.Offered && connInfo.Status != database.Answered {
+			return nil
+		}
+		if err := c.broker.Publish(broker.Peer, broker.CONNECTING, message.Connecting{
+			ConnectionID: connInfo.ID,
+		}); err != nil {
+			return fmt.Errorf("failed to publish connecting message: %w", err)
+		}
+	}
+	return nil
+}
+
 // handleForward handles the forward event. forward event means that a client requests
 func (c *Controller) handleForward(req request.Common, channelID, userID string) error {
 	var payload request.Forwarding
 	if err := json.Unmarshal(req.Payload, &payload); err != nil {
-		return fmt.Errorf("failed to unmarshal exchange payload: %w", err)
+		return fmt.Errorf("failed to unmarshal exchange payload: %w: %w", ErrInvalidPayload, err)
 	}
 	connInfo, err := c.database.FindConnectionInfoByID(payload.ConnectionID)
 	if err != nil {
 		return fmt.Errorf("failed to find connection info: %w", err)
 	}
 	if !connInfo.Authorize(channelID, userID) {
-		return fmt.Errorf("unauthorized connection exchange: %s", payload.ConnectionID)
+		return fmt.Errorf("%w connection exchange: %s", ErrUnauthorized, payload.ConnectionID)
+	}
+
+	// NOTE: The fetcher offers and the forwarder answers. The status is changed
+	// by the coordinator, like the other events of the connection.
+	var (
+		detail     = broker.OFFERED
+		status any = message.Offered{ConnectionID: connInfo.ID}
+	)
+	if userID == connInfo.From {
+		detail = broker.ANSWERED
+		status = message.Answered{ConnectionID: connInfo.ID}
+	}
+	if err := c.broker.Publish(broker.Peer, detail, status); err != nil {
+		return fmt.Errorf("failed to publish %s message: %w", detail, err)
 	}
 
 	counterpart := connInfo.GetCounterpart(userID)
@@ -272,14 +512,14 @@ func (c *Controller) handleForward(req request.Common, channelID, userID string)
 func (c *Controller) handleForwarded(req request.Common, channelID, userID string) error {
 	var payload request.Forwarded
 	if err := json.Unmarshal(req.Payload, &payload); err != nil {
-		return fmt.Errorf("failed to unmarshal succeed payload: %w", err)
+		return fmt.Errorf("failed to unmarshal succeed payload: %w: %w", ErrInvalidPayload, err)
 	}
 	connInfo, err := c.database.FindConnectionInfoByID(payload.ConnectionID)
 	if err != nil {
 		return fmt.Errorf("failed to find connection info: %w", err)
 	}
 	if !connInfo.Authorize(channelID, userID) {
-		return fmt.Errorf("unauthorized connection exchange: %s", payload.ConnectionID)
+		return fmt.Errorf("%w connection exchange: %s", ErrUnauthorized, payload.ConnectionID)
 	}
 
 	if err := c.broker.Publish(broker.Peer, broker.CONNECTED, message.Connected{
@@ -295,14 +535,14 @@ func (c *Controller) handleForwarded(req request.Common, channelID, userID strin
 func (c *Controller) handleFailed(req request.Common, channelID, userID string) error {
 	var payload request.Failed
 	if err := json.Unmarshal(req.Payload, &payload); err != nil {
-		return fmt.Errorf("failed to unmarshal failed payload: %w", err)
+		return fmt.Errorf("failed to unmarshal failed payload: %w: %w", ErrInvalidPayload, err)
 	}
 	connInfo, err := c.database.FindConnectionInfoByID(payload.ConnectionID)
 	if err != nil {
 		return fmt.Errorf("failed to find connection info: %w", err)
 	}
 	if !connInfo.Authorize(channelID, userID) {
-		return fmt.Errorf("unauthorized connection exchange: %s", payload.ConnectionID)
+		return fmt.Errorf("%w connection exchange: %s", ErrUnauthorized, payload.ConnectionID)
 	}
 
 	if err := c.broker.Publish(broker.Peer, broker.FAILED, message.Failed{
@@ -317,14 +557,14 @@ func (c *Controller) handleFailed(req request.Common, channelID, userID string)
 func (c *Controller) handleDisconnected(req request.Common, channelID, userID string) error {
 	var payload request.Disconnected
 	if err := json.Unmarshal(req.Payload, &payload); err != nil {
-		return fmt.Errorf("failed to unmarshal closed payload: %w", err)
+		return fmt.Errorf("failed to unmarshal closed payload: %w: %w", ErrInvalidPayload, err)
 	}
 	connInfo, err := c.database.FindConnectionInfoByID(payload.ConnectionID)
 	if err != nil {
 		return fmt.Errorf("failed to find connection info: %w", err)
 	}
 	if !connInfo.Authorize(channelID, userID) {
-		return fmt.Errorf("unauthorized connection exchange: %s", payload.ConnectionID)
+		return fmt.Errorf("%w connection exchange: %s", ErrUnauthorized, payload.ConnectionID)
 	}
 
 	if err := c.broker.Publish(broker.Peer, broker.DISCONNECTED, message.Disconnected{
@@ -334,3 +574,86 @@ func (c *Controller) handleDisconnected(req request.Common, channelID, userID st
 	}
 	return nil
 }
+
+// handleUpdate handles the update event. update event means that a client reports
+// its changed delivery capabilities, e.g. after the network has changed. The
+// capabilities are limited by what the client is granted.
+func (c *Controller) handleUpdate(req request.Common, channelID, userID string, granted grant) error {
+	var payload request.Update
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal update payload: %w: %w", ErrInvalidPayload, err)
+	}
+
+	if err := c.broker.Publish(broker.Client, broker.UPDATE, message.Update{
+		ChannelID:    channelID,
+		ClientID:     userID,
+		Capabilities: granted.limit(toCapabilities(payload.Capabilities)),
+	}); err != nil {
+		return fmt.Errorf("failed to publish update message: %w", err)
+	}
+	return nil
+}
+
+// handleHealth handles the health event. health event means that a client reports
+// the stats of its connections periodically.
+func (c *Controller) handleHealth(req request.Common, channelID, userID string) error {
+	var payload request.Health
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal health payload: %w: %w", ErrInvalidPayload, err)
+	}
+
+	now := time.Now()
+	reports := make(map[string]database.Health, len(payload.Connections))
+	for _, report := range payload.Connections {
+		connInfo, err := c.database.FindConnectionInfoByID(report.ConnectionID)
+		if err != nil {
+			return fmt.Errorf("failed to find connection info: %w", err)
+		}
+		if !connInfo.Authorize(channelID, userID) {
+			return fmt.Errorf("%w connection health: %s", ErrUnauthorized, report.ConnectionID)
+		}
+		reports[report.ConnectionID] = database.Health{
+			RTT:        time.Duration(max(report.RTT, 0)) * time.Millisecond,
+			Jitter:     time.Duration(max(report.Jitter, 0)) * time.Millisecond,
+			PacketLoss: min(max(report.PacketLoss, 0), 1),
+			Bitrate:    max(report.Bitrate, 0),
+			ReportedAt: now,
+
+			BytesReceived: max(report.BytesReceived, 0),
+		}
+	}
+
+	if err := c.broker.Publish(broker.Client, broker.HEALTH, message.Health{
+		ChannelID: channelID,
+		ClientID:  userID,
+		Reports:   reports,
+	}); err != nil {
+		return fmt.Errorf("failed to publish health message: %w", err)
+	}
+	return nil
+}
+
+// handleKick handles the kick event. kick event means that a moderator removes
+// another client from the channel.
+func (c *Controller) handleKick(req request.Common, channelID string) error {
+	var payload request.Kick
+	if err := json.Unmarshal(req.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal kick payload: %w: %w", ErrInvalidPayload, err)
+	}
+	if payload.ClientID == "" {
+		return fmt.Errorf("%w: no client to kick", ErrInvalidPayload)
+	}
+	return c.remove(channelID, payload.ClientID)
+}
+
+// toCapabilities converts the capabilities in the request to the database
+// type. Invalid values are treated as unknown.
+func toCapabilities(req request.Capabilities) database.Capabilities {
+	return database.Capabilities{
+		UploadBandwidth:    max(req.UploadBandwidth, 0),
+		RTT:                time.Duration(max(req.RTT, 0)) * time.Millisecond,
+		DeviceClass:        req.DeviceClass,
+		NATType:            req.NATType,
+		ForwardingDisabled: req.ForwardingDisabled,
+	}
+}
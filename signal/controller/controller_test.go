@@ -0,0 +1,459 @@
+package controller_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"pdn/auth"
+	"pdn/broker"
+	"pdn/database"
+	"pdn/database/memory"
+	"pdn/metric"
+	"pdn/signal/controller"
+	"pdn/signal/handler"
+	"pdn/signal/limiter"
+	"pdn/types/client/request"
+	"pdn/types/client/response"
+	"pdn/types/message"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret is the HS256 secret of the tokens in the tests.
+const secret = "secret"
+
+// testServer starts the signal server and returns its URL and the events of
+// the clients activated and deactivated.
+func testServer(t *testing.T, config controller.Config, policy database.Policy) (*broker.Broker, string, <-chan any) {
+	t.Helper()
+
+	_, b, url, events := testController(t, config, policy)
+	return b, url, events
+}
+
+// testController starts the signal server like testServer, and returns its
+// controller too. The channel has a peer connection from the forwarder to the
+// fetcher.
+func testController(t *testing.T, config controller.Config, policy database.Policy) (*controller.Controller, *broker.Broker, string, <-chan any) {
+	t.Helper()
+
+	m := metric.New(metric.Config{})
+	b := broker.New(m)
+	db := memory.New(database.Config{DefaultPolicy: policy})
+	key, err := database.NewChannelKey("key")
+	require.NoError(t, err)
+	_, err = db.CreateChannelInfo("channel", key)
+	require.NoError(t, err)
+	_, err = db.CreatePeerConnectionInfo("channel", "forwarder", "fetcher", "connection")
+	require.NoError(t, err)
+	events := make(chan any, 16)
+	for _, detail := range []broker.Detail{broker.ACTIVATE, broker.DEACTIVATE} {
+		sub := b.Subscribe(broker.Client, detail)
+		go func() {
+			for event := range sub.Receive() {
+				events <- event
+			}
+		}()
+	}
+
+	verifier, err := auth.New(auth.Config{HS256Secret: secret})
+	require.NoError(t, err)
+	c := controller.New(config, b, db, m, verifier)
+	srv := httptest.NewServer(handler.New(c, handler.Config{}))
+	t.Cleanup(srv.Close)
+	return c, b, "ws" + strings.TrimPrefix(srv.URL, "http"), events
+}
+
+// activate connects to the server and activates the client.
+func activate(t *testing.T, url string, payload request.Activate) (*websocket.Conn, response.Activate) {
+	t.Helper()
+
+	conn := dial(t, url, payload)
+	var res response.Activate
+	require.NoError(t, conn.ReadJSON(&res))
+	return conn, res
+}
+
+// dial connects to the server and sends the activation request.
+func dial(t *testing.T, url string, payload request.Activate) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	raw, err := json.Marshal(payload)
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteJSON(request.Common{Type: request.ACTIVATE, Payload: raw}))
+	return conn
+}
+
+// sign signs the claims as an HS256 token.
+func sign(t *testing.T, claims auth.Claims) string {
+	t.Helper()
+
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(map[string]string{"alg": auth.HS256, "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestResume(t *testing.T) {
+	config := controller.Config{
+		PingInterval:      time.Second,
+		IdleTimeout:       5 * time.Second,
+		ResumeGracePeriod: 200 * time.Millisecond,
+	}
+	b, url, events := testServer(t, config, database.Policy{})
+	payload := request.Activate{
+		ChannelID:  "channel",
+		ChannelKey: "key",
+		ClientID:   "client",
+		Version:    controller.Version,
+		Features:   []string{request.FeatureResume, request.FeatureError},
+	}
+
+	// given an activated client
+	conn, res := activate(t, url, payload)
+	require.NotEmpty(t, res.ResumeToken)
+	assert.False(t, res.Resumed)
+	assert.Equal(t, []string{request.FeatureError, request.FeatureResume}, res.Features)
+	<-events
+
+	// when the websocket is closed and a message is sent in the grace period
+	require.NoError(t, conn.Close())
+	time.Sleep(50 * time.Millisecond)
+	closed := response.Closed{Type: response.CLOSED, ConnectionID: "connection"}
+	require.NoError(t, b.Publish(broker.ClientSocket, broker.Detail("channelclient"), closed))
+
+	// then the client resumes the session and receives the message
+	payload.ResumeToken = res.ResumeToken
+	conn, resumed := activate(t, url, payload)
+	assert.True(t, resumed.Resumed)
+	assert.NotEqual(t, res.ResumeToken, resumed.ResumeToken)
+	var replayed response.Closed
+	require.NoError(t, conn.ReadJSON(&replayed))
+	assert.Equal(t, closed, replayed)
+
+	// and the failed request is answered by an error
+	require.NoError(t, conn.WriteJSON(request.Common{Type: "UNKNOWN", RequestID: "1"}))
+	var failed response.Error
+	require.NoError(t, conn.ReadJSON(&failed))
+	assert.Equal(t, response.Error{
+		Type:      response.ERROR,
+		RequestID: "1",
+		Code:      response.CodeInvalidRequest,
+		Message:   "invalid request type: UNKNOWN",
+	}, failed)
+
+	// and the client is deactivated only after the grace period
+	require.NoError(t, conn.Close())
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("client is not deactivated")
+	}
+
+	// and the expired token is not accepted
+	_, res = activate(t, url, payload)
+	assert.False(t, res.Resumed)
+	<-events
+}
+
+func TestDuplicateClient(t *testing.T) {
+	config := controller.Config{PingInterval: time.Second, IdleTimeout: 5 * time.Second}
+	payload := request.Activate{ChannelID: "channel", ChannelKey: "key", ClientID: "client"}
+
+	t.Run("given reject policy when same client activates then reject newcomer", func(t *testing.T) {
+		_, url, events := testServer(t, config, database.Policy{DuplicateClient: database.DuplicateReject})
+		_, res := activate(t, url, payload)
+		assert.Equal(t, "client", res.ClientID)
+		<-events
+
+		conn := dial(t, url, payload)
+		var rejected response.Error
+		require.NoError(t, conn.ReadJSON(&rejected))
+		assert.Equal(t, response.CodeClientExists, rejected.Code)
+	})
+
+	t.Run("given kick policy when same client activates then close old session", func(t *testing.T) {
+		_, url, events := testServer(t, config, database.Policy{DuplicateClient: database.DuplicateKick})
+		old, _ := activate(t, url, payload)
+		<-events
+
+		_, res := activate(t, url, payload)
+		assert.Equal(t, "client", res.ClientID)
+		_, _, err := old.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, response.CloseReplaced))
+		assert.IsType(t, message.Deactivate{}, <-events)
+		assert.IsType(t, message.Activate{}, <-events)
+	})
+
+	t.Run("given unique policy when same client activates then assign unique id", func(t *testing.T) {
+		_, url, events := testServer(t, config, database.Policy{DuplicateClient: database.DuplicateUnique})
+		activate(t, url, payload)
+		<-events
+
+		_, res := activate(t, url, payload)
+		assert.True(t, strings.HasPrefix(res.ClientID, "client-"))
+		activated := (<-events).(message.Activate)
+		assert.Equal(t, res.ClientID, activated.ClientID)
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	config := controller.Config{PingInterval: time.Second, IdleTimeout: 5 * time.Second}
+	tests := []struct {
+		name     string
+		payload  request.Activate
+		wantCode string
+	}{
+		{
+			name:    "given provisioned channel and its key when activate then succeed",
+			payload: request.Activate{ChannelID: "channel", ChannelKey: "key", ClientID: "client"},
+		},
+		{
+			name:     "given wrong key when activate then reject",
+			payload:  request.Activate{ChannelID: "channel", ChannelKey: "channel", ClientID: "client"},
+			wantCode: response.CodeUnauthorized,
+		},
+		{
+			name:     "given unknown channel when activate then reject like wrong key",
+			payload:  request.Activate{ChannelID: "unknown", ChannelKey: "unknown", ClientID: "client"},
+			wantCode: response.CodeUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, url, _ := testServer(t, config, database.Policy{})
+			conn := dial(t, url, tt.payload)
+			var res response.Error
+			require.NoError(t, conn.ReadJSON(&res))
+			if tt.wantCode == "" {
+				assert.Equal(t, response.ACTIVATE, res.Type)
+				return
+			}
+			assert.Equal(t, response.ERROR, res.Type)
+			assert.Equal(t, tt.wantCode, res.Code)
+		})
+	}
+}
+
+func TestRoles(t *testing.T) {
+	config := controller.Config{PingInterval: time.Second, IdleTimeout: 5 * time.Second}
+	_, url, events := testServer(t, config, database.Policy{})
+	token := func(client, role string) string {
+		return sign(t, auth.Claims{
+			Channel:   "channel",
+			Client:    client,
+			Role:      role,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		})
+	}
+	features := []string{request.FeatureError}
+
+	// given a viewer and a moderator activated with tokens
+	viewer, res := activate(t, url, request.Activate{
+		ChannelID: "channel", Token: token("viewer", auth.RoleViewer), Version: controller.Version, Features: features,
+	})
+	require.Equal(t, response.ACTIVATE, res.Type)
+	assert.Equal(t, "viewer", res.ClientID)
+	<-events
+	moderator, _ := activate(t, url, request.Activate{
+		ChannelID: "channel", Token: token("moderator", auth.RoleModerator), Version: controller.Version, Features: features,
+	})
+	<-events
+
+	// when the viewer pushes, then it is forbidden
+	require.NoError(t, viewer.WriteJSON(request.Common{Type: request.PUSH, RequestID: "1", Payload: json.RawMessage(`{}`)}))
+	var forbidden response.Error
+	require.NoError(t, viewer.ReadJSON(&forbidden))
+	assert.Equal(t, response.CodeForbidden, forbidden.Code)
+
+	// when a client activated with the channel key pushes, then it is forbidden
+	keyClient, _ := activate(t, url, request.Activate{
+		ChannelID: "channel", ChannelKey: "key", ClientID: "key", Version: controller.Version, Features: features,
+	})
+	<-events
+	require.NoError(t, keyClient.WriteJSON(request.Common{Type: request.PUSH, RequestID: "1", Payload: json.RawMessage(`{}`)}))
+	require.NoError(t, keyClient.ReadJSON(&forbidden))
+	assert.Equal(t, response.CodeForbidden, forbidden.Code)
+
+	// when the moderator kicks the viewer, then the viewer is closed and deactivated
+	require.NoError(t, moderator.WriteJSON(request.Common{Type: request.KICK, Payload: json.RawMessage(`{"client_id":"viewer"}`)}))
+	_, _, err := viewer.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, response.CloseKicked))
+	deactivated := (<-events).(message.Deactivate)
+	assert.Equal(t, "viewer", deactivated.ClientID)
+
+	// and a token of another client or channel is rejected
+	for _, payload := range []request.Activate{
+		{ChannelID: "channel", ClientID: "other", Token: token("viewer", auth.RoleViewer)},
+		{ChannelID: "unknown", Token: token("viewer", auth.RoleViewer)},
+	} {
+		conn := dial(t, url, payload)
+		var rejected response.Error
+		require.NoError(t, conn.ReadJSON(&rejected))
+		assert.Equal(t, response.CodeUnauthorized, rejected.Code)
+	}
+}
+
+func TestForward(t *testing.T) {
+	config := controller.Config{PingInterval: time.Second, IdleTimeout: 5 * time.Second}
+	b, url, events := testServer(t, config, database.Policy{})
+	offered := b.Subscribe(broker.Peer, broker.OFFERED)
+	answered := b.Subscribe(broker.Peer, broker.ANSWERED)
+	payload := func(client string) request.Activate {
+		return request.Activate{ChannelID: "channel", ChannelKey: "key", ClientID: client, Version: controller.Version}
+	}
+	forwarding := request.Common{Type: request.FORWARDING, Payload: json.RawMessage(`{"connection_id":"connection","sdp":"sdp"}`)}
+
+	// given the forwarder and the fetcher of a peer connection
+	forwarder, _ := activate(t, url, payload("forwarder"))
+	<-events
+	fetcher, _ := activate(t, url, payload("fetcher"))
+	<-events
+
+	// when the fetcher offers twice and the forwarder answers twice
+	// then every SDP is relayed and the coordinator is told of each of them
+	for range 2 {
+		require.NoError(t, fetcher.WriteJSON(forwarding))
+		var res response.Forwarding
+		require.NoError(t, forwarder.ReadJSON(&res))
+		assert.Equal(t, response.Forwarding{Type: response.FORWARDING, ConnectionID: "connection", SDP: "sdp"}, res)
+		assert.Equal(t, message.Offered{ConnectionID: "connection"}, <-offered.Receive())
+	}
+	for range 2 {
+		require.NoError(t, forwarder.WriteJSON(forwarding))
+		var res response.Forwarding
+		require.NoError(t, fetcher.ReadJSON(&res))
+		assert.Equal(t, "connection", res.ConnectionID)
+		assert.Equal(t, message.Answered{ConnectionID: "connection"}, <-answered.Receive())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	payload := request.Activate{
+		ChannelID:  "channel",
+		ChannelKey: "key",
+		ClientID:   "client",
+		Version:    controller.Version,
+		Features:   []string{request.FeatureError},
+	}
+	once := limiter.Limit{Rate: 0.001, Burst: 1}
+
+	t.Run("given request limit when exceeded repeatedly then disconnect client", func(t *testing.T) {
+		config := controller.Config{
+			PingInterval:  time.Second,
+			IdleTimeout:   5 * time.Second,
+			RequestLimit:  once,
+			MaxViolations: 2,
+		}
+		_, url, events := testServer(t, config, database.Policy{})
+		conn, _ := activate(t, url, payload)
+		<-events
+
+		for _, code := range []string{response.CodeInvalidRequest, response.CodeRateLimited} {
+			require.NoError(t, conn.WriteJSON(request.Common{Type: "UNKNOWN"}))
+			var res response.Error
+			require.NoError(t, conn.ReadJSON(&res))
+			assert.Equal(t, code, res.Code)
+		}
+		require.NoError(t, conn.WriteJSON(request.Common{Type: "UNKNOWN"}))
+		_, _, err := conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, response.CloseRateLimited))
+		assert.IsType(t, message.Deactivate{}, <-events)
+	})
+
+	t.Run("given join limit when exceeded then reject activation", func(t *testing.T) {
+		config := controller.Config{PingInterval: time.Second, IdleTimeout: 5 * time.Second, JoinLimit: once}
+		_, url, _ := testServer(t, config, database.Policy{})
+		activate(t, url, payload)
+
+		other := payload
+		other.ClientID = "other"
+		conn := dial(t, url, other)
+		var res response.Error
+		require.NoError(t, conn.ReadJSON(&res))
+		assert.Equal(t, response.CodeRateLimited, res.Code)
+	})
+
+	t.Run("given failed authentications when exceeded then reject connection", func(t *testing.T) {
+		config := controller.Config{PingInterval: time.Second, IdleTimeout: 5 * time.Second, AuthFailureLimit: once}
+		_, url, _ := testServer(t, config, database.Policy{})
+		wrong := payload
+		wrong.ChannelKey = "wrong"
+		conn := dial(t, url, wrong)
+		var res response.Error
+		require.NoError(t, conn.ReadJSON(&res))
+		assert.Equal(t, response.CodeUnauthorized, res.Code)
+
+		_, _, err := conn.ReadMessage()
+		require.Error(t, err)
+		_, rejected, err := websocket.DefaultDialer.Dial(url, nil)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusTooManyRequests, rejected.StatusCode)
+	})
+}
+
+func TestDrain(t *testing.T) {
+	config := controller.Config{
+		PingInterval:      time.Second,
+		IdleTimeout:       5 * time.Second,
+		ResumeGracePeriod: time.Minute,
+		MoveURL:           "wss://other.example",
+	}
+	c, _, url, events := testController(t, config, database.Policy{})
+	payload := request.Activate{
+		ChannelID:  "channel",
+		ChannelKey: "key",
+		ClientID:   "client",
+		Version:    controller.Version,
+		Features:   []string{request.FeatureResume},
+	}
+	conn, _ := activate(t, url, payload)
+	<-events
+
+	// when the server drains, then the client is told to move and closed
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		drained <- c.Drain(ctx)
+	}()
+	var move response.Move
+	require.NoError(t, conn.ReadJSON(&move))
+	assert.Equal(t, response.Move{Type: response.MOVE, Message: "server shutting down", URL: "wss://other.example"}, move)
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, response.CloseMoved))
+
+	// and deactivated at once, since it can't resume on this server
+	assert.IsType(t, message.Deactivate{}, <-events)
+	require.NoError(t, <-drained)
+
+	// and new connections are rejected
+	_, res, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"pdn/types/client/response"
+)
+
+// moveReason is the reason of the close frame sent after the MOVE response.
+const moveReason = "server shutting down"
+
+// enter registers the connection to be waited on drain. It fails if the
+// server is draining.
+func (c *Controller) enter() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.draining {
+		return ErrShuttingDown
+	}
+	c.processes.Add(1)
+	return nil
+}
+
+// isDraining checks if the server is draining.
+func (c *Controller) isDraining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining
+}
+
+// Drain stops accepting sessions and tells the clients to move to another
+// server by the MOVE response. It waits for the clients to leave until the
+// context is done, and then closes the remaining sessions. The clients left
+// in their grace period are deactivated at once, since they can't resume.
+func (c *Controller) Drain(ctx context.Context) error {
+	c.mu.Lock()
+	c.draining = true
+	attachedSessions, detachedSessions := c.snapshot()
+	c.mu.Unlock()
+
+	move := response.Move{
+		Type:    response.MOVE,
+		Message: moveReason,
+		URL:     c.config.MoveURL,
+	}
+	for _, s := range attachedSessions {
+		c.reply(s.channelID, s.clientID, move)
+	}
+	for _, s := range detachedSessions {
+		c.drop(s, response.CloseMoved, moveReason)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.processes.Wait()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	attachedSessions, detachedSessions = c.snapshot()
+	c.mu.Unlock()
+	for _, s := range append(attachedSessions, detachedSessions...) {
+		c.drop(s, response.CloseMoved, moveReason)
+	}
+	return fmt.Errorf("failed to drain sessions: %w", ctx.Err())
+}
+
+// snapshot returns the attached and the detached sessions. The caller must
+// hold the mutex.
+func (c *Controller) snapshot() ([]*session, []*session) {
+	var attachedSessions, detachedSessions []*session
+	for _, s := range c.sessions {
+		switch s.state {
+		case attached:
+			attachedSessions = append(attachedSessions, s)
+		case detached:
+			detachedSessions = append(detachedSessions, s)
+		}
+	}
+	return attachedSessions, detachedSessions
+}
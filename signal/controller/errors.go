@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"pdn/broker"
+	"pdn/database"
+	"pdn/types/client/request"
+	"pdn/types/client/response"
+)
+
+var (
+	// ErrInvalidRequest is returned when the request type is not expected.
+	ErrInvalidRequest = errors.New("invalid request")
+
+	// ErrInvalidPayload is returned when the payload of the request can't be parsed.
+	ErrInvalidPayload = errors.New("invalid payload")
+
+	// ErrUnauthorized is returned when the client is not allowed to make the request.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrForbidden is returned when the role of the client doesn't allow the request.
+	ErrForbidden = errors.New("forbidden")
+
+	// ErrRateLimited is returned when the request exceeds the rate limit.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrShuttingDown is returned when the server is shutting down.
+	ErrShuttingDown = errors.New("server shutting down")
+
+	// ErrUnsupportedVersion is returned when the protocol version of the client is not supported.
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+	// ErrFeatureDisabled is returned when the request needs a feature not enabled for the client.
+	ErrFeatureDisabled = errors.New("feature disabled")
+)
+
+// codeOf returns the error code of the error sent to the client.
+func codeOf(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		return response.CodeInvalidRequest
+	case errors.Is(err, ErrInvalidPayload):
+		return response.CodeInvalidPayload
+	case errors.Is(err, ErrUnauthorized):
+		return response.CodeUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return response.CodeForbidden
+	case errors.Is(err, ErrRateLimited):
+		return response.CodeRateLimited
+	case errors.Is(err, ErrUnsupportedVersion):
+		return response.CodeUnsupportedVersion
+	case errors.Is(err, ErrFeatureDisabled):
+		return response.CodeFeatureDisabled
+	case errors.Is(err, database.ErrChannelNotFound):
+		return response.CodeChannelNotFound
+	case errors.Is(err, database.ErrClientAlreadyExists):
+		return response.CodeClientExists
+	case errors.Is(err, database.ErrClientNotFound):
+		return response.CodeClientNotFound
+	case errors.Is(err, database.ErrConnectionNotFound):
+		return response.CodeConnectionNotFound
+	case errors.Is(err, database.ErrConnectionAlreadyExists), errors.Is(err, database.ErrPushConnectionExists):
+		return response.CodeConnectionExists
+	case errors.Is(err, database.ErrInvalidTransition):
+		return response.CodeInvalidTransition
+	default:
+		return response.CodeInternal
+	}
+}
+
+// toError converts the error of the request to the response. The details of
+// internal errors are not sent to the client.
+func toError(req request.Common, err error) response.Error {
+	code := codeOf(err)
+	msg := err.Error()
+	if code == response.CodeInternal {
+		msg = "internal server error"
+	}
+	return response.Error{
+		Type:      response.ERROR,
+		RequestID: req.RequestID,
+		Code:      code,
+		Message:   msg,
+	}
+}
+
+// reply sends the response to the client through its socket.
+func (c *Controller) reply(channelID, userID string, res any) {
+	if err := c.broker.Publish(broker.ClientSocket, broker.Detail(channelID+userID), res); err != nil {
+		log.Printf("error occurs in publishing reply %v", err)
+	}
+}
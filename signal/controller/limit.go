@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"pdn/signal/limiter"
+	"time"
+)
+
+// Scopes of the rate limits, labeling the rejections in the metrics.
+const (
+	scopeConnection = "connection"
+	scopeAuth       = "auth"
+	scopeRequest    = "request"
+	scopeJoin       = "join"
+)
+
+// limiters are the rate limits of the signal server.
+type limiters struct {
+	connections *limiter.Limiter
+	failures    *limiter.Limiter
+	requests    *limiter.Limiter
+	joins       *limiter.Limiter
+}
+
+// newLimiters creates the limiters with the limits of the config.
+func newLimiters(c Config) limiters {
+	return limiters{
+		connections: limiter.New(c.ConnectionLimit),
+		failures:    limiter.New(c.AuthFailureLimit),
+		requests:    limiter.New(c.RequestLimit),
+		joins:       limiter.New(c.JoinLimit),
+	}
+}
+
+// Admit checks if a connection from the remote address is allowed by the rate
+// limits of its IP. An IP that failed to authenticate too often is not
+// allowed until the limit is refilled. No connection is allowed while the
+// server is draining.
+func (c *Controller) Admit(addr string) error {
+	if c.isDraining() {
+		return ErrShuttingDown
+	}
+	ip := hostOf(addr)
+	now := time.Now()
+	if !c.limiters.failures.Check(ip, now) {
+		c.metric.IncrementRateLimited(scopeAuth)
+		return fmt.Errorf("%w authentication failures: %s", ErrRateLimited, ip)
+	}
+	if !c.limiters.connections.Allow(ip, now) {
+		c.metric.IncrementRateLimited(scopeConnection)
+		return fmt.Errorf("%w connections: %s", ErrRateLimited, ip)
+	}
+	return nil
+}
+
+// failed records the failed authentication of the remote address.
+func (c *Controller) failed(addr string) {
+	c.limiters.failures.Allow(hostOf(addr), time.Now())
+}
+
+// join checks if an activation in the channel is allowed by its rate limit.
+func (c *Controller) join(channelID string) error {
+	if !c.limiters.joins.Allow(channelID, time.Now()) {
+		c.metric.IncrementRateLimited(scopeJoin)
+		return fmt.Errorf("%w joins: %s", ErrRateLimited, channelID)
+	}
+	return nil
+}
+
+// allow checks if the request type of the client is allowed by its rate limit.
+func (c *Controller) allow(s *session, requestType string) error {
+	if !c.limiters.requests.Allow(s.key()+"/"+requestType, time.Now()) {
+		c.metric.IncrementRateLimited(scopeRequest)
+		return fmt.Errorf("%w requests: %s", ErrRateLimited, requestType)
+	}
+	return nil
+}
+
+// hostOf returns the IP of the remote address, or the address itself if it
+// has no port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
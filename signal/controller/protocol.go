@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"pdn/types/client/request"
+	"slices"
+)
+
+const (
+	// Version is the latest protocol version the server speaks.
+	Version = 2
+
+	// MinVersion is the oldest protocol version the server still speaks.
+	// Version 1 is the protocol before the negotiation, whose features are
+	// fixed.
+	MinVersion = 1
+)
+
+// supportedFeatures are the features the server supports in the latest version.
+var supportedFeatures = []string{
+	request.FeatureTrickleICE,
+	request.FeatureHealth,
+	request.FeatureError,
+	request.FeatureAck,
+	request.FeatureResume,
+}
+
+// legacyFeatures are the features of version 1, enabled without negotiation.
+var legacyFeatures = []string{
+	request.FeatureTrickleICE,
+	request.FeatureHealth,
+}
+
+// requiredFeatures are the features required by request types.
+var requiredFeatures = map[string]string{
+	request.SIGNAL: request.FeatureTrickleICE,
+	request.HEALTH: request.FeatureHealth,
+}
+
+// features is the set of features enabled for a client.
+type features map[string]bool
+
+// list returns the enabled features in the order of the supported features.
+func (f features) list() []string {
+	list := make([]string, 0, len(f))
+	for _, feature := range supportedFeatures {
+		if f[feature] {
+			list = append(list, feature)
+		}
+	}
+	return list
+}
+
+// negotiate picks the protocol version and the features for the client. The
+// version is the latest one both speak, and the features are the ones both
+// support in it. A client without the version is treated as version 1.
+func negotiate(payload request.Activate) (int, features, error) {
+	if payload.Version < 0 {
+		return 0, nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, payload.Version)
+	}
+	version := min(max(payload.Version, MinVersion), Version)
+
+	enabled := make(features)
+	if version == 1 {
+		for _, feature := range legacyFeatures {
+			enabled[feature] = true
+		}
+		return version, enabled, nil
+	}
+	for _, feature := range payload.Features {
+		if slices.Contains(supportedFeatures, feature) {
+			enabled[feature] = true
+		}
+	}
+	return version, enabled, nil
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"fmt"
+	"pdn/auth"
+	"pdn/database"
+	"pdn/types/client/request"
+	"slices"
+)
+
+// allRoles are every role a client can have in a channel.
+var allRoles = []string{auth.RolePublisher, auth.RoleViewer, auth.RoleModerator}
+
+// allowedRoles are the roles allowed to send each request type. A request
+// type not listed is allowed to nobody.
+var allowedRoles = map[string][]string{
+	request.PUSH:         {auth.RolePublisher},
+	request.PULL:         allRoles,
+	request.FORWARDING:   allRoles,
+	request.SIGNAL:       allRoles,
+	request.FORWARDED:    allRoles,
+	request.DISCONNECTED: allRoles,
+	request.FAILED:       allRoles,
+	request.UPDATE:       allRoles,
+	request.HEALTH:       allRoles,
+	request.KICK:         {auth.RoleModerator},
+}
+
+// grant is what the credential of a client allows it in the channel. The
+// channel key grants the viewer role without limits, or the publisher role if
+// the verifier lets the key publish.
+type grant struct {
+	role   string
+	limits auth.Limits
+}
+
+// authorize checks if the role of the client allows the request type.
+func (g grant) authorize(requestType string) error {
+	roles, ok := allowedRoles[requestType]
+	if !ok {
+		return fmt.Errorf("%w type: %s", ErrInvalidRequest, requestType)
+	}
+	if !slices.Contains(roles, g.role) {
+		return fmt.Errorf("%w: %s is not allowed to %s", ErrForbidden, g.role, requestType)
+	}
+	return nil
+}
+
+// limit applies the limits of the client to the capabilities it reports. An
+// unknown upload bandwidth is taken as the max one.
+func (g grant) limit(caps database.Capabilities) database.Capabilities {
+	if max := g.limits.MaxUploadBandwidth; max > 0 && (caps.UploadBandwidth == 0 || caps.UploadBandwidth > max) {
+		caps.UploadBandwidth = max
+	}
+	if g.limits.ForwardingDisabled {
+		caps.ForwardingDisabled = true
+	}
+	return caps
+}
@@ -0,0 +1,342 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"pdn/broker"
+	"pdn/broker/subscription"
+	"pdn/database"
+	"pdn/types/client/request"
+	"pdn/types/client/response"
+	"pdn/types/message"
+	"time"
+)
+
+// maxQueuedMessages is the max number of messages queued for a client while
+// it is away. The oldest messages are dropped over it.
+const maxQueuedMessages = 256
+
+// sessionState is the state of a session.
+type sessionState int
+
+// States of a session.
+const (
+	attached sessionState = iota
+	detached
+	closed
+)
+
+// session is the state of an activated client kept across its websockets.
+// While the client is away, the messages to it are queued, and the client is
+// deactivated only if it doesn't come back within the grace period.
+type session struct {
+	channelID string
+	clientID  string
+	features  features
+	sub       *subscription.Subscription
+
+	// NOTE: The fields below are guarded by the mutex of the controller.
+	conn   *websocket.Conn
+	token  string
+	state  sessionState
+	expiry *time.Timer
+	grant  grant
+
+	// NOTE: The queue is touched by the queueing goroutine while the session
+	// is detached, and by the websocket of the client while attached.
+	queue []any
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// key returns the key of the session.
+func (s *session) key() string {
+	return s.channelID + s.clientID
+}
+
+// newToken creates a new resume token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// open opens a new session of the client. If the client left a session in its
+// grace period, the old one is closed and the client is deactivated first. If
+// another client is active with the ID, the policy of the channel decides:
+// the newcomer is rejected, the old session is closed, or the newcomer gets a
+// unique ID.
+func (c *Controller) open(conn *websocket.Conn, channelID, clientID string, enabled features, granted grant, policy string) (*session, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	old, ok := c.sessions[channelID+clientID]
+	switch {
+	case !ok:
+	case old.state == detached:
+		// NOTE: If the grace period just ended, the expiry finds the session
+		// closed and leaves it to us.
+		old.expiry.Stop()
+	case policy == database.DuplicateKick:
+	case policy == database.DuplicateUnique:
+		base := clientID
+		for ok {
+			suffix, err := newToken()
+			if err != nil {
+				c.mu.Unlock()
+				return nil, err
+			}
+			clientID = base + "-" + suffix[:8]
+			_, ok = c.sessions[channelID+clientID]
+		}
+		old = nil
+	default:
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", database.ErrClientAlreadyExists, clientID)
+	}
+	if old != nil {
+		old.state = closed
+	}
+	s := &session{
+		channelID: channelID,
+		clientID:  clientID,
+		features:  enabled,
+		conn:      conn,
+		token:     token,
+		grant:     granted,
+		sub:       c.broker.Subscribe(broker.ClientSocket, broker.Detail(channelID+clientID)),
+	}
+	c.sessions[s.key()] = s
+	kicked := old != nil && old.conn != nil
+	c.mu.Unlock()
+
+	if old != nil {
+		c.evict(old, kicked, response.CloseReplaced, "session replaced")
+	}
+	return s, nil
+}
+
+// remove closes the session of the client kicked by a moderator.
+func (c *Controller) remove(channelID, clientID string) error {
+	c.mu.Lock()
+	s, ok := c.sessions[channelID+clientID]
+	c.mu.Unlock()
+	if !ok || !c.drop(s, response.CloseKicked, "kicked by moderator") {
+		return fmt.Errorf("%w: %s", database.ErrClientNotFound, clientID)
+	}
+	return nil
+}
+
+// drop closes the session and deactivates the client, whether it is attached
+// or left in its grace period. It reports false if the session is already
+// closed.
+func (c *Controller) drop(s *session, code int, reason string) bool {
+	c.mu.Lock()
+	if s.state == closed {
+		c.mu.Unlock()
+		return false
+	}
+	if s.state == detached {
+		// NOTE: If the grace period just ended, the expiry finds the session
+		// closed and leaves it to us.
+		s.expiry.Stop()
+	}
+	s.state = closed
+	attached := s.conn != nil
+	c.mu.Unlock()
+
+	c.evict(s, attached, code, reason)
+	return true
+}
+
+// evict closes the session taken from the client and deactivates it. The
+// websocket of the attached client is closed with the code, and the queueing
+// of the detached client is stopped.
+func (c *Controller) evict(s *session, attached bool, code int, reason string) {
+	if s.stop != nil && !attached {
+		c.stopQueueing(s)
+	}
+	c.close(s)
+	c.deactivate(s)
+	if attached {
+		c.kick(s.conn, code, reason)
+	}
+}
+
+// kick closes the websocket of the client. The close frame tells the
+// client why, and the read deadline stops receiving requests from it.
+func (c *Controller) kick(conn *websocket.Conn, code int, reason string) {
+	deadline := time.Now().Add(c.config.PingInterval)
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := conn.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+		log.Printf("error occurs in sending close message %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Now()); err != nil {
+		log.Printf("error occurs in setting read deadline %v", err)
+	}
+}
+
+// resume takes back the session of the client left in its grace period, if
+// the token is the one given to the client. A new token is given for the next
+// resumption, and the session takes what the new credential grants.
+func (c *Controller) resume(conn *websocket.Conn, channelID, clientID, token string, granted grant) (*session, bool) {
+	next, err := newToken()
+	if err != nil {
+		log.Printf("error occurs in creating resume token %v", err)
+		return nil, false
+	}
+
+	c.mu.Lock()
+	s, ok := c.sessions[channelID+clientID]
+	if !ok || s.state != detached || subtle.ConstantTimeCompare([]byte(s.token), []byte(token)) != 1 {
+		c.mu.Unlock()
+		return nil, false
+	}
+	if !s.expiry.Stop() {
+		// NOTE: The grace period just ended and the session is being closed.
+		c.mu.Unlock()
+		return nil, false
+	}
+	s.state = attached
+	s.conn = conn
+	s.token = next
+	s.grant = granted
+	c.mu.Unlock()
+
+	c.stopQueueing(s)
+	return s, true
+}
+
+// release releases the session when the websocket of the client is closed.
+// A client supporting resumption is deactivated after the grace period, and
+// the messages to it are queued in the meantime. The others are deactivated
+// at once.
+func (c *Controller) release(s *session) {
+	c.mu.Lock()
+	replaced := s.state == closed
+	draining := c.draining
+	c.mu.Unlock()
+	if replaced {
+		return
+	}
+
+	if c.config.ResumeGracePeriod <= 0 || !s.features[request.FeatureResume] || draining {
+		c.discard(s)
+		c.deactivate(s)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s.state = detached
+	s.conn = nil
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go c.queue(s)
+	s.expiry = time.AfterFunc(c.config.ResumeGracePeriod, func() {
+		c.expire(s)
+	})
+}
+
+// expire deactivates the client whose grace period ended.
+func (c *Controller) expire(s *session) {
+	c.mu.Lock()
+	if s.state != detached {
+		c.mu.Unlock()
+		return
+	}
+	s.state = closed
+	c.mu.Unlock()
+
+	c.stopQueueing(s)
+	c.close(s)
+	c.deactivate(s)
+}
+
+// queue queues the messages to the client until the queueing is stopped.
+func (c *Controller) queue(s *session) {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case msg, ok := <-s.sub.Receive():
+			if !ok {
+				return
+			}
+			if len(s.queue) == maxQueuedMessages {
+				log.Printf("drop the oldest message queued for client %s in channel %s", s.clientID, s.channelID)
+				s.queue = s.queue[1:]
+			}
+			s.queue = append(s.queue, msg)
+		}
+	}
+}
+
+// stopQueueing stops queueing the messages and waits for it.
+func (c *Controller) stopQueueing(s *session) {
+	close(s.stop)
+	<-s.done
+}
+
+// discard closes the session of the client that is not activated.
+func (c *Controller) discard(s *session) {
+	c.mu.Lock()
+	s.state = closed
+	c.mu.Unlock()
+	c.close(s)
+}
+
+// token returns the current resume token of the session.
+func (c *Controller) token(s *session) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return s.token
+}
+
+// replay sends the messages queued while the client was away. The messages
+// not sent yet are kept for the next resumption.
+func (c *Controller) replay(conn *websocket.Conn, s *session) error {
+	for len(s.queue) > 0 {
+		if err := c.write(conn, s.queue[0]); err != nil {
+			return err
+		}
+		s.queue = s.queue[1:]
+	}
+	s.queue = nil
+	return nil
+}
+
+// close forgets the session and stops receiving the messages to the client.
+func (c *Controller) close(s *session) {
+	c.mu.Lock()
+	if c.sessions[s.key()] == s {
+		delete(c.sessions, s.key())
+	}
+	c.mu.Unlock()
+
+	detail := broker.Detail(s.key())
+	if err := c.broker.Unsubscribe(broker.ClientSocket, detail, s.sub); err != nil {
+		log.Printf("Error occurs in unsubscribe: %v", err)
+	}
+}
+
+// deactivate publishes that the client left.
+func (c *Controller) deactivate(s *session) {
+	if err := c.broker.Publish(broker.Client, broker.DEACTIVATE, message.Deactivate{
+		ChannelID: s.channelID,
+		ClientID:  s.clientID,
+	}); err != nil {
+		log.Printf("failed to publish left message: %v", err)
+	}
+}
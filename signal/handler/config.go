@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// AnyOrigin allows websockets from any origin.
+const AnyOrigin = "*"
+
+// Config is the configuration of upgrading HTTP requests to websockets.
+type Config struct {
+	// AllowedOrigins are the origins, e.g. "https://example.com", allowed to
+	// open websockets besides the same origin. AnyOrigin allows any.
+	AllowedOrigins []string
+
+	// MaxMessageSize is the max size in bytes of a message from the client.
+	// Zero means no limit.
+	MaxMessageSize int64
+
+	// EnableCompression negotiates permessage-deflate with the client.
+	EnableCompression bool
+
+	// Subprotocols are the subprotocols the server speaks, in the order of
+	// preference.
+	Subprotocols []string
+}
+
+// checkOrigin checks if the origin of the request is allowed. Requests without
+// the origin are not from browsers, and are allowed.
+func (c Config) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(c.AllowedOrigins, AnyOrigin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return slices.ContainsFunc(c.AllowedOrigins, func(allowed string) bool {
+		return strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
@@ -2,36 +2,58 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
 	"pdn/signal/controller"
+	"time"
 )
 
+// handshakeTimeout is the duration that the websocket handshake must be done in.
+const handshakeTimeout = 10 * time.Second
+
 // Handler wraps the gorilla/websocket connection.
 type Handler struct {
 	controller *controller.Controller
+	config     Config
+	upgrader   websocket.Upgrader
 }
 
 // New creates a new SocketHandler connection by upgrading the HTTP request.
-func New(c *controller.Controller) *Handler {
+func New(c *controller.Controller, config Config) *Handler {
 	return &Handler{
 		controller: c,
+		config:     config,
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout:  handshakeTimeout,
+			CheckOrigin:       config.checkOrigin,
+			EnableCompression: config.EnableCompression,
+			Subprotocols:      config.Subprotocols,
+		},
 	}
 }
 
 // ServeHTTP handles the HTTP request and upgrades it to websocket connection.
+// The request over the rate limits of its IP, or while the server is shutting
+// down, is rejected before the upgrade.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ug := websocket.Upgrader{
-		CheckOrigin: func(_ *http.Request) bool {
-			return true
-		},
+	if err := h.controller.Admit(r.RemoteAddr); err != nil {
+		status := http.StatusTooManyRequests
+		if errors.Is(err, controller.ErrShuttingDown) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
 	}
 
-	conn, err := ug.Upgrade(w, r, nil)
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
+	if h.config.MaxMessageSize > 0 {
+		conn.SetReadLimit(h.config.MaxMessageSize)
+	}
 
 	defer func(conn *websocket.Conn) {
 		err := conn.Close()
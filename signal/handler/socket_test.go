@@ -0,0 +1,75 @@
+package handler_test
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"pdn/auth"
+	"pdn/broker"
+	"pdn/database"
+	"pdn/database/memory"
+	"pdn/metric"
+	"pdn/signal/controller"
+	"pdn/signal/handler"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeHTTP(t *testing.T) {
+	verifier, err := auth.New(auth.Config{})
+	require.NoError(t, err)
+	m := metric.New(metric.Config{})
+	c := controller.New(controller.Config{PingInterval: time.Second, IdleTimeout: 5 * time.Second},
+		broker.New(m), memory.New(database.Config{}), m, verifier)
+	srv := httptest.NewServer(handler.New(c, handler.Config{
+		AllowedOrigins: []string{"https://allowed.example"},
+		Subprotocols:   []string{"pdn.v2", "pdn.v1"},
+	}))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	tests := []struct {
+		name        string
+		header      http.Header
+		wantErr     bool
+		subprotocol string
+	}{
+		{
+			name:   "given allowed origin when upgrade then accept",
+			header: http.Header{"Origin": {"https://allowed.example"}},
+		},
+		{
+			name:   "given no origin when upgrade then accept",
+			header: http.Header{},
+		},
+		{
+			name:    "given other origin when upgrade then reject",
+			header:  http.Header{"Origin": {"https://evil.example"}},
+			wantErr: true,
+		},
+		{
+			name:        "given subprotocols when upgrade then pick the preferred one",
+			header:      http.Header{"Sec-Websocket-Protocol": {"pdn.v1, pdn.v2"}},
+			subprotocol: "pdn.v2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, res, err := websocket.DefaultDialer.Dial(url, tt.header)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+				return
+			}
+			require.NoError(t, err)
+			defer func() {
+				_ = conn.Close()
+			}()
+			assert.Equal(t, tt.subprotocol, conn.Subprotocol())
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Package limiter provides token buckets limiting the rate of events by key,
+// e.g. connection attempts per IP or requests per client.
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sweepInterval is the interval of forgetting the buckets that are full again.
+const sweepInterval = time.Minute
+
+// ErrInvalidLimit is returned when the limit is invalid.
+var ErrInvalidLimit = errors.New("invalid rate limit")
+
+// Limit is the rate of a token bucket. Rate tokens are added per second up to
+// Burst, and every event takes a token. Zero rate disables the limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled checks if the limit is enabled.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// Validate validates the rate and the burst of the limit.
+func (l Limit) Validate() error {
+	if l.Rate < 0 {
+		return fmt.Errorf("rate must not be negative, given %g: %w", l.Rate, ErrInvalidLimit)
+	}
+	if l.Enabled() && l.Burst < 1 {
+		return fmt.Errorf("burst must be positive, given %d: %w", l.Burst, ErrInvalidLimit)
+	}
+	return nil
+}
+
+// bucket is a token bucket of a key.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter limits the rate of events by key, with a token bucket per key.
+type Limiter struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// New creates a new Limiter with the limit.
+func New(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token of the key, and reports if there was one.
+func (l *Limiter) Allow(key string, now time.Time) bool {
+	if !l.limit.Enabled() {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.fill(key, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Check reports if the key has a token, without taking it.
+func (l *Limiter) Check(key string, now time.Time) bool {
+	if !l.limit.Enabled() {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.fill(key, now).tokens >= 1
+}
+
+// fill adds the tokens since the last update to the bucket of the key.
+func (l *Limiter) fill(key string, now time.Time) *bucket {
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+		return b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*l.limit.Rate, float64(l.limit.Burst))
+		b.updated = now
+	}
+	return b
+}
+
+// sweep forgets the buckets that are full again, since they are the same as
+// new ones.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
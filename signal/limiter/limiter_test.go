@@ -0,0 +1,40 @@
+package limiter_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"pdn/signal/limiter"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Now()
+
+	t.Run("given burst when events exceed it then reject until refilled", func(t *testing.T) {
+		l := limiter.New(limiter.Limit{Rate: 2, Burst: 3})
+		for range 3 {
+			assert.True(t, l.Allow("key", now))
+		}
+		assert.False(t, l.Allow("key", now))
+		assert.False(t, l.Check("key", now))
+		assert.True(t, l.Allow("other", now))
+
+		assert.True(t, l.Allow("key", now.Add(500*time.Millisecond)))
+		assert.False(t, l.Allow("key", now.Add(500*time.Millisecond)))
+	})
+
+	t.Run("given check when token is left then not take it", func(t *testing.T) {
+		l := limiter.New(limiter.Limit{Rate: 1, Burst: 1})
+		assert.True(t, l.Check("key", now))
+		assert.True(t, l.Check("key", now))
+		assert.True(t, l.Allow("key", now))
+		assert.False(t, l.Check("key", now))
+	})
+
+	t.Run("given disabled limit when events come then allow all", func(t *testing.T) {
+		l := limiter.New(limiter.Limit{})
+		for range 100 {
+			assert.True(t, l.Allow("key", now))
+		}
+	})
+}
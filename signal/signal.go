@@ -2,9 +2,12 @@
 package signal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"pdn/auth"
 	"pdn/broker"
 	"pdn/database"
 	"pdn/metric"
@@ -15,21 +18,49 @@ import (
 
 // Signal contains the server and configuration.
 type Signal struct {
-	server *http.Server
-	conf   Config
+	server     *http.Server
+	controller *controller.Controller
+	conf       Config
 }
 
 // New creates a new instance of Signal.
-func New(config Config, db database.Database, brk *broker.Broker, m *metric.Metrics) *Signal {
-	con := controller.New(brk, db, m)
+func New(config Config, db database.Database, brk *broker.Broker, m *metric.Metrics, v *auth.Verifier) *Signal {
+	hc := handler.Config{
+		AllowedOrigins:    config.AllowedOrigins,
+		MaxMessageSize:    config.MaxMessageSize,
+		EnableCompression: config.EnableCompression,
+		Subprotocols:      config.Subprotocols,
+	}
+	writeTimeout := config.WriteTimeout
+	if config.Debug {
+		hc.AllowedOrigins = []string{handler.AnyOrigin}
+		hc.MaxMessageSize = 0
+		writeTimeout = 0
+	}
+
+	con := controller.New(controller.Config{
+		PingInterval: config.PingInterval,
+		IdleTimeout:  config.IdleTimeout,
+		WriteTimeout: writeTimeout,
+		MoveURL:      config.MoveURL,
+
+		ResumeGracePeriod: config.ResumeGracePeriod,
+
+		ConnectionLimit:  config.ConnectionLimit,
+		AuthFailureLimit: config.AuthFailureLimit,
+		RequestLimit:     config.RequestLimit,
+		JoinLimit:        config.JoinLimit,
+		MaxViolations:    config.MaxViolations,
+	}, brk, db, m, v)
 	srv := &http.Server{
 		Addr:        fmt.Sprintf(":%d", config.Port),
 		ReadTimeout: 2 * time.Second,
-		Handler:     handler.New(con),
+		Handler:     handler.New(con, hc),
 	}
 	return &Signal{
-		server: srv,
-		conf:   config,
+		server:     srv,
+		controller: con,
+		conf:       config,
 	}
 }
 
@@ -37,15 +68,26 @@ func New(config Config, db database.Database, brk *broker.Broker, m *metric.Metr
 func (s *Signal) Start() error {
 	if s.conf.CertFile == "" || s.conf.KeyFile == "" {
 		log.Printf("Starting server port on %d, without TLS", s.conf.Port)
-		if err := s.server.ListenAndServe(); err != nil {
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return fmt.Errorf("failed to start server: %w", err)
 		}
 		return nil
 	}
 
 	log.Printf("Starting server port on %d, with TLS", s.conf.Port)
-	if err := s.server.ListenAndServeTLS(s.conf.CertFile, s.conf.KeyFile); err != nil {
+	if err := s.server.ListenAndServeTLS(s.conf.CertFile, s.conf.KeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 	return nil
 }
+
+// Shutdown stops accepting sessions, tells the clients to move to another
+// server, and stops the server after they left or the context is done.
+func (s *Signal) Shutdown(ctx context.Context) error {
+	log.Printf("Shutting down server port on %d", s.conf.Port)
+	drainErr := s.controller.Drain(ctx)
+	if err := s.server.Shutdown(ctx); err != nil {
+		return errors.Join(drainErr, fmt.Errorf("failed to shutdown server: %w", err))
+	}
+	return drainErr
+}
@@ -0,0 +1,102 @@
+package simulator
+
+import (
+	"maps"
+	"pdn/broker/subscription"
+	"time"
+)
+
+// upstream is a connection that a client receives the stream through.
+type upstream struct {
+	// forwarder is the client that forwards the stream, or empty if the
+	// stream comes from the media server.
+	forwarder string
+	connected bool
+}
+
+// client is a simulated client. It behaves like a browser client: it pulls
+// the stream from the media server when it joins, follows the responses of the
+// server and pulls from the media server again when it is left without the
+// stream for a while.
+type client struct {
+	arrival
+	subscription *subscription.Subscription
+
+	// upstreams are the connections the client receives the stream through,
+	// and forwards are the connected peer connections the client forwards
+	// the stream through, by connection ID.
+	upstreams map[string]*upstream
+	forwards  map[string]bool
+
+	// gone is true after the client left or crashed.
+	gone bool
+
+	// received is true after the client received the stream once, and lostAt
+	// is when the client was left without the stream if starving is true.
+	received bool
+	starving bool
+	lostAt   time.Duration
+
+	// lastAttempt is when the client started the last attempt to get the
+	// stream, and retrying is true while the client retries to get it.
+	lastAttempt time.Duration
+	retrying    bool
+}
+
+// newClient creates a new client that is starving until its first upstream
+// is connected.
+func newClient(a arrival, sub *subscription.Subscription) *client {
+	return &client{
+		arrival:      a,
+		subscription: sub,
+		upstreams:    make(map[string]*upstream),
+		forwards:     make(map[string]bool),
+		starving:     true,
+		lostAt:       a.at,
+		lastAttempt:  a.at,
+	}
+}
+
+// key returns the key of the client in the broker.
+func (c *client) key() string {
+	return c.channelID + c.clientID
+}
+
+// receiving reports whether the client has a connected upstream.
+func (c *client) receiving() bool {
+	for _, u := range c.upstreams {
+		if u.connected {
+			return true
+		}
+	}
+	return false
+}
+
+// pending reports whether the client has an upstream being connected.
+func (c *client) pending() bool {
+	for _, u := range c.upstreams {
+		if !u.connected {
+			return true
+		}
+	}
+	return false
+}
+
+// fromServer reports whether the client receives the stream from the media server.
+func (c *client) fromServer() bool {
+	for _, u := range c.upstreams {
+		if u.connected && u.forwarder == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// peerUpstreams returns the connected upstreams from other clients.
+func (c *client) peerUpstreams() map[string]*upstream {
+	peers := maps.Clone(c.upstreams)
+	maps.DeleteFunc(peers, func(_ string, u *upstream) bool {
+		return !u.connected || u.forwarder == ""
+	})
+	return peers
+}
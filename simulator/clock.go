@@ -0,0 +1,126 @@
+package simulator
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// event is a task scheduled at a simulated time. Events at the same time run
+// in the order they were scheduled.
+type event struct {
+	at       time.Duration
+	sequence int64
+	run      func()
+}
+
+// events is a min-heap of events by time.
+type events []*event
+
+// Len returns the number of events.
+func (e events) Len() int { return len(e) }
+
+// Less reports whether the event i runs before the event j.
+func (e events) Less(i, j int) bool {
+	if e[i].at != e[j].at {
+		return e[i].at < e[j].at
+	}
+	return e[i].sequence < e[j].sequence
+}
+
+// Swap swaps the events i and j.
+func (e events) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+
+// Push adds an event.
+func (e *events) Push(x any) { *e = append(*e, x.(*event)) }
+
+// Pop removes the last event.
+func (e *events) Pop() any {
+	old := *e
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*e = old[:len(old)-1]
+	return last
+}
+
+// clock runs scheduled events in order of simulated time. The simulated time
+// runs speedup times faster than the wall clock, because the coordinator under
+// test works on the wall clock. Events run one at a time on the goroutine that
+// runs the clock, so they can share state without locks, while other
+// goroutines can schedule events at any time.
+type clock struct {
+	mu       sync.Mutex
+	queue    events
+	sequence int64
+	wake     chan struct{}
+	start    time.Time
+	speedup  float64
+}
+
+// newClock creates a new clock that starts now.
+func newClock(speedup float64) *clock {
+	return &clock{
+		wake:    make(chan struct{}, 1),
+		start:   time.Now(),
+		speedup: speedup,
+	}
+}
+
+// now returns the current simulated time.
+func (c *clock) now() time.Duration {
+	return time.Duration(float64(time.Since(c.start)) * c.speedup)
+}
+
+// wall returns the wall-clock duration of the simulated duration.
+func (c *clock) wall(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.speedup)
+}
+
+// at schedules the task at the simulated time.
+func (c *clock) at(at time.Duration, task func()) {
+	c.mu.Lock()
+	c.sequence++
+	heap.Push(&c.queue, &event{at: at, sequence: c.sequence, run: task})
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// after schedules the task after the simulated duration from now.
+func (c *clock) after(d time.Duration, task func()) {
+	c.at(c.now()+d, task)
+}
+
+// run runs the events until the simulated time reaches the end. The events
+// scheduled after the end are dropped.
+func (c *clock) run(end time.Duration) {
+	for {
+		c.mu.Lock()
+		next := end
+		if len(c.queue) > 0 {
+			next = min(c.queue[0].at, end)
+		}
+		wait := c.wall(next) - time.Since(c.start)
+		if wait > 0 {
+			c.mu.Unlock()
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-c.wake:
+				timer.Stop()
+			}
+			continue
+		}
+		if len(c.queue) == 0 || c.queue[0].at > end {
+			c.mu.Unlock()
+			return
+		}
+		e := heap.Pop(&c.queue).(*event)
+		c.mu.Unlock()
+
+		e.run()
+	}
+}
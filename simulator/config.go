@@ -0,0 +1,181 @@
+package simulator
+
+import (
+	"fmt"
+	"pdn/coordinator"
+	"pdn/database"
+	"pdn/pool"
+	"time"
+)
+
+// Default values for the simulation. If the values are not set, these values are used.
+const (
+	DefaultDuration         = 10 * time.Minute
+	DefaultSpeedup          = 20
+	DefaultChannels         = 1
+	DefaultArrivalRate      = 0.5
+	DefaultMeanLifetime     = 3 * time.Minute
+	DefaultCrashRate        = 0.1
+	DefaultUplinkNoise      = 0.3
+	DefaultMobileRate       = 0.3
+	DefaultSymmetricNATRate = 0.2
+	DefaultFailureRate      = 0.05
+	DefaultStallRate        = 0.02
+	DefaultSignalingDelay   = 300 * time.Millisecond
+	DefaultDetectionDelay   = 5 * time.Second
+	DefaultIdleTimeout      = 30 * time.Second
+	DefaultRetryDelay       = 3 * time.Second
+	DefaultSampleInterval   = time.Second
+	DefaultHealthInterval   = 10 * time.Second
+	DefaultSeed             = 1
+)
+
+// DefaultStrategies are the strategies compared when none is configured.
+var DefaultStrategies = []string{
+	pool.LeastLoaded,
+	pool.BandwidthWeighted,
+	pool.RTTAware,
+	pool.Random,
+	pool.RoundRobin,
+}
+
+// DefaultUplinks are the actual upload bandwidths in kbps that viewers are
+// drawn from. They span from viewers that can't forward a single stream to
+// viewers that can forward to many.
+var DefaultUplinks = []int{1000, 2500, 5000, 10000, 25000}
+
+// Config contains the configuration for the simulation. Durations are in
+// simulated time unless noted otherwise.
+type Config struct {
+	// Strategies are the names of the pool strategies to compare. Every
+	// strategy runs the same trace.
+	Strategies []string
+
+	// Coordinator and Policy configure the coordinator and the channels
+	// under test. The durations of the coordinator are in simulated time, and
+	// the Strategy of the coordinator is overridden by each of Strategies.
+	Coordinator coordinator.Config
+	Policy      database.Policy
+
+	// Duration is the length of the simulation, and Speedup is how many times
+	// the simulated time runs faster than the wall clock. The coordinator
+	// works on the wall clock, so a too large Speedup leaves it no time to
+	// handle the events.
+	Duration time.Duration
+	Speedup  float64
+
+	// Channels is the number of channels. Every channel has a publisher from
+	// the start to the end, and viewers arrive in ArrivalRate per second by
+	// Poisson process and stay MeanLifetime on average.
+	Channels     int
+	ArrivalRate  float64
+	MeanLifetime time.Duration
+
+	// CrashRate is the ratio of viewers that leave without deactivating. Their
+	// fetchers notice it after DetectionDelay, and the server deactivates them
+	// after IdleTimeout.
+	CrashRate      float64
+	DetectionDelay time.Duration
+	IdleTimeout    time.Duration
+
+	// Uplinks are the actual upload bandwidths in kbps that viewers are drawn
+	// from. Viewers report them with an error up to UplinkNoise, so a
+	// forwarder can be overloaded by the fetchers that its report allows.
+	Uplinks     []int
+	UplinkNoise float64
+
+	// MobileRate and SymmetricNATRate are the ratios of viewers on mobile
+	// devices and behind symmetric NAT. Two viewers behind symmetric NAT
+	// never connect to each other.
+	MobileRate       float64
+	SymmetricNATRate float64
+
+	// FailureRate and StallRate are the ratios of peer connections that fail,
+	// and that stop negotiating until the coordinator sweeps them.
+	FailureRate float64
+	StallRate   float64
+
+	// SignalingDelay is the delay of every step of a negotiation, and
+	// RetryDelay is how long a viewer without the stream waits before pulling
+	// it from the media server by itself.
+	SignalingDelay time.Duration
+	RetryDelay     time.Duration
+
+	// SampleInterval is the interval of sampling the delivery trees, and
+	// HealthInterval is the interval of viewers reporting their connections.
+	SampleInterval time.Duration
+	HealthInterval time.Duration
+
+	// Seed is the seed of the trace and the outcomes of negotiations.
+	Seed uint64
+}
+
+// Validate validates the configuration of the simulation.
+func (c Config) Validate() error {
+	if len(c.Strategies) == 0 {
+		return fmt.Errorf("at least one strategy is required")
+	}
+	for _, strategy := range c.Strategies {
+		if _, err := pool.NewStrategy(strategy); err != nil {
+			return fmt.Errorf("invalid strategy: %w", err)
+		}
+	}
+	if err := c.Policy.Validate(); err != nil {
+		return err
+	}
+	if c.Duration <= 0 || c.Speedup <= 0 {
+		return fmt.Errorf("duration and speedup must be positive")
+	}
+	if c.Channels < 1 {
+		return fmt.Errorf("channels must be positive, given %d", c.Channels)
+	}
+	if c.ArrivalRate <= 0 || c.MeanLifetime <= 0 {
+		return fmt.Errorf("arrival rate and mean lifetime must be positive")
+	}
+	for name, rate := range map[string]float64{
+		"crash rate":         c.CrashRate,
+		"uplink noise":       c.UplinkNoise,
+		"mobile rate":        c.MobileRate,
+		"symmetric nat rate": c.SymmetricNATRate,
+		"failure rate":       c.FailureRate,
+		"stall rate":         c.StallRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, given %f", name, rate)
+		}
+	}
+	if len(c.Uplinks) == 0 {
+		return fmt.Errorf("at least one uplink is required")
+	}
+	for _, uplink := range c.Uplinks {
+		if uplink <= 0 {
+			return fmt.Errorf("uplinks must be positive, given %d", uplink)
+		}
+	}
+	if c.SignalingDelay < 0 || c.DetectionDelay < 0 || c.IdleTimeout < 0 || c.RetryDelay <= 0 {
+		return fmt.Errorf("delays must not be negative, and retry delay must be positive")
+	}
+	if c.SampleInterval <= 0 || c.HealthInterval <= 0 {
+		return fmt.Errorf("sample and health intervals must be positive")
+	}
+	return nil
+}
+
+// scale converts the durations of the coordinator from simulated time to
+// wall-clock time, so the coordinator keeps up with the simulated clock.
+// MaxRTT is a threshold of reported stats, so it isn't converted.
+func scale(c coordinator.Config, speedup float64) coordinator.Config {
+	convert := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) / speedup)
+	}
+	c.SuperPeerUptime = convert(c.SuperPeerUptime)
+	c.SuperPeerInterval = convert(c.SuperPeerInterval)
+	c.RebalanceInterval = convert(c.RebalanceInterval)
+	c.RebalanceCooldown = convert(c.RebalanceCooldown)
+	c.OfferTimeout = convert(c.OfferTimeout)
+	c.AnswerTimeout = convert(c.AnswerTimeout)
+	c.ConnectTimeout = convert(c.ConnectTimeout)
+	c.SweepInterval = convert(c.SweepInterval)
+	c.FailedPairTTL = convert(c.FailedPairTTL)
+	return c
+}
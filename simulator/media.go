@@ -0,0 +1,104 @@
+package simulator
+
+import (
+	"log"
+	"pdn/broker"
+	"pdn/broker/subscription"
+	"pdn/types/message"
+)
+
+// startMedia starts the stub of the media server and returns the function to
+// stop it. The stub connects every connection after the signaling delay
+// without any WebRTC, and tells the viewers when their connections to the
+// media server are cleared.
+func (s *simulation) startMedia() func() {
+	handlers := map[broker.Detail]func(any){
+		broker.UPSTREAM:   s.handleUpstream,
+		broker.DOWNSTREAM: s.handleDownstream,
+		broker.CLEAR:      s.handleClear,
+		broker.CLOSE:      func(any) {},
+	}
+	subs := make(map[broker.Detail]*subscription.Subscription, len(handlers))
+	for detail, handler := range handlers {
+		sub := s.broker.Subscribe(broker.Media, detail)
+		subs[detail] = sub
+		go func() {
+			for event := range sub.Receive() {
+				s.clock.after(0, func() {
+					handler(event)
+				})
+			}
+		}()
+	}
+
+	return func() {
+		for detail, sub := range subs {
+			if err := s.broker.Unsubscribe(broker.Media, detail, sub); err != nil {
+				log.Printf("error occurs in unsubscribing media %v", err)
+			}
+		}
+	}
+}
+
+// handleUpstream answers and connects the stream of the publisher.
+func (s *simulation) handleUpstream(event any) {
+	up, ok := event.(message.Upstream)
+	if !ok {
+		log.Printf("error occurs in parsing upstream message %v", event)
+		return
+	}
+	s.answer(up.ConnectionID, func() bool {
+		return true
+	})
+}
+
+// handleDownstream answers and connects the stream to the viewer.
+func (s *simulation) handleDownstream(event any) {
+	down, ok := event.(message.Downstream)
+	if !ok {
+		log.Printf("error occurs in parsing downstream message %v", event)
+		return
+	}
+	s.servers[down.ConnectionID] = down.Key
+	s.answer(down.ConnectionID, func() bool {
+		if _, ok := s.servers[down.ConnectionID]; !ok {
+			return false
+		}
+		if c, ok := s.clients[down.Key]; ok {
+			s.connected(c, down.ConnectionID)
+		}
+		return true
+	})
+}
+
+// answer publishes that the media server answered the connection, and then
+// that the connection is connected after the signaling delay unless connected
+// returns false, e.g. when the connection is cleared in the meantime.
+func (s *simulation) answer(connectionID string, connected func() bool) {
+	s.clock.after(s.config.SignalingDelay, func() {
+		s.publish(broker.Media, broker.ANSWERED, message.Answered{ConnectionID: connectionID})
+		s.clock.after(s.config.SignalingDelay, func() {
+			if !connected() {
+				return
+			}
+			s.publish(broker.Media, broker.CONNECTED, message.Connected{ConnectionID: connectionID})
+		})
+	})
+}
+
+// handleClear tells the viewer that its connection to the media server is cleared.
+func (s *simulation) handleClear(event any) {
+	clr, ok := event.(message.Clear)
+	if !ok {
+		log.Printf("error occurs in parsing clear message %v", event)
+		return
+	}
+	key, ok := s.servers[clr.ConnectionID]
+	if !ok {
+		return
+	}
+	delete(s.servers, clr.ConnectionID)
+	if c, ok := s.clients[key]; ok {
+		s.drop(c, clr.ConnectionID)
+	}
+}
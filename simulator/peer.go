@@ -0,0 +1,103 @@
+package simulator
+
+import (
+	"log"
+	"pdn/broker"
+	"pdn/database"
+	"pdn/types/message"
+)
+
+// outcome is how a negotiation of a peer connection ends.
+type outcome int
+
+// Outcomes of a negotiation.
+const (
+	connect outcome = iota
+	fail
+	stall
+)
+
+// fetch starts fetching the stream from the forwarder of the connection.
+func (s *simulation) fetch(c *client, connectionID string) {
+	conn, err := s.database.FindConnectionInfoByID(connectionID)
+	if err != nil {
+		return
+	}
+	c.upstreams[connectionID] = &upstream{forwarder: conn.From}
+	c.lastAttempt = s.clock.now()
+	s.stats.pairings++
+
+	result := s.negotiate(c, conn.From)
+	if result != connect {
+		s.stats.failedPairings++
+	}
+	s.step(c, connectionID, func() {
+		// NOTE: The fetcher offers first, and then the forwarder answers, like
+		// the controller records them from the signaling between clients.
+		s.advance(connectionID, database.Offered)
+		if result == stall {
+			return
+		}
+		s.step(c, connectionID, func() {
+			s.advance(connectionID, database.Answered)
+			s.step(c, connectionID, func() {
+				s.finish(c, conn, result)
+			})
+		})
+	})
+}
+
+// negotiate draws the outcome of the negotiation between the fetcher and the
+// forwarder. A crashed forwarder never answers, and two clients behind
+// symmetric NAT never connect.
+func (s *simulation) negotiate(c *client, forwarderID string) outcome {
+	forwarder, ok := s.clients[c.channelID+forwarderID]
+	if !ok || forwarder.gone {
+		return stall
+	}
+	if c.capabilities.NATType == database.NATSymmetric && forwarder.capabilities.NATType == database.NATSymmetric {
+		return fail
+	}
+	switch r := s.rng.Float64(); {
+	case r < s.config.StallRate:
+		return stall
+	case r < s.config.StallRate+s.config.FailureRate:
+		return fail
+	default:
+		return connect
+	}
+}
+
+// step runs the task after the signaling delay, if the client still waits
+// for the connection.
+func (s *simulation) step(c *client, connectionID string, task func()) {
+	s.clock.after(s.config.SignalingDelay, func() {
+		if _, ok := c.upstreams[connectionID]; ok && !c.gone {
+			task()
+		}
+	})
+}
+
+// advance records the status of the connection like the controller does.
+func (s *simulation) advance(connectionID string, status database.Status) {
+	if _, err := s.database.UpdateConnectionInfo(connectionID, status); err != nil {
+		log.Printf("error occurs in updating connection info %v", err)
+	}
+}
+
+// finish reports the result of the negotiation. The fetcher reports it, like
+// browsers do when ICE completes or fails. If the forwarder crashed during the
+// negotiation, ICE never completes and the connection is left to the sweeper.
+func (s *simulation) finish(c *client, conn *database.ConnectionInfo, result outcome) {
+	forwarder, ok := s.clients[conn.ChannelID+conn.From]
+	if !ok || forwarder.gone {
+		return
+	}
+	if result == fail {
+		s.publish(broker.Peer, broker.FAILED, message.Failed{ConnectionID: conn.ID})
+		return
+	}
+	forwarder.forwards[conn.ID] = true
+	s.connected(c, conn.ID)
+	s.publish(broker.Peer, broker.CONNECTED, message.Connected{ConnectionID: conn.ID})
+}
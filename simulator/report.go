@@ -0,0 +1,77 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Result is the measurements of a strategy in a simulation.
+type Result struct {
+	Strategy string
+
+	// Viewers is the number of viewers that joined.
+	Viewers int
+
+	// Offload is the ratio of the viewer time fed by peers to the viewer time
+	// fed by the media server or peers.
+	Offload float64
+
+	// ServerBandwidth and PeakServerBandwidth are the mean and the peak
+	// bandwidth of the media server to viewers in Mbps.
+	ServerBandwidth     float64
+	PeakServerBandwidth float64
+
+	// MeanDepth and MaxDepth are the depth of viewers receiving the stream.
+	MeanDepth float64
+	MaxDepth  int
+
+	// Starved is the ratio of the viewer time without the stream.
+	Starved float64
+
+	// Startups are the sorted times from joining to receiving the stream, and
+	// Recoveries are the sorted times from losing the stream to receiving it
+	// again. Unrecovered is the number of viewers that left without it.
+	Startups    []time.Duration
+	Recoveries  []time.Duration
+	Unrecovered int
+
+	// Pairings is the number of peer connections that fetchers were told to
+	// make, and FailedPairings is the number of them that failed or stalled.
+	Pairings       int
+	FailedPairings int
+}
+
+// percentile returns the p-th percentile of the sorted durations, or zero if
+// there is none.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(int(p*float64(len(sorted))), len(sorted)-1)]
+}
+
+// Write writes the results as a table.
+func Write(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "STRATEGY\tVIEWERS\tOFFLOAD\tSERVER MBPS (MEAN/PEAK)\tDEPTH (MEAN/MAX)\t"+
+		"STARVED\tSTARTUP P50\tRECOVERY (N/P50/P95/MAX)\tUNRECOVERED\tFAILED PAIRINGS"); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if _, err := fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%.1f/%.1f\t%.2f/%d\t%.1f%%\t%s\t%d/%s/%s/%s\t%d\t%d/%d\n",
+			r.Strategy, r.Viewers, r.Offload*100, r.ServerBandwidth, r.PeakServerBandwidth,
+			r.MeanDepth, r.MaxDepth, r.Starved*100, round(percentile(r.Startups, 0.5)),
+			len(r.Recoveries), round(percentile(r.Recoveries, 0.5)), round(percentile(r.Recoveries, 0.95)),
+			round(percentile(r.Recoveries, 1)), r.Unrecovered, r.FailedPairings, r.Pairings); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// round rounds the duration for the table.
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Millisecond)
+}
@@ -0,0 +1,347 @@
+// Package simulator evaluates the forwarder selection strategies offline. It
+// drives the real Coordinator, Pool and memory DB through the broker with
+// synthetic traces of clients joining, leaving and crashing, while the media
+// server and the browsers are replaced by stubs.
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"pdn/broker"
+	"pdn/coordinator"
+	"pdn/database"
+	"pdn/database/memory"
+	"pdn/metric"
+	"pdn/pool"
+	"pdn/types/client/response"
+	"pdn/types/message"
+	"time"
+)
+
+var (
+	// ErrCoordinatorNotStarted is an error that occurs when the coordinator
+	// doesn't subscribe to the broker in time.
+	ErrCoordinatorNotStarted = errors.New("coordinator not started")
+)
+
+// startTimeout is how long the coordinator is waited for to subscribe.
+const startTimeout = time.Second
+
+// Run runs the trace generated from the configuration once per strategy, and
+// returns the results in the order of the strategies.
+func Run(config Config) ([]Result, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	trace := generate(config)
+	results := make([]Result, 0, len(config.Strategies))
+	for _, strategy := range config.Strategies {
+		result, err := newSimulation(config, strategy).run(trace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to simulate %s: %w", strategy, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// simulation is a run of a trace with a strategy. Its state is changed only
+// by the events of the clock.
+type simulation struct {
+	config   Config
+	strategy string
+	broker   *broker.Broker
+	metric   *metric.Metrics
+	database database.Database
+	clock    *clock
+	rng      *rand.Rand
+
+	// clients are the clients that are not deactivated yet, and servers are
+	// the keys of the clients by the ID of their connections to the media
+	// server.
+	clients map[string]*client
+	servers map[string]string
+
+	sequence int
+	stats    stats
+}
+
+// newSimulation creates a new simulation of the strategy.
+func newSimulation(config Config, strategy string) *simulation {
+	m := metric.New(metric.Config{})
+	return &simulation{
+		config:   config,
+		strategy: strategy,
+		broker:   broker.New(m),
+		metric:   m,
+		database: memory.New(database.Config{DefaultPolicy: config.Policy, AutoCreateChannels: true}),
+		rng:      rand.New(rand.NewPCG(config.Seed, 1)),
+		clients:  make(map[string]*client),
+		servers:  make(map[string]string),
+	}
+}
+
+// run runs the trace and returns the result.
+func (s *simulation) run(trace []arrival) (Result, error) {
+	strategy, err := pool.NewStrategy(s.strategy)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create strategy: %w", err)
+	}
+	config := scale(s.config.Coordinator, s.config.Speedup)
+	config.Strategy = s.strategy
+	cod := coordinator.New(config, s.broker, s.metric, s.database, pool.New(s.database, strategy))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cod.Start(ctx)
+	if err := s.waitCoordinator(); err != nil {
+		return Result{}, err
+	}
+
+	s.clock = newClock(s.config.Speedup)
+	stop := s.startMedia()
+	defer stop()
+
+	for _, a := range trace {
+		s.clock.at(a.at, func() {
+			s.join(a)
+		})
+	}
+	s.clock.at(0, s.sample)
+	s.clock.at(s.config.HealthInterval, s.reportHealth)
+	s.clock.run(s.config.Duration)
+
+	for _, c := range s.clients {
+		s.leave(c)
+		s.deactivate(c)
+	}
+	return s.stats.result(s.strategy, s.config), nil
+}
+
+// waitCoordinator waits until the coordinator subscribes to the broker. The
+// broker fails to publish a message that nobody subscribes to.
+func (s *simulation) waitCoordinator() error {
+	deadline := time.Now().Add(startTimeout)
+	for s.broker.Publish(broker.Client, broker.HEALTH, message.Health{}) != nil {
+		if time.Now().After(deadline) {
+			return ErrCoordinatorNotStarted
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+// publish publishes the message to the broker.
+func (s *simulation) publish(topic broker.Topic, detail broker.Detail, msg any) {
+	if err := s.broker.Publish(topic, detail, msg); err != nil {
+		log.Printf("error occurs in publishing %s message %v", detail, err)
+	}
+}
+
+// connectionID returns a new connection ID that the clients create.
+func (s *simulation) connectionID() string {
+	s.sequence++
+	return fmt.Sprintf("connection-%d", s.sequence)
+}
+
+// join activates the client, and pushes or pulls the stream after signaling.
+func (s *simulation) join(a arrival) {
+	sub := s.broker.Subscribe(broker.ClientSocket, broker.Detail(a.channelID+a.clientID))
+	c := newClient(a, sub)
+	s.clients[c.key()] = c
+	go s.listen(c)
+
+	// The channel is created on demand by the signal server on activation.
+	if _, err := s.database.FindOrCreateChannelInfoByID(c.channelID); err != nil {
+		log.Printf("error occurs in finding channel info %v", err)
+	}
+	s.publish(broker.Client, broker.ACTIVATE, message.Activate{
+		ChannelID:    c.channelID,
+		ClientID:     c.clientID,
+		Capabilities: c.capabilities,
+	})
+	if c.publisher {
+		s.clock.after(s.config.SignalingDelay, func() {
+			s.push(c)
+		})
+	} else {
+		s.stats.viewers++
+		s.clock.after(s.config.SignalingDelay, func() {
+			s.pull(c)
+		})
+		s.watch(c)
+	}
+	if end := a.at + a.lifetime; end < s.config.Duration {
+		s.clock.at(end, func() {
+			s.leave(c)
+		})
+	}
+}
+
+// leave makes the client leave. A crashed client doesn't deactivate, so its
+// fetchers report their connections failed when they notice it, and the server
+// deactivates the client when it times out.
+func (s *simulation) leave(c *client) {
+	if c.gone {
+		return
+	}
+	c.gone = true
+	if c.received && c.starving {
+		s.stats.unrecovered++
+	}
+	if !c.crash {
+		s.deactivate(c)
+		return
+	}
+
+	s.clock.after(s.config.DetectionDelay, func() {
+		for _, fetcher := range s.clients {
+			if fetcher.gone || fetcher.channelID != c.channelID {
+				continue
+			}
+			for id, u := range fetcher.upstreams {
+				if u.forwarder == c.clientID {
+					s.publish(broker.Peer, broker.FAILED, message.Failed{ConnectionID: id})
+				}
+			}
+		}
+	})
+	s.clock.after(s.config.IdleTimeout, func() {
+		s.deactivate(c)
+	})
+}
+
+// deactivate deactivates the client and closes its socket.
+func (s *simulation) deactivate(c *client) {
+	if _, ok := s.clients[c.key()]; !ok {
+		return
+	}
+	delete(s.clients, c.key())
+	s.publish(broker.Client, broker.DEACTIVATE, message.Deactivate{
+		ChannelID: c.channelID,
+		ClientID:  c.clientID,
+	})
+	if err := s.broker.Unsubscribe(broker.ClientSocket, broker.Detail(c.key()), c.subscription); err != nil {
+		log.Printf("error occurs in unsubscribing client socket %v", err)
+	}
+}
+
+// push pushes the stream of the publisher to the media server.
+func (s *simulation) push(c *client) {
+	if c.gone {
+		return
+	}
+	s.publish(broker.Client, broker.PUSH, message.Push{
+		ConnectionID: s.connectionID(),
+		ChannelID:    c.channelID,
+		ClientID:     c.clientID,
+	})
+}
+
+// pull pulls the stream of the viewer from the media server.
+func (s *simulation) pull(c *client) {
+	if c.gone {
+		return
+	}
+	id := s.connectionID()
+	c.upstreams[id] = &upstream{}
+	c.lastAttempt = s.clock.now()
+	s.publish(broker.Client, broker.PULL, message.Pull{
+		ConnectionID: id,
+		ChannelID:    c.channelID,
+		ClientID:     c.clientID,
+	})
+}
+
+// watch starts retrying to get the stream while the viewer is starving.
+func (s *simulation) watch(c *client) {
+	if c.retrying {
+		return
+	}
+	c.retrying = true
+	s.clock.after(s.config.RetryDelay, func() {
+		s.retry(c)
+	})
+}
+
+// retry pulls the stream from the media server again if the viewer has been
+// without the stream and any attempt to get it for RetryDelay.
+func (s *simulation) retry(c *client) {
+	if c.gone || !c.starving {
+		c.retrying = false
+		return
+	}
+	if s.clock.now()-c.lastAttempt >= s.config.RetryDelay {
+		s.pull(c)
+	}
+	s.clock.after(s.config.RetryDelay, func() {
+		s.retry(c)
+	})
+}
+
+// listen passes the responses to the client to the clock.
+func (s *simulation) listen(c *client) {
+	for res := range c.subscription.Receive() {
+		s.clock.after(0, func() {
+			s.respond(c, res)
+		})
+	}
+}
+
+// respond handles the response of the server to the client.
+func (s *simulation) respond(c *client, res any) {
+	if c.gone {
+		return
+	}
+	switch res := res.(type) {
+	case response.Forward:
+		s.fetch(c, res.ConnectionID)
+	case response.Clear:
+		s.drop(c, res.ConnectionID)
+	case response.Closed:
+		s.drop(c, res.ConnectionID)
+	case response.Fallback:
+		if !c.receiving() {
+			s.pull(c)
+		}
+	}
+}
+
+// connected marks the upstream of the client connected. If the client was
+// without the stream, the time to get it is recorded.
+func (s *simulation) connected(c *client, connectionID string) {
+	u, ok := c.upstreams[connectionID]
+	if !ok || c.gone {
+		return
+	}
+	u.connected = true
+	if !c.starving {
+		return
+	}
+	c.starving = false
+	if c.received {
+		s.stats.recoveries = append(s.stats.recoveries, s.clock.now()-c.lostAt)
+	} else {
+		s.stats.startups = append(s.stats.startups, s.clock.now()-c.at)
+	}
+	c.received = true
+}
+
+// drop removes the connection from the client. If the client is left without
+// the stream, it starts starving.
+func (s *simulation) drop(c *client, connectionID string) {
+	delete(c.forwards, connectionID)
+	if _, ok := c.upstreams[connectionID]; !ok {
+		return
+	}
+	delete(c.upstreams, connectionID)
+	if c.receiving() || c.starving {
+		return
+	}
+	c.starving = true
+	c.lostAt = s.clock.now()
+	s.watch(c)
+}
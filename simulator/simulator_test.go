@@ -0,0 +1,103 @@
+package simulator_test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pdn/coordinator"
+	"pdn/database"
+	"pdn/pool"
+	"pdn/simulator"
+	"testing"
+	"time"
+)
+
+// testConfig returns a short simulation of a busy channel with the default
+// behavior of clients.
+func testConfig() simulator.Config {
+	return simulator.Config{
+		Strategies: []string{pool.LeastLoaded, pool.BandwidthWeighted},
+		Coordinator: coordinator.Config{
+			MaxForwardingNumber: coordinator.DefaultMaxForwardingNumber,
+			MaxForwardingCap:    coordinator.DefaultMaxForwardingCap,
+			StreamBitrate:       coordinator.DefaultStreamBitrate,
+			MaxPacketLoss:       coordinator.DefaultMaxPacketLoss,
+			MaxRTT:              coordinator.DefaultMaxRTT,
+			SuperPeerCap:        coordinator.DefaultSuperPeerCap,
+			SuperPeerQuality:    coordinator.DefaultSuperPeerQuality,
+			SuperPeerInterval:   coordinator.DefaultSuperPeerInterval,
+			OfferTimeout:        coordinator.DefaultOfferTimeout,
+			AnswerTimeout:       coordinator.DefaultAnswerTimeout,
+			ConnectTimeout:      coordinator.DefaultConnectTimeout,
+			SweepInterval:       coordinator.DefaultSweepInterval,
+		},
+		Policy:           database.Policy{PeerToPeer: true, MaxDepth: database.DefaultMaxDepth},
+		Duration:         time.Minute,
+		Speedup:          60,
+		Channels:         2,
+		ArrivalRate:      1,
+		MeanLifetime:     30 * time.Second,
+		CrashRate:        simulator.DefaultCrashRate,
+		DetectionDelay:   simulator.DefaultDetectionDelay,
+		IdleTimeout:      simulator.DefaultIdleTimeout,
+		Uplinks:          simulator.DefaultUplinks,
+		UplinkNoise:      simulator.DefaultUplinkNoise,
+		MobileRate:       simulator.DefaultMobileRate,
+		SymmetricNATRate: simulator.DefaultSymmetricNATRate,
+		FailureRate:      simulator.DefaultFailureRate,
+		StallRate:        simulator.DefaultStallRate,
+		SignalingDelay:   simulator.DefaultSignalingDelay,
+		RetryDelay:       simulator.DefaultRetryDelay,
+		SampleInterval:   simulator.DefaultSampleInterval,
+		HealthInterval:   simulator.DefaultHealthInterval,
+		Seed:             simulator.DefaultSeed,
+	}
+}
+
+// TestRun tests that every strategy runs the same trace, and that viewers are
+// fed by peers only if the delivery between clients is on.
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		peerToPeer  bool
+		wantOffload bool
+	}{
+		{
+			name:        "given peer to peer on when simulated then peers offload the server",
+			peerToPeer:  true,
+			wantOffload: true,
+		},
+		{
+			name:       "given peer to peer off when simulated then server feeds all viewers",
+			peerToPeer: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.Policy.PeerToPeer = tt.peerToPeer
+
+			results, err := simulator.Run(config)
+			require.NoError(t, err)
+			require.Len(t, results, len(config.Strategies))
+			for i, result := range results {
+				assert.Equal(t, config.Strategies[i], result.Strategy)
+				assert.Equal(t, results[0].Viewers, result.Viewers)
+				assert.Positive(t, result.Viewers)
+				assert.NotEmpty(t, result.Startups)
+				if tt.wantOffload {
+					assert.Positive(t, result.Offload)
+					assert.Positive(t, result.Pairings)
+				} else {
+					assert.Zero(t, result.Offload)
+					assert.Zero(t, result.Pairings)
+				}
+			}
+
+			var output bytes.Buffer
+			require.NoError(t, simulator.Write(&output, results))
+			assert.Contains(t, output.String(), pool.BandwidthWeighted)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package simulator
+
+import (
+	"pdn/broker"
+	"pdn/database"
+	"pdn/types/message"
+	"slices"
+	"time"
+)
+
+// stats accumulates the measurements of a simulation.
+type stats struct {
+	viewers int
+
+	// viewerTime is the total time of viewers, split into the time fed by
+	// the media server, fed by peers and without the stream.
+	viewerTime  time.Duration
+	serverTime  time.Duration
+	peerTime    time.Duration
+	starvedTime time.Duration
+
+	// peakServers is the largest number of viewers fed by the media server at
+	// the same time.
+	peakServers int
+
+	depthSum     int
+	depthSamples int
+	maxDepth     int
+
+	startups    []time.Duration
+	recoveries  []time.Duration
+	unrecovered int
+
+	pairings       int
+	failedPairings int
+}
+
+// sample samples the delivery trees of all channels every SampleInterval.
+func (s *simulation) sample() {
+	interval := s.config.SampleInterval
+	servers := 0
+	for _, c := range s.clients {
+		if c.gone || c.publisher {
+			continue
+		}
+		s.stats.viewerTime += interval
+
+		depth := 1
+		switch {
+		case !c.receiving():
+			s.stats.starvedTime += interval
+			continue
+		case c.fromServer():
+			s.stats.serverTime += interval
+			servers++
+		default:
+			s.stats.peerTime += interval
+			client, err := s.database.FindClientInfoByID(c.channelID, c.clientID)
+			if err != nil || client.Depth == 0 {
+				continue
+			}
+			depth = client.Depth
+		}
+		s.stats.depthSum += depth
+		s.stats.depthSamples++
+		s.stats.maxDepth = max(s.stats.maxDepth, depth)
+	}
+	s.stats.peakServers = max(s.stats.peakServers, servers)
+
+	s.clock.after(interval, s.sample)
+}
+
+// reportHealth makes every viewer report the health of its connections every
+// HealthInterval. A forwarder whose fetchers need more than its actual upload
+// bandwidth loses packets in proportion.
+func (s *simulation) reportHealth() {
+	bitrate := s.config.Coordinator.StreamBitrate
+	for _, c := range s.clients {
+		if c.gone || c.publisher {
+			continue
+		}
+		reports := make(map[string]database.Health)
+		for id, u := range c.upstreams {
+			if !u.connected {
+				continue
+			}
+			health := database.Health{
+				RTT:        c.rtt,
+				Bitrate:    bitrate,
+				ReportedAt: time.Now(),
+			}
+			if forwarder, ok := s.clients[c.channelID+u.forwarder]; ok && u.forwarder != "" && len(forwarder.forwards) > 0 {
+				health.RTT += forwarder.rtt
+				if load := len(forwarder.forwards) * bitrate; load > forwarder.uplink {
+					health.PacketLoss = 1 - float64(forwarder.uplink)/float64(load)
+					health.Bitrate = forwarder.uplink / len(forwarder.forwards)
+				}
+			}
+			reports[id] = health
+		}
+		if len(reports) == 0 {
+			continue
+		}
+		s.publish(broker.Client, broker.HEALTH, message.Health{
+			ChannelID: c.channelID,
+			ClientID:  c.clientID,
+			Reports:   reports,
+		})
+	}
+
+	s.clock.after(s.config.HealthInterval, s.reportHealth)
+}
+
+// result summarizes the measurements.
+func (st *stats) result(strategy string, config Config) Result {
+	mbps := float64(config.Coordinator.StreamBitrate) / 1000
+	result := Result{
+		Strategy:            strategy,
+		Viewers:             st.viewers,
+		ServerBandwidth:     st.serverTime.Seconds() / config.Duration.Seconds() * mbps,
+		PeakServerBandwidth: float64(st.peakServers) * mbps,
+		MaxDepth:            st.maxDepth,
+		Startups:            slices.Sorted(slices.Values(st.startups)),
+		Recoveries:          slices.Sorted(slices.Values(st.recoveries)),
+		Unrecovered:         st.unrecovered,
+		Pairings:            st.pairings,
+		FailedPairings:      st.failedPairings,
+	}
+	if fed := st.serverTime + st.peerTime; fed > 0 {
+		result.Offload = float64(st.peerTime) / float64(fed)
+	}
+	if st.viewerTime > 0 {
+		result.Starved = float64(st.starvedTime) / float64(st.viewerTime)
+	}
+	if st.depthSamples > 0 {
+		result.MeanDepth = float64(st.depthSum) / float64(st.depthSamples)
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package simulator
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"pdn/database"
+	"slices"
+	"time"
+)
+
+// arrival is a client joining a channel in the trace.
+type arrival struct {
+	at        time.Duration
+	channelID string
+	clientID  string
+	publisher bool
+
+	// lifetime is how long the client stays, and crash is true if the client
+	// leaves without deactivating.
+	lifetime time.Duration
+	crash    bool
+
+	// uplink is the actual upload bandwidth of the client in kbps, while the
+	// capabilities have the one the client reports.
+	uplink       int
+	rtt          time.Duration
+	capabilities database.Capabilities
+}
+
+// generate generates the trace of the simulation from the seed, so every
+// strategy runs the same trace. A publisher joins every channel at the start
+// and stays to the end, and viewers arrive by Poisson process with
+// exponential lifetimes.
+func generate(config Config) []arrival {
+	rng := rand.New(rand.NewPCG(config.Seed, 0))
+
+	var trace []arrival
+	for i := range config.Channels {
+		channelID := fmt.Sprintf("channel-%d", i)
+		trace = append(trace, arrival{
+			channelID: channelID,
+			clientID:  "publisher",
+			publisher: true,
+			lifetime:  config.Duration,
+			uplink:    slices.Max(config.Uplinks),
+		})
+
+		at := time.Duration(0)
+		for n := 0; ; n++ {
+			at += seconds(rng.ExpFloat64() / config.ArrivalRate)
+			if at >= config.Duration {
+				break
+			}
+			trace = append(trace, viewer(rng, config, channelID, fmt.Sprintf("viewer-%d", n), at))
+		}
+	}
+	slices.SortStableFunc(trace, func(a, b arrival) int {
+		return cmp.Compare(a.at, b.at)
+	})
+	return trace
+}
+
+// viewer draws a viewer arriving at the given time.
+func viewer(rng *rand.Rand, config Config, channelID, clientID string, at time.Duration) arrival {
+	uplink := config.Uplinks[rng.IntN(len(config.Uplinks))]
+	rtt := 20*time.Millisecond + time.Duration(rng.Int64N(int64(180*time.Millisecond)))
+	capabilities := database.Capabilities{
+		UploadBandwidth: int(float64(uplink) * (1 + config.UplinkNoise*(2*rng.Float64()-1))),
+		RTT:             rtt,
+		DeviceClass:     database.DeviceDesktop,
+		NATType:         database.NATPortRestrictedCone,
+	}
+	if rng.Float64() < config.MobileRate {
+		capabilities.DeviceClass = database.DeviceMobile
+	}
+	if rng.Float64() < config.SymmetricNATRate {
+		capabilities.NATType = database.NATSymmetric
+	}
+	return arrival{
+		at:           at,
+		channelID:    channelID,
+		clientID:     clientID,
+		lifetime:     max(seconds(rng.ExpFloat64()*config.MeanLifetime.Seconds()), time.Second),
+		crash:        rng.Float64() < config.CrashRate,
+		uplink:       uplink,
+		rtt:          rtt,
+		capabilities: capabilities,
+	}
+}
+
+// seconds converts the seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
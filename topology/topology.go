@@ -0,0 +1,213 @@
+// Package topology builds the delivery tree of a channel from its connections
+// for debugging and dashboards.
+package topology
+
+import (
+	"cmp"
+	"fmt"
+	"pdn/database"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Roles of a node in the delivery tree.
+const (
+	RolePublisher   = "publisher"
+	RoleMediaServer = "media-server"
+	RoleViewer      = "viewer"
+)
+
+// Types of an edge in the delivery tree.
+const (
+	TypePush = "push"
+	TypePull = "pull"
+	TypePeer = "peer"
+)
+
+// Node is the media server or a client in the delivery tree.
+type Node struct {
+	ID            string  `json:"id"`
+	Role          string  `json:"role"`
+	Depth         int     `json:"depth"`
+	SuperPeer     bool    `json:"super_peer"`
+	Capacity      int     `json:"capacity"`
+	UploadQuality float64 `json:"upload_quality"`
+}
+
+// Health is the last reported stats of a connection.
+type Health struct {
+	RTT        int64     `json:"rtt_ms"`
+	Jitter     int64     `json:"jitter_ms"`
+	PacketLoss float64   `json:"packet_loss"`
+	Bitrate    int       `json:"bitrate"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// Edge is a connection that the stream flows through, from the sender to the
+// receiver.
+type Edge struct {
+	ConnectionID string    `json:"connection_id"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	Type         string    `json:"type"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"created_at"`
+	Age          int64     `json:"age_ms"`
+	Health       *Health   `json:"health,omitempty"`
+}
+
+// Topology is the delivery tree of a channel.
+type Topology struct {
+	ChannelID string `json:"channel_id"`
+	Nodes     []Node `json:"nodes"`
+	Edges     []Edge `json:"edges"`
+}
+
+// Build builds the current delivery tree of the channel: the publisher, the
+// media server, the viewers fed by the server and the viewers fed by peers.
+// Nodes are sorted by depth and edges by the depth of their receiver, so the
+// output is stable between calls.
+func Build(db database.Database, channelID string) (*Topology, error) {
+	clients, err := db.FindAllClientInfosByChannelID(channelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find clients: %w", err)
+	}
+	connections, err := db.FindAllConnectionInfosByChannelID(channelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find connections: %w", err)
+	}
+
+	publishers := make(map[string]bool)
+	for _, conn := range connections {
+		if conn.IsUpstream() {
+			publishers[conn.From] = true
+		}
+	}
+
+	t := &Topology{
+		ChannelID: channelID,
+		Nodes:     []Node{{ID: database.MediaServerID, Role: RoleMediaServer}},
+	}
+	depths := map[string]int{database.MediaServerID: 0}
+	for _, client := range clients {
+		role := RoleViewer
+		if publishers[client.ID] {
+			role = RolePublisher
+		}
+		depths[client.ID] = client.Depth
+		t.Nodes = append(t.Nodes, Node{
+			ID:            client.ID,
+			Role:          role,
+			Depth:         client.Depth,
+			SuperPeer:     client.SuperPeer,
+			Capacity:      client.Capacity,
+			UploadQuality: client.UploadQuality,
+		})
+	}
+
+	now := time.Now()
+	for _, conn := range connections {
+		edge := Edge{
+			ConnectionID: conn.ID,
+			From:         conn.From,
+			To:           conn.To,
+			Type:         typeOf(conn),
+			Status:       conn.Status.String(),
+			CreatedAt:    conn.CreatedAt,
+			Age:          now.Sub(conn.CreatedAt).Milliseconds(),
+		}
+		if !conn.Health.ReportedAt.IsZero() {
+			edge.Health = &Health{
+				RTT:        conn.Health.RTT.Milliseconds(),
+				Jitter:     conn.Health.Jitter.Milliseconds(),
+				PacketLoss: conn.Health.PacketLoss,
+				Bitrate:    conn.Health.Bitrate,
+				ReportedAt: conn.Health.ReportedAt,
+			}
+		}
+		t.Edges = append(t.Edges, edge)
+	}
+
+	slices.SortFunc(t.Nodes, func(a, b Node) int {
+		return cmp.Or(cmp.Compare(rankOf(a), rankOf(b)), cmp.Compare(a.Depth, b.Depth), cmp.Compare(a.ID, b.ID))
+	})
+	slices.SortFunc(t.Edges, func(a, b Edge) int {
+		// NOTE: The push edge goes to the media server whose depth is 0, so
+		// it is placed first.
+		return cmp.Or(cmp.Compare(depths[a.To], depths[b.To]), cmp.Compare(a.From, b.From), cmp.Compare(a.To, b.To))
+	})
+	return t, nil
+}
+
+// typeOf returns the edge type of the connection.
+func typeOf(conn *database.ConnectionInfo) string {
+	switch {
+	case conn.IsUpstream():
+		return TypePush
+	case conn.IsDownstream():
+		return TypePull
+	default:
+		return TypePeer
+	}
+}
+
+// rankOf orders the publisher and the media server before the viewers.
+func rankOf(node Node) int {
+	switch node.Role {
+	case RolePublisher:
+		return 0
+	case RoleMediaServer:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// DOT renders the topology in Graphviz DOT. Connections that are not
+// connected are dashed, and failed ones are red.
+func (t *Topology) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", quote(t.ChannelID))
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range t.Nodes {
+		label := fmt.Sprintf("%s\n%s depth %d", node.ID, node.Role, node.Depth)
+		shape := "ellipse"
+		switch {
+		case node.Role == RoleMediaServer:
+			label, shape = "media server", "box"
+		case node.Role == RolePublisher:
+			shape = "doublecircle"
+		case node.SuperPeer:
+			label += "\nsuper-peer"
+			shape = "doubleoctagon"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s];\n", quote(node.ID), quote(label), shape)
+	}
+	for _, edge := range t.Edges {
+		label := fmt.Sprintf("%s %s\n%s", edge.Type, edge.Status, (time.Duration(edge.Age) * time.Millisecond).Truncate(time.Second))
+		if edge.Health != nil {
+			label += fmt.Sprintf("\nrtt %dms loss %.1f%%", edge.Health.RTT, edge.Health.PacketLoss*100)
+		}
+		attrs := "label=" + quote(label)
+		switch edge.Status {
+		case database.Connected.String():
+		case database.Failed.String():
+			attrs += ", style=dashed, color=red"
+		default:
+			attrs += ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  %s -> %s [%s];\n", quote(edge.From), quote(edge.To), attrs)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// quote quotes the string as a DOT identifier. Newlines become line breaks of
+// the label.
+func quote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// dotEscaper escapes the characters that have a meaning in a quoted DOT string.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
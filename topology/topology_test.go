@@ -0,0 +1,61 @@
+package topology_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"pdn/database"
+	"pdn/database/memory"
+	"pdn/topology"
+	"testing"
+)
+
+// TestBuild tests that the delivery tree covers the publisher, the media
+// server, the server-fed viewers and the peer-fed viewers.
+func TestBuild(t *testing.T) {
+	const channelID = "channel"
+
+	db := memory.New(database.Config{AutoCreateChannels: true})
+	for id, depth := range map[string]int{"publisher": 0, "server-fed": 1, "peer-fed": 2} {
+		require.NoError(t, db.CreateClientInfo(channelID, id))
+		_, err := db.UpdateClientDepth(channelID, id, depth)
+		require.NoError(t, err)
+	}
+	push, err := db.CreatePushConnectionInfo(channelID, "publisher", "push")
+	require.NoError(t, err)
+	_, err = db.UpdateConnectionInfo(push.ID, database.Connected)
+	require.NoError(t, err)
+	pull, err := db.CreatePullConnectionInfo(channelID, "server-fed", "pull")
+	require.NoError(t, err)
+	_, err = db.UpdateConnectionInfo(pull.ID, database.Connected)
+	require.NoError(t, err)
+	_, err = db.CreatePeerConnectionInfo(channelID, "server-fed", "peer-fed", "peer")
+	require.NoError(t, err)
+
+	got, err := topology.Build(db, channelID)
+	require.NoError(t, err)
+
+	var nodes []string
+	for _, node := range got.Nodes {
+		nodes = append(nodes, node.ID+":"+node.Role)
+	}
+	assert.Equal(t, []string{
+		"publisher:" + topology.RolePublisher,
+		database.MediaServerID + ":" + topology.RoleMediaServer,
+		"server-fed:" + topology.RoleViewer,
+		"peer-fed:" + topology.RoleViewer,
+	}, nodes)
+
+	var edges []string
+	for _, edge := range got.Edges {
+		edges = append(edges, edge.Type+":"+edge.From+"->"+edge.To+":"+edge.Status)
+	}
+	assert.Equal(t, []string{
+		"push:publisher->" + database.MediaServerID + ":connected",
+		"pull:" + database.MediaServerID + "->server-fed:connected",
+		"peer:server-fed->peer-fed:created",
+	}, edges)
+
+	dot := got.DOT()
+	assert.Contains(t, dot, `digraph "channel" {`)
+	assert.Contains(t, dot, `"server-fed" -> "peer-fed" [label="peer created\n0s", style=dashed];`)
+}
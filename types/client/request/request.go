@@ -13,19 +13,71 @@ const (
 	FORWARDED    = "FORWARDED"
 	DISCONNECTED = "DISCONNECTED"
 	FAILED       = "FAILED"
+	UPDATE       = "UPDATE"
+	HEALTH       = "HEALTH"
+	KICK         = "KICK"
 )
 
 // Common is data type that must be implemented in all request
 type Common struct {
 	Type    string          `json:"type"`
 	Payload json.RawMessage `json:"payload"`
+
+	// RequestID is an optional ID chosen by the client. It is echoed in the
+	// ERROR or ACK response to the request.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Ack asks the server to send an ACK response when the request succeeds.
+	Ack bool `json:"ack,omitempty"`
+}
+
+// Capabilities is data type for delivery capabilities of the client. All
+// fields are optional.
+type Capabilities struct {
+	UploadBandwidth    int    `json:"upload_bandwidth"` // kbps
+	RTT                int    `json:"rtt"`              // milliseconds
+	DeviceClass        string `json:"device_class"`
+	NATType            string `json:"nat_type"`
+	ForwardingDisabled bool   `json:"forwarding_disabled"`
 }
 
-// Activate is data type for activating user
+// Features of the protocol that the client and the server negotiate in the
+// activation.
+const (
+	FeatureTrickleICE = "trickle-ice"
+	FeatureHealth     = "health"
+	FeatureError      = "error"
+	FeatureAck        = "ack"
+	FeatureResume     = "resume"
+)
+
+// Activate is data type for activating user. Clients without the version are
+// treated as version 1.
 type Activate struct {
-	ChannelID  string `json:"channel_id"`
-	ChannelKey string `json:"channel_key"`
-	ClientID   string `json:"client_id"`
+	ChannelID    string       `json:"channel_id"`
+	ChannelKey   string       `json:"channel_key"`
+	ClientID     string       `json:"client_id"`
+	Capabilities Capabilities `json:"capabilities"`
+
+	// Version is the latest protocol version the client speaks.
+	Version int `json:"version"`
+
+	// Features are the protocol features the client supports.
+	Features []string `json:"features"`
+
+	// ResumeToken is the token given in the last activation, to resume the
+	// session after the websocket was closed.
+	ResumeToken string `json:"resume_token,omitempty"`
+
+	// Token is the signed token giving the client its role in the channel. The
+	// channel key is not needed with it, and the client ID may be omitted if
+	// the token has it.
+	Token string `json:"token,omitempty"`
+}
+
+// Update is data type for updating capabilities of the client
+type Update struct {
+	Capabilities Capabilities `json:"capabilities"`
 }
 
 // Push is data type for push stream
@@ -46,6 +98,12 @@ type Forwarding struct {
 	SDP          string `json:"sdp"`
 }
 
+// Signal types that move the connection forward in its lifecycle.
+const (
+	SignalAnswer    = "answer"
+	SignalCandidate = "candidate"
+)
+
 // Signal is data type for exchanging SDP
 type Signal struct {
 	ConnectionID string `json:"connection_id"`
@@ -67,3 +125,26 @@ type Failed struct {
 type Disconnected struct {
 	ConnectionID string `json:"connection_id"`
 }
+
+// Kick is data type for moderators removing a client from the channel
+type Kick struct {
+	ClientID string `json:"client_id"`
+}
+
+// Health is data type for reporting health of the connections of the client
+type Health struct {
+	Connections []ConnectionHealth `json:"connections"`
+}
+
+// ConnectionHealth is data type for stats of a connection measured by the client
+type ConnectionHealth struct {
+	ConnectionID string  `json:"connection_id"`
+	RTT          int     `json:"rtt"`         // milliseconds
+	Jitter       int     `json:"jitter"`      // milliseconds
+	PacketLoss   float64 `json:"packet_loss"` // ratio between 0 and 1
+	Bitrate      int     `json:"bitrate"`     // kbps
+
+	// BytesReceived is the total bytes the client received through the
+	// connection since it was connected.
+	BytesReceived int64 `json:"bytes_received"`
+}
@@ -9,12 +9,67 @@ const (
 	CLOSED     = "CLOSED"
 	CLEAR      = "CLEAR"
 	SIGNAL     = "SIGNAL"
+	ROLE       = "ROLE"
+	FALLBACK   = "FALLBACK"
+	ERROR      = "ERROR"
+	ACK        = "ACK"
+	MOVE       = "MOVE"
 )
 
-// Activate is data type for activating user
+// Roles of a client in the delivery tree
+const (
+	RolePeer      = "peer"
+	RoleSuperPeer = "super-peer"
+)
+
+// Error codes that tell the client why its request failed
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeInvalidPayload     = "invalid_payload"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeChannelNotFound    = "channel_not_found"
+	CodeClientNotFound     = "client_not_found"
+	CodeClientExists       = "client_exists"
+	CodeConnectionNotFound = "connection_not_found"
+	CodeConnectionExists   = "connection_exists"
+	CodeInvalidTransition  = "invalid_transition"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeFeatureDisabled    = "feature_disabled"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal"
+)
+
+// Websocket close codes sent to a client whose session is closed by the server
+const (
+	// CloseReplaced is sent when the session is replaced by another client
+	// activating with the same ID.
+	CloseReplaced = 4000
+
+	// CloseKicked is sent when a moderator removed the client from the channel.
+	CloseKicked = 4001
+
+	// CloseRateLimited is sent when the client exceeded the rate limits
+	// repeatedly.
+	CloseRateLimited = 4002
+
+	// CloseMoved is sent after the MOVE response, when the server shuts down.
+	CloseMoved = 4003
+)
+
+// Activate is data type for activating user. It has the client ID, which the
+// server may have assigned if the requested one was in use, the protocol
+// version the server picked and the features it enabled for the client. The
+// resume token is given if resumption is enabled, and Resumed tells whether
+// the previous session of the client is resumed.
 type Activate struct {
-	Type    string `json:"type"`
-	Message string `json:"message"`
+	Type        string   `json:"type"`
+	Message     string   `json:"message"`
+	ClientID    string   `json:"client_id"`
+	Version     int      `json:"version"`
+	Features    []string `json:"features"`
+	ResumeToken string   `json:"resume_token,omitempty"`
+	Resumed     bool     `json:"resumed"`
 }
 
 // Forwarding is data type for server sent response to command user forwarding
@@ -36,6 +91,14 @@ type Closed struct {
 	ConnectionID string `json:"connection_id"`
 }
 
+// Fallback is data type for server sent response to command user pulling the
+// stream from Media server, because its forwarder left and there is no other
+// forwarder. The connection ID is the one of the lost connection.
+type Fallback struct {
+	Type         string `json:"type"`
+	ConnectionID string `json:"connection_id"`
+}
+
 // Clear is data type for server sent response to command user clearing
 type Clear struct {
 	Type         string `json:"type"`
@@ -49,3 +112,37 @@ type Signal struct {
 	SignalType   string `json:"signal_type"`
 	SignalData   string `json:"signal_data"`
 }
+
+// Role is data type for server sent response to notify the role of the client
+// in the delivery tree. The client can change its encoder and buffering
+// behavior by its role.
+type Role struct {
+	Type     string `json:"type"`
+	Role     string `json:"role"`
+	Capacity int    `json:"capacity"`
+}
+
+// Error is data type for server sent response to notify that a request of the
+// client failed. The request ID is the one of the failed request, if any.
+type Error struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// Ack is data type for server sent response to acknowledge that a request of
+// the client succeeded. It is sent only if the client asked for it.
+type Ack struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Move is data type for telling the client to move to another server, because
+// the server shuts down. The client reconnects to the URL, or to the same
+// address if it's empty, after the websocket is closed.
+type Move struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+}
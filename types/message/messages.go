@@ -1,10 +1,20 @@
 // Package message provides data types for broker message.
 package message
 
-// Activate is data type for deactivating user
+import "pdn/database"
+
+// Activate is data type for activating user
 type Activate struct {
-	ChannelID string
-	ClientID  string
+	ChannelID    string
+	ClientID     string
+	Capabilities database.Capabilities
+}
+
+// Update is data type for updating capabilities of user
+type Update struct {
+	ChannelID    string
+	ClientID     string
+	Capabilities database.Capabilities
 }
 
 // Deactivate is data type for deactivating user
@@ -44,6 +54,21 @@ type Downstream struct {
 	SDP          string
 }
 
+// Offered is data type for broker offered
+type Offered struct {
+	ConnectionID string
+}
+
+// Answered is data type for broker answered
+type Answered struct {
+	ConnectionID string
+}
+
+// Connecting is data type for broker connecting
+type Connecting struct {
+	ConnectionID string
+}
+
 // Connected is data type for broker connected
 type Connected struct {
 	ConnectionID string
@@ -68,3 +93,17 @@ type Clear struct {
 type Close struct {
 	ConnectionID string
 }
+
+// Stats is data type for the stats of a connection of Media server. Bytes is
+// the total bytes Media server sent through the connection.
+type Stats struct {
+	ConnectionID string
+	Bytes        int64
+}
+
+// Health is data type for health reports of connections
+type Health struct {
+	ChannelID string
+	ClientID  string
+	Reports   map[string]database.Health
+}